@@ -0,0 +1,257 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/ayushn2/blockchainz/types"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient account balance")
+	ErrOverflow            = errors.New("arithmetic overflow")
+	ErrImmatureCoinbase    = errors.New("coinbase not mature")
+)
+
+// safeAdd returns a + b or an error if the sum does not fit into an uint64.
+func safeAdd(a, b uint64) (uint64, error) {
+	if a > math.MaxUint64-b {
+		return 0, fmt.Errorf("%w: (%d) + (%d)", ErrOverflow, a, b)
+	}
+
+	return a + b, nil
+}
+
+// safeSub returns a - b or an error if b is larger than a.
+func safeSub(a, b uint64) (uint64, error) {
+	if b > a {
+		return 0, fmt.Errorf("%w: (%d) - (%d)", ErrOverflow, a, b)
+	}
+
+	return a - b, nil
+}
+
+// lockedBalance is a part of a balance that can't be spent before the
+// block at height until.
+type lockedBalance struct {
+	amount uint64
+	until  uint32
+}
+
+// AccountState holds the balances of the accounts and the unspent outputs
+// of transactions using inputs and outputs.
+type AccountState struct {
+	mu       sync.RWMutex
+	accounts map[types.Address]uint64
+	// locked holds the coinbase amounts of the accounts that did not mature
+	// yet, they are part of the balance but can't be spent.
+	locked map[types.Address][]lockedBalance
+	utxos  map[OutPoint]TxOutput
+	// undo collects the entries changed while a block is applied, it is
+	// nil when nothing is recorded.
+	undo *stateUndo
+}
+
+func NewAccountState() *AccountState {
+	return &AccountState{
+		accounts: make(map[types.Address]uint64),
+		locked:   make(map[types.Address][]lockedBalance),
+		utxos:    make(map[OutPoint]TxOutput),
+	}
+}
+
+func (s *AccountState) AddBalance(to types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, err := safeAdd(s.accounts[to], amount)
+	if err != nil {
+		return fmt.Errorf("cannot credit account (%s): %w", to, err)
+	}
+
+	s.saveAccount(to)
+	s.accounts[to] = balance
+
+	return nil
+}
+
+// AddLockedBalance credits the account with an amount that can't be spent
+// before the block at the given height.
+func (s *AccountState) AddLockedBalance(to types.Address, amount uint64, until uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, err := safeAdd(s.accounts[to], amount)
+	if err != nil {
+		return fmt.Errorf("cannot credit account (%s): %w", to, err)
+	}
+
+	s.saveAccount(to)
+	s.saveLocks(to)
+	s.accounts[to] = balance
+	s.locked[to] = append(s.locked[to], lockedBalance{amount: amount, until: until})
+
+	return nil
+}
+
+// Unlock makes the amounts locked until the given height spendable.
+func (s *AccountState) Unlock(height uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr, locks := range s.locked {
+		if !hasUnlocked(locks, height) {
+			continue
+		}
+
+		s.saveLocks(addr)
+		remaining := locks[:0]
+		for _, l := range locks {
+			if l.until > height {
+				remaining = append(remaining, l)
+			}
+		}
+
+		if len(remaining) == 0 {
+			delete(s.locked, addr)
+		} else {
+			s.locked[addr] = remaining
+		}
+	}
+}
+
+func hasUnlocked(locks []lockedBalance, height uint32) bool {
+	for _, l := range locks {
+		if l.until <= height {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lockedAmount returns the part of the balance of the account that can't be
+// spent yet, the caller has to hold the lock.
+func (s *AccountState) lockedAmount(addr types.Address) uint64 {
+	var amount uint64
+	for _, l := range s.locked[addr] {
+		amount += l.amount
+	}
+
+	return amount
+}
+
+func (s *AccountState) SubBalance(from types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, ok := s.accounts[from]
+	if !ok || balance < amount {
+		return fmt.Errorf("%w: account (%s) has (%d) wants (%d)", ErrInsufficientBalance, from, balance, amount)
+	}
+
+	if locked := s.lockedAmount(from); balance-locked < amount {
+		return fmt.Errorf("%w: account (%s) has (%d) of (%d) locked wants (%d)", ErrImmatureCoinbase, from, locked, balance, amount)
+	}
+
+	balance, err := safeSub(balance, amount)
+	if err != nil {
+		return fmt.Errorf("cannot debit account (%s): %w", from, err)
+	}
+
+	s.saveAccount(from)
+	s.accounts[from] = balance
+
+	return nil
+}
+
+func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
+	if err := s.SubBalance(from, amount); err != nil {
+		return err
+	}
+
+	return s.AddBalance(to, amount)
+}
+
+func (s *AccountState) GetBalance(addr types.Address) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	balance, ok := s.accounts[addr]
+	if !ok {
+		return 0, fmt.Errorf("account (%s) not found", addr)
+	}
+
+	return balance, nil
+}
+
+// subset returns a copy of the part of the state the transaction reads,
+// the accounts of its sender and recipient, the outputs it spends and the
+// outputs it creates, which fail the transaction if they already exist.
+func (s *AccountState) subset(tx *Transaction, hasher Hasher[*Transaction]) *AccountState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub := NewAccountState()
+	for _, addr := range []types.Address{tx.Sender(), tx.To} {
+		if balance, ok := s.accounts[addr]; ok {
+			sub.accounts[addr] = balance
+		}
+		if locks, ok := s.locked[addr]; ok {
+			sub.locked[addr] = append([]lockedBalance{}, locks...)
+		}
+	}
+
+	ops := make([]OutPoint, 0, len(tx.Inputs)+len(tx.Outputs))
+	for _, input := range tx.Inputs {
+		ops = append(ops, input.PrevOut)
+	}
+	for i := range tx.Outputs {
+		ops = append(ops, tx.OutPointWithHasher(hasher, uint32(i)))
+	}
+	for _, op := range ops {
+		if out, ok := s.utxos[op]; ok {
+			sub.utxos[op] = out
+		}
+	}
+
+	return sub
+}
+
+// Len returns the number of accounts.
+func (s *AccountState) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.accounts)
+}
+
+// Copy returns a deep copy of the state, changes can be made to the copy
+// and swapped in once they are known to be valid.
+func (s *AccountState) Copy() *AccountState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make(map[types.Address]uint64, len(s.accounts))
+	for addr, balance := range s.accounts {
+		accounts[addr] = balance
+	}
+
+	locked := make(map[types.Address][]lockedBalance, len(s.locked))
+	for addr, locks := range s.locked {
+		locked[addr] = append([]lockedBalance{}, locks...)
+	}
+
+	utxos := make(map[OutPoint]TxOutput, len(s.utxos))
+	for op, out := range s.utxos {
+		utxos[op] = out
+	}
+
+	return &AccountState{
+		accounts: accounts,
+		locked:   locked,
+		utxos:    utxos,
+	}
+}
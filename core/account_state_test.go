@@ -0,0 +1,71 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountStateAddOverflow(t *testing.T) {
+	state := NewAccountState()
+	addr := types.Address{0x01}
+
+	assert.Nil(t, state.AddBalance(addr, math.MaxUint64))
+	assert.ErrorIs(t, state.AddBalance(addr, 1), ErrOverflow)
+
+	balance, err := state.GetBalance(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), balance)
+}
+
+func TestAccountStateSubUnderflow(t *testing.T) {
+	state := NewAccountState()
+	addr := types.Address{0x01}
+
+	assert.Nil(t, state.AddBalance(addr, 10))
+	assert.ErrorIs(t, state.SubBalance(addr, 11), ErrInsufficientBalance)
+	assert.Nil(t, state.SubBalance(addr, 10))
+
+	balance, err := state.GetBalance(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), balance)
+}
+
+func TestSafeArithmetic(t *testing.T) {
+	sum, err := safeAdd(1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), sum)
+
+	_, err = safeAdd(math.MaxUint64, 1)
+	assert.ErrorIs(t, err, ErrOverflow)
+
+	_, err = safeSub(1, 2)
+	assert.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestAccountStateRevert(t *testing.T) {
+	state := NewAccountState()
+	a, b := types.Address{0x01}, types.Address{0x02}
+	op := OutPoint{TxHash: types.Hash{0x01}}
+
+	assert.Nil(t, state.AddBalance(a, 100))
+	assert.Nil(t, state.AddLockedBalance(a, 10, 2))
+	assert.Nil(t, state.AddOutput(op, TxOutput{To: a, Value: 5}))
+	before := state.Copy()
+
+	state.record()
+	state.Unlock(2)
+	assert.Nil(t, state.Transfer(a, b, 50))
+	assert.Nil(t, state.AddLockedBalance(b, 20, 5))
+	_, err := state.SpendOutput(op)
+	assert.Nil(t, err)
+	assert.Nil(t, state.AddOutput(OutPoint{TxHash: types.Hash{0x02}}, TxOutput{To: b, Value: 5}))
+	undo := state.stopRecording()
+
+	state.revert(undo)
+	assert.Equal(t, before.accounts, state.accounts)
+	assert.Equal(t, before.locked, state.locked)
+	assert.Equal(t, before.utxos, state.utxos)
+}
@@ -2,29 +2,43 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash"
 	"time"
 
 	"github.com/ayushn2/blockchainz/crypto"
 	"github.com/ayushn2/blockchainz/types"
 )
 
+// ErrNilHeader is returned for a block without a header, which a peer can
+// send as a block with all fields left out.
+var ErrNilHeader = errors.New("block has no header")
+
 type Header struct {
 	Version       uint32
 	DataHash      types.Hash
 	PrevBlockHash types.Hash
 	Height        uint32
 	Timestamp     int64
+	HashAlgorithm HashAlgorithm
+	// GasUsed is the gas used by the transactions of the block, so it is
+	// known from the header alone.
+	GasUsed uint64
 }
 
-func (h *Header) Bytes() []byte {
+func (h *Header) Bytes() ([]byte, error) {
+	if h == nil {
+		return nil, fmt.Errorf("failed to encode header: header is nil")
+	}
+
 	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	if err := gob.NewEncoder(buf).Encode(h); err != nil {
+		return nil, fmt.Errorf("failed to encode header: %w", err)
+	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 type Block struct {
@@ -32,6 +46,8 @@ type Block struct {
 	Transactions []*Transaction
 	Validator    crypto.PublicKey
 	Signature    *crypto.Signature
+	// SigScheme is the scheme of the signature, ECDSA when not set.
+	SigScheme crypto.SigScheme
 
 	// Cached version of the header hash
 	hash types.Hash
@@ -45,7 +61,13 @@ func NewBlock(h *Header, txx []*Transaction) (*Block, error) {
 }
 
 func NewBlockFromPrevHeader(prevHeader *Header, txx []*Transaction) (*Block, error) {
-	dataHash, err := CalculateDataHash(txx)
+	return NewBlockFromPrevHeaderWithHasher(BlockHasher{}, prevHeader, txx)
+}
+
+// NewBlockFromPrevHeaderWithHasher creates the block following prevHeader,
+// linking it by the hash the given hasher computes for prevHeader.
+func NewBlockFromPrevHeaderWithHasher(hasher Hasher[*Header], prevHeader *Header, txx []*Transaction) (*Block, error) {
+	dataHash, err := CalculateDataHashWithAlgorithm(prevHeader.HashAlgorithm, txx)
 	if err != nil {
 		return nil, err
 	}
@@ -54,8 +76,10 @@ func NewBlockFromPrevHeader(prevHeader *Header, txx []*Transaction) (*Block, err
 		Version:       1,
 		Height:        prevHeader.Height + 1,
 		DataHash:      dataHash,
-		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
+		PrevBlockHash: hasher.Hash(prevHeader),
 		Timestamp:     time.Now().UnixNano(),
+		HashAlgorithm: prevHeader.HashAlgorithm,
+		GasUsed:       CalculateGasUsed(txx),
 	}
 
 	return NewBlock(header, txx)
@@ -65,46 +89,106 @@ func (b *Block) AddTransaction(tx *Transaction) {
 	b.Transactions = append(b.Transactions, tx)
 }
 
+func (b *Block) TxCount() int {
+	return len(b.Transactions)
+}
+
+// TxAt returns the transaction at index i, an error is returned when i is
+// out of range.
+func (b *Block) TxAt(i int) (*Transaction, error) {
+	if i < 0 || i >= len(b.Transactions) {
+		return nil, fmt.Errorf("transaction index (%d) out of range => block has (%d) transactions", i, len(b.Transactions))
+	}
+
+	return b.Transactions[i], nil
+}
+
 func (b *Block) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+	data, err := b.Header.Bytes()
+	if err != nil {
+		return err
+	}
+
+	sig, err := privKey.Sign(data)
 	if err != nil {
 		return err
 	}
 
 	b.Validator = privKey.PublicKey()
 	b.Signature = sig
+	b.SigScheme = privKey.Scheme()
 
 	return nil
 }
 
 func (b *Block) Verify() error {
-	if b.Signature == nil {
-		return fmt.Errorf("block has no signature")
-	}
+	return b.VerifyWithHasher(BlockHasher{})
+}
 
-	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
-		return fmt.Errorf("block has invalid signature")
+// VerifyWithHasher verifies the block of a chain hashing its headers with
+// the given hasher, the hash names the block in the errors.
+func (b *Block) VerifyWithHasher(hasher Hasher[*Header]) error {
+	if err := b.verifySignature(); err != nil {
+		return err
 	}
 
-	for _, tx := range b.Transactions {
+	for i, tx := range b.Transactions {
+		// The coinbase is unsigned, its amount is checked by the validator.
+		if i == 0 && tx.IsCoinbase() {
+			continue
+		}
+
 		if err := tx.Verify(); err != nil {
 			return err
 		}
 	}
 
-	dataHash, err := CalculateDataHash(b.Transactions)
+	return b.verifyDataHash(hasher)
+}
+
+func (b *Block) verifySignature() error {
+	if b.Signature == nil {
+		return fmt.Errorf("block has no signature")
+	}
+
+	if b.Validator.IsZero() {
+		return fmt.Errorf("block has no validator")
+	}
+
+	data, err := b.Header.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if !b.Signature.VerifyWithScheme(b.SigScheme, b.Validator, data) {
+		return fmt.Errorf("block has invalid signature")
+	}
+
+	return nil
+}
+
+func (b *Block) verifyDataHash(hasher Hasher[*Header]) error {
+	dataHash, err := CalculateDataHashWithAlgorithm(b.HashAlgorithm, b.Transactions)
 	if err != nil {
 		return err
 	}
 	if dataHash != b.DataHash {
-		return fmt.Errorf("block (%s) has an invalid data hash", b.Hash(BlockHasher{}))
+		return fmt.Errorf("block (%s) has an invalid data hash", b.Hash(hasher))
 	}
 
 	return nil
 }
 
 func (b *Block) Decode(dec Decoder[*Block]) error {
-	return dec.Decode(b)
+	if err := dec.Decode(b); err != nil {
+		return err
+	}
+
+	if b.Header == nil {
+		return ErrNilHeader
+	}
+
+	return nil
 }
 
 func (b *Block) Encode(enc Encoder[*Block]) error {
@@ -119,16 +203,69 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 	return b.hash
 }
 
+// CalculateGasUsed returns the gas used by all transactions.
+func CalculateGasUsed(txx []*Transaction) uint64 {
+	var gas uint64
+	for _, tx := range txx {
+		gas += tx.Gas()
+	}
+
+	return gas
+}
+
 func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
-	buf := &bytes.Buffer{}
+	return CalculateDataHashWithAlgorithm(HashSHA256, txx)
+}
+
+func CalculateDataHashWithAlgorithm(alg HashAlgorithm, txx []*Transaction) (hash types.Hash, err error) {
+	h := NewDataHasher(alg)
 
 	for _, tx := range txx {
-		if err = tx.Encode(NewGobTxEncoder(buf)); err != nil {
+		if err = h.Add(tx); err != nil {
 			return
 		}
 	}
 
-	hash = sha256.Sum256(buf.Bytes())
+	hash = h.Sum()
 
 	return
 }
+
+// DataHasher computes the data hash of a block while its transactions are
+// appended, so adding a transaction doesn't encode the previous ones again.
+type DataHasher struct {
+	h hash.Hash
+}
+
+func NewDataHasher(alg HashAlgorithm) *DataHasher {
+	h := alg.New()
+	if h != nil {
+		h.Write([]byte{byte(DomainData)})
+	}
+
+	return &DataHasher{
+		h: h,
+	}
+}
+
+func (d *DataHasher) Add(tx *Transaction) error {
+	if d.h == nil {
+		return nil
+	}
+
+	return tx.Encode(NewCanonicalTxEncoder(d.h))
+}
+
+// Sum returns the data hash of the transactions added so far, more
+// transactions can be added afterwards. An unknown algorithm results in a
+// zero hash.
+func (d *DataHasher) Sum() types.Hash {
+	if d.h == nil {
+		return types.Hash{}
+	}
+
+	var sum types.Hash
+	copy(sum[:], d.h.Sum(nil))
+
+	return sum
+}
@@ -10,6 +10,16 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestBlockSigScheme(t *testing.T) {
+	b := randomBlock(t, 0, types.Hash{})
+	assert.Nil(t, b.Sign(crypto.GenerateEd25519PrivateKey()))
+	assert.Equal(t, crypto.SchemeEd25519, b.SigScheme)
+	assert.Nil(t, b.Verify())
+
+	b.SigScheme = crypto.SchemeECDSA
+	assert.NotNil(t, b.Verify())
+}
+
 func TestSignBlock(t *testing.T) {
 	privKey := crypto.GeneratePrivateKey()
 	b := randomBlock(t, 0, types.Hash{})
@@ -43,7 +53,106 @@ func TestDecodeEncode(t *testing.T) {
 	assert.Equal(t, bDecode, b)
 }
 
-func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
+func TestHeaderBytes(t *testing.T) {
+	h := &Header{
+		Version:       1,
+		PrevBlockHash: types.Hash{0x01},
+		Height:        10,
+		Timestamp:     time.Now().UnixNano(),
+	}
+
+	a, err := h.Bytes()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, a)
+
+	b, err := h.Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, a, b)
+
+	var nilHeader *Header
+	_, err = nilHeader.Bytes()
+	assert.NotNil(t, err)
+}
+
+func TestBlockWithoutHeader(t *testing.T) {
+	assert.True(t, BlockHasher{}.Hash(nil).IsZero())
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, (&Block{}).Encode(NewGobBlockEncoder(buf)))
+	decoded := new(Block)
+	assert.ErrorIs(t, decoded.Decode(NewGobBlockDecoder(buf)), ErrNilHeader)
+
+	bc := newBlockchainWithGenesis(t)
+	assert.ErrorIs(t, bc.AddBlock(&Block{}), ErrNilHeader)
+	assert.Empty(t, bc.Quarantine().Entries())
+}
+
+func TestBlockTxAt(t *testing.T) {
+	b := randomBlock(t, 0, types.Hash{})
+	tx := randomTxWithSignature(t)
+	b.AddTransaction(&tx)
+	assert.Equal(t, 2, b.TxCount())
+
+	first, err := b.TxAt(0)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Transactions[0], first)
+
+	second, err := b.TxAt(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &tx, second)
+
+	_, err = b.TxAt(2)
+	assert.NotNil(t, err)
+	_, err = b.TxAt(-1)
+	assert.NotNil(t, err)
+}
+
+func TestDataHasher(t *testing.T) {
+	txx := randomTxx(t, 10)
+
+	for _, alg := range []HashAlgorithm{HashSHA256, HashSHA3_256, HashBLAKE2b256} {
+		h := NewDataHasher(alg)
+		for i, tx := range txx {
+			assert.Nil(t, h.Add(tx))
+
+			full, err := CalculateDataHashWithAlgorithm(alg, txx[:i+1])
+			assert.Nil(t, err)
+			assert.Equal(t, full, h.Sum())
+		}
+	}
+}
+
+// The benchmarks build a block one transaction at a time and need the data
+// hash after every transaction.
+func BenchmarkDataHashFull(b *testing.B) {
+	txx := randomTxx(b, 1000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for n := 1; n <= len(txx); n++ {
+			if _, err := CalculateDataHash(txx[:n]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDataHashIncremental(b *testing.B) {
+	txx := randomTxx(b, 1000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h := NewDataHasher(HashSHA256)
+		for _, tx := range txx {
+			if err := h.Add(tx); err != nil {
+				b.Fatal(err)
+			}
+			h.Sum()
+		}
+	}
+}
+
+func randomBlock(t testing.TB, height uint32, prevBlockHash types.Hash) *Block {
 	privKey := crypto.GeneratePrivateKey()
 	tx := randomTxWithSignature(t)
 	header := &Header{
@@ -58,7 +167,30 @@ func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
 	dataHash, err := CalculateDataHash(b.Transactions)
 	assert.Nil(t, err)
 	b.Header.DataHash = dataHash
+	b.Header.GasUsed = CalculateGasUsed(b.Transactions)
 	assert.Nil(t, b.Sign(privKey))
 
 	return b
 }
+
+func TestHashDomains(t *testing.T) {
+	data := []byte("the same bytes")
+
+	for _, alg := range []HashAlgorithm{HashSHA256, HashSHA3_256, HashBLAKE2b256} {
+		header := alg.SumDomain(DomainHeader, data)
+		assert.NotEqual(t, header, alg.SumDomain(DomainTx, data))
+		assert.NotEqual(t, header, alg.SumDomain(DomainData, data))
+		assert.NotEqual(t, header, alg.Sum(data))
+		assert.Equal(t, alg.Sum(append([]byte{byte(DomainHeader)}, data...)), header)
+	}
+
+	// A header and a transaction hashed from the same bytes differ.
+	h := &Header{Version: 1, Height: 1}
+	headerBytes, err := h.Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, HashSHA256.SumDomain(DomainHeader, headerBytes), BlockHasher{}.Hash(h))
+	assert.NotEqual(t, HashSHA256.SumDomain(DomainTx, headerBytes), BlockHasher{}.Hash(h))
+
+	tx := randomTxWithSignature(t)
+	assert.Equal(t, HashSHA256.SumDomain(DomainTx, tx.signingBytes()), tx.Hash(TxHasher{}))
+}
@@ -1,96 +1,770 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
+	"github.com/ayushn2/blockchainz/types"
 	"github.com/go-kit/log"
 )
 
+// MaxBlockRange is the maximum number of blocks returned by GetBlocks.
+const MaxBlockRange = 500
+
+var ErrInvalidRange = errors.New("invalid block range")
+
 type Blockchain struct {
-	logger    log.Logger
-	store     Storage
+	logger log.Logger
+	store  Storage
+	// addLock serializes adding blocks, which covers validation, state
+	// changes and the write to the store. lock only guards the in memory
+	// chain and is held briefly, so readers don't wait on the store.
+	addLock   sync.Mutex
 	lock      sync.RWMutex
 	headers   []*Header
 	blocks    []*Block
+	// undos holds the state changes of the blocks in memory, aligned with
+	// blocks. A reorg reverts them down to the fork point, the entries of
+	// blocks deeper than maxReorgDepth are dropped.
+	undos []*stateUndo
+	// headerIndex maps the hash of every block on the chain to its header.
+	headerIndex map[types.Hash]*Header
+	// sideBlocks holds the valid blocks that are not on the main chain. A side
+	// branch that grows longer than the main chain replaces it.
+	sideBlocks map[types.Hash]*Block
+	// uncles holds the blocks that lost out at every height, they are only
+	// recorded when trackUncles is set.
+	trackUncles bool
+	uncles      map[uint32][]*Block
+	// maxReorgDepth is the maximum number of blocks a reorg may roll back,
+	// zero allows reorgs of any depth.
+	maxReorgDepth uint32
+	// headerWindow is the number of recent headers and blocks kept in
+	// memory, older ones are read from the store. Zero keeps all of them.
+	headerWindow uint32
+	// offset is the height of the first header kept in memory.
+	offset uint32
+	// txCount is the number of transactions on the main chain.
+	txCount uint64
+	// newBlockHandlers are called with every block added to the main chain.
+	newBlockHandlers []func(*Block)
+	// reorgHandlers are called after the main chain switched to another
+	// branch.
+	reorgHandlers []func(orphaned, added []*Block)
 	validator Validator
 	// TODO: make this an interface.
 	contractState *State
+	accountState  *AccountState
+	// blockReward is paid to the validator of every block through the
+	// coinbase transaction. A zero reward disables coinbase transactions.
+	blockReward uint64
+	// coinbaseMaturity is the number of blocks after which a coinbase can
+	// be spent, zero allows spending it right away.
+	coinbaseMaturity uint32
+	// medianTimeSpan is the number of blocks the median time past of a new
+	// block is taken over, zero disables the rule.
+	medianTimeSpan uint32
+	// validators are the addresses allowed to sign blocks, when nil any
+	// validator is allowed.
+	validators map[types.Address]struct{}
+	// checkpoints are the trusted hashes of the blocks at some heights, a
+	// block at a checkpoint height with another hash is rejected.
+	checkpoints map[uint32]types.Hash
+	// quarantine records the recently rejected blocks.
+	quarantine *Quarantine
+	// equivocations detects validators signing two blocks at a height.
+	equivocations *equivocationDetector
+	// blockHasher and txHasher hash the blocks and transactions of the
+	// chain, they default to BlockHasher and TxHasher.
+	blockHasher Hasher[*Header]
+	txHasher    Hasher[*Transaction]
+}
+
+// BlockchainOption configures a blockchain when it is created.
+type BlockchainOption func(*Blockchain)
+
+// WithBlockHasher makes the chain hash headers with the given hasher. The
+// genesis block is indexed when the chain is created, so the hasher can't be
+// replaced afterwards.
+func WithBlockHasher(h Hasher[*Header]) BlockchainOption {
+	return func(bc *Blockchain) {
+		bc.blockHasher = h
+	}
+}
+
+// WithTxHasher makes the chain hash transactions with the given hasher.
+// Outpoints are built from the same hash, wallets spending outputs of the
+// chain reference them with OutPointWithHasher and the chain's TxHasher.
+// Input signatures always cover the default TxHasher hash.
+func WithTxHasher(h Hasher[*Transaction]) BlockchainOption {
+	return func(bc *Blockchain) {
+		bc.txHasher = h
+	}
+}
+
+func NewBlockchain(l log.Logger, genesis *Block, opts ...BlockchainOption) (*Blockchain, error) {
+	return NewBlockchainWithStorage(l, NewMemorystore(), genesis, opts...)
 }
 
-func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
+// NewBlockchainWithStorage creates a blockchain on top of the given store.
+// If the store already holds blocks the chain is loaded from it, otherwise
+// the genesis block is added.
+func NewBlockchainWithStorage(l log.Logger, store Storage, genesis *Block, opts ...BlockchainOption) (*Blockchain, error) {
 	bc := &Blockchain{
 		contractState: NewState(),
+		accountState:  NewAccountState(),
 		headers:       []*Header{},
-		store:         NewMemorystore(),
+		headerIndex:   make(map[types.Hash]*Header),
+		sideBlocks:    make(map[types.Hash]*Block),
+		uncles:        make(map[uint32][]*Block),
+		blockHasher:   BlockHasher{},
+		txHasher:      TxHasher{},
+		store:         store,
 		logger:        l,
 	}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	bc.quarantine = NewQuarantineWithHasher(quarantineSize, bc.blockHasher)
+	bc.equivocations = newEquivocationDetector(bc.blockHasher)
 	bc.validator = NewBlockValidator(bc)
+
+	if err := validateGenesis(genesis, bc.blockHasher); err != nil {
+		return nil, err
+	}
+
+	if store.Len() > 0 {
+		return bc, bc.loadFromStore(genesis)
+	}
+
 	err := bc.addBlockWithoutValidation(genesis)
 
 	return bc, err
 }
 
+// SetValidator replaces the validator of the chain, use a ChainValidator
+// together with NewBlockValidator to add rules to the built-in ones.
 func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+// SetBlockReward enables coinbase transactions, every block then has to start
+// with a coinbase paying the reward plus the fees of the block to its
+// validator. Without a reward the fees are burned.
+func (bc *Blockchain) SetBlockReward(reward uint64) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.blockReward = reward
+}
+
+func (bc *Blockchain) BlockReward() uint64 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.blockReward
+}
+
+// SetCoinbaseMaturity sets the number of blocks a coinbase has to be buried
+// under before it can be spent. A coinbase of the block at height h can be
+// spent from height h + depth on, transactions spending it earlier are
+// rejected.
+func (bc *Blockchain) SetCoinbaseMaturity(depth uint32) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.coinbaseMaturity = depth
+}
+
+func (bc *Blockchain) CoinbaseMaturity() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.coinbaseMaturity
+}
+
+// BlockHasher returns the hasher the chain hashes headers with.
+func (bc *Blockchain) BlockHasher() Hasher[*Header] {
+	return bc.blockHasher
+}
+
+// TxHasher returns the hasher the chain hashes transactions with.
+func (bc *Blockchain) TxHasher() Hasher[*Transaction] {
+	return bc.txHasher
+}
+
+// SetValidators restricts the validators allowed to sign blocks to the given
+// addresses. Passing nil allows any validator again.
+func (bc *Blockchain) SetValidators(addrs []types.Address) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if addrs == nil {
+		bc.validators = nil
+		return
+	}
+
+	bc.validators = make(map[types.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		bc.validators[addr] = struct{}{}
+	}
+}
+
+// SetCheckpoints sets the trusted block hashes by height, blocks at these
+// heights with any other hash are rejected, so a long branch forking off
+// below a checkpoint can't replace the main chain. Passing nil removes the
+// checkpoints.
+func (bc *Blockchain) SetCheckpoints(checkpoints map[uint32]types.Hash) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.checkpoints = make(map[uint32]types.Hash, len(checkpoints))
+	for height, hash := range checkpoints {
+		bc.checkpoints[height] = hash
+	}
+}
+
+// checkpoint returns the trusted hash of the block at the given height.
+func (bc *Blockchain) checkpoint(height uint32) (types.Hash, bool) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	hash, ok := bc.checkpoints[height]
+	return hash, ok
+}
+
+// IsValidator returns true if the address is allowed to sign blocks.
+func (bc *Blockchain) IsValidator(addr types.Address) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if bc.validators == nil {
+		return true
+	}
+
+	_, ok := bc.validators[addr]
+	return ok
+}
+
+// OnNewBlock registers a function that is called with every block added to
+// the main chain, including the blocks of a branch the chain reorgs to. The
+// function is called while the block is being added, so it should not block.
+func (bc *Blockchain) OnNewBlock(fn func(*Block)) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.newBlockHandlers = append(bc.newBlockHandlers, fn)
+}
+
 func (bc *Blockchain) AddBlock(b *Block) error {
+	return bc.AddBlockFrom(b, "")
+}
+
+// AddBlockFrom adds the block like AddBlock, a rejected block is recorded in
+// the quarantine together with its source, usually the address of the peer
+// that sent it. Known blocks are not recorded.
+func (bc *Blockchain) AddBlockFrom(b *Block, source string) error {
+	if b == nil || b.Header == nil {
+		return ErrNilHeader
+	}
+
+	err := bc.addBlock(b)
+	if err != nil && !errors.Is(err, ErrBlockKnown) {
+		bc.quarantine.Add(b, source, err)
+	}
+
+	// A rejected block still counts towards an equivocation when its
+	// validator signed it. Blocks at a known height are rejected as known
+	// as well, only the same block again is left out. Signers that may not
+	// sign blocks are ignored before their signature is checked, so blocks
+	// signed by made up keys cost nothing.
+	if err == nil || (bc.IsValidator(b.Validator.Address()) && !bc.HasBlockHash(b.Hash(bc.blockHasher)) && b.verifySignature() == nil) {
+		bc.observeSigner(b)
+	}
+
+	return err
+}
+
+// Quarantine returns the recently rejected blocks.
+func (bc *Blockchain) Quarantine() *Quarantine {
+	return bc.quarantine
+}
+
+func (bc *Blockchain) addBlock(b *Block) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	// A block competing with a main chain block for the same parent forks
+	// the chain, it is kept on the side in case its branch gets longer.
+	if bc.isSideBlock(b) {
+		return bc.addSideBlock(b)
+	}
+
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
 
-
 	return bc.addBlockWithoutValidation(b)
 }
 
-func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
-	if height > bc.Height() {
-		return nil, fmt.Errorf("given height (%d) too high", height)
+func (bc *Blockchain) GetBalance(addr types.Address) (uint64, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.accountState.GetBalance(addr)
+}
+
+// GetTransaction returns the confirmed transaction with the given hash.
+func (bc *Blockchain) GetTransaction(hash types.Hash) (*Transaction, error) {
+	height, err := bc.store.GetTxIndex(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.Hash(bc.txHasher) == hash {
+			return tx, nil
+		}
 	}
 
+	return nil, fmt.Errorf("transaction (%s) not found in block (%d)", hash, height)
+}
+
+// SetHeaderWindow limits the number of recent headers and blocks kept in
+// memory, older heights are read from the store and only the blocks in the
+// window can be looked up by hash. Zero keeps the whole chain in memory.
+func (bc *Blockchain) SetHeaderWindow(window uint32) {
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
-	return bc.blocks[height], nil
+	bc.headerWindow = window
+}
+
+func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
+	bc.lock.RLock()
+	if height < bc.offset {
+		bc.lock.RUnlock()
+		return bc.store.Get(height)
+	}
+	defer bc.lock.RUnlock()
+
+	if int(height-bc.offset) >= len(bc.blocks) {
+		return nil, fmt.Errorf("given height (%d) too high", height)
+	}
+
+	return bc.blocks[height-bc.offset], nil
+}
+
+// GetBlocks returns the full blocks from height from up to and including
+// height to, read from the store. The range has to be within the chain and
+// may hold at most MaxBlockRange blocks.
+func (bc *Blockchain) GetBlocks(from, to uint32) ([]*Block, error) {
+	if from > to {
+		return nil, fmt.Errorf("%w: from (%d) is above to (%d)", ErrInvalidRange, from, to)
+	}
+
+	if n := uint64(to-from) + 1; n > MaxBlockRange {
+		return nil, fmt.Errorf("%w: (%d) blocks => maximum (%d)", ErrInvalidRange, n, MaxBlockRange)
+	}
+
+	if height := bc.Height(); to > height {
+		return nil, fmt.Errorf("%w: to (%d) => current height (%d)", ErrInvalidRange, to, height)
+	}
+
+	blocks := make([]*Block, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		b, err := bc.store.Get(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
 }
 
 func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
-	if height > bc.Height() {
+	bc.lock.RLock()
+	if height < bc.offset {
+		bc.lock.RUnlock()
+
+		if hs, ok := bc.store.(headerStore); ok {
+			return hs.GetHeader(height)
+		}
+
+		b, err := bc.store.Get(height)
+		if err != nil {
+			return nil, err
+		}
+		return b.Header, nil
+	}
+	defer bc.lock.RUnlock()
+
+	if int(height-bc.offset) >= len(bc.headers) {
 		return nil, fmt.Errorf("given height (%d) too high", height)
 	}
 
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
+	return bc.headers[height-bc.offset], nil
+}
+
+// LastHeader returns the header of the tip of the main chain.
+func (bc *Blockchain) LastHeader() *Header {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.headers[len(bc.headers)-1]
+}
+
+// HeaderByHash returns the header of the block on the main chain with the
+// given hash.
+func (bc *Blockchain) HeaderByHash(hash types.Hash) (*Header, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	header, ok := bc.headerIndex[hash]
+	if !ok {
+		return nil, fmt.Errorf("header with hash (%s) not found", hash)
+	}
 
-	return bc.headers[height], nil
+	return header, nil
 }
 
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= bc.Height()
 }
 
+// HasBlockHash returns true if the block with the given hash is on the chain.
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, ok := bc.headerIndex[hash]
+	return ok
+}
+
 // [0, 1, 2 ,3] => 4 len
 // [0, 1, 2 ,3] => 3 height
 func (bc *Blockchain) Height() uint32 {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
-	return uint32(len(bc.headers) - 1)
+	return bc.height()
+}
+
+// height returns the height of the tip, the caller has to hold the lock.
+func (bc *Blockchain) height() uint32 {
+	return bc.offset + uint32(len(bc.headers)-1)
+}
+
+// handleTransactions applies the value transfers of the given block on a
+// copy of the account state. The copy is only swapped in by the caller when
+// every transfer succeeded, so a bad block leaves the state untouched. The
+// returned undo reverts the block on the new state.
+func (bc *Blockchain) handleTransactions(b *Block) (*AccountState, *stateUndo, error) {
+	bc.lock.RLock()
+	state := bc.accountState.Copy()
+	bc.lock.RUnlock()
+
+	undo, err := bc.applyBlock(state, b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return state, undo, nil
+}
+
+// applyBlock applies the transactions of the block on the state and returns
+// the undo of the changes.
+func (bc *Blockchain) applyBlock(state *AccountState, b *Block) (*stateUndo, error) {
+	state.record()
+	err := bc.applyTransactions(state, b)
+	undo := state.stopRecording()
+
+	return undo, err
+}
+
+func (bc *Blockchain) applyTransactions(state *AccountState, b *Block) error {
+	maturity := bc.CoinbaseMaturity()
+	state.Unlock(b.Height)
+
+	order, err := applyOrder(bc.txHasher, b.Transactions)
+	if err != nil {
+		return fmt.Errorf("block (%s): %w", b.Hash(bc.blockHasher), err)
+	}
+
+	for _, i := range order {
+		tx := b.Transactions[i]
+		// The genesis block funds the initial accounts with unsigned
+		// transactions, after that only the coinbase mints new coins.
+		if tx.IsCoinbase() && (b.Height == 0 || i == 0) {
+			if b.Height > 0 && maturity > 0 {
+				if err := state.AddLockedBalance(tx.To, tx.Value, b.Height+maturity); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := state.AddBalance(tx.To, tx.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := bc.applyTransaction(state, tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyTransaction applies a transaction other than a coinbase on the state.
+func (bc *Blockchain) applyTransaction(state *AccountState, tx *Transaction) error {
+	if tx.IsUTXO() {
+		return applyUTXO(state, tx, bc.txHasher)
+	}
+
+	// Cost saturates, a sum that overflows has to be rejected here.
+	if _, err := safeAdd(tx.Value, tx.Fee); err != nil {
+		return fmt.Errorf("transaction (%s) cost: %w", tx.Hash(bc.txHasher), err)
+	}
+
+	// Outputs of a transaction without inputs are paid by the sender.
+	outputs, err := tx.outputsValue(bc.txHasher)
+	if err != nil {
+		return err
+	}
+	if outputs > 0 {
+		if err := state.SubBalance(tx.Sender(), outputs); err != nil {
+			return err
+		}
+		if err := addOutputs(state, tx, bc.txHasher); err != nil {
+			return err
+		}
+	}
+
+	cost := tx.Cost()
+	if cost == 0 {
+		return nil
+	}
+
+	if err := state.SubBalance(tx.Sender(), cost); err != nil {
+		return err
+	}
+
+	return state.AddBalance(tx.To, tx.Value)
+}
+
+// CheckTransaction reports whether the transaction can be applied on its
+// own on top of the current state, as part of the next block. Only the
+// accounts and outputs the transaction touches are copied, so the check is
+// cheap enough for every relayed transaction.
+func (bc *Blockchain) CheckTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("transaction (%s) is a coinbase", tx.Hash(bc.txHasher))
+	}
+
+	bc.lock.RLock()
+	state := bc.accountState.subset(tx, bc.txHasher)
+	height := bc.height()
+	bc.lock.RUnlock()
+
+	state.Unlock(height + 1)
+
+	return bc.applyTransaction(state, tx)
+}
+
+// FilterApplicable applies the transactions in order on a copy of the
+// current state, as part of the next block, and splits them into the ones
+// that apply and the ones that fail. A block built from the applicable
+// transactions doesn't fail on a transaction the state can't afford.
+func (bc *Blockchain) FilterApplicable(txx []*Transaction) (applicable, failed []*Transaction) {
+	bc.lock.RLock()
+	state := bc.accountState.Copy()
+	height := bc.height()
+	bc.lock.RUnlock()
+
+	state.Unlock(height + 1)
+
+	for _, tx := range txx {
+		// A failed transaction may have changed the state part way, it is
+		// only kept when it applies on a copy.
+		scratch := state.subset(tx, bc.txHasher)
+		if tx.IsCoinbase() || bc.applyTransaction(scratch, tx) != nil {
+			failed = append(failed, tx)
+			continue
+		}
+
+		if err := bc.applyTransaction(state, tx); err != nil {
+			failed = append(failed, tx)
+			continue
+		}
+		applicable = append(applicable, tx)
+	}
+
+	return applicable, failed
 }
 
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
-	bc.lock.Lock()
-	bc.headers = append(bc.headers, b.Header)
-	bc.blocks = append(bc.blocks, b)
-	bc.lock.Unlock()
+	if err := bc.appendBlock(b); err != nil {
+		return err
+	}
 
 	bc.logger.Log(
 		"msg", "new block",
-		"hash", b.Hash(BlockHasher{}),
+		"hash", b.Hash(bc.blockHasher),
 		"height", b.Height,
 		"transactions", len(b.Transactions),
 	)
 
-	return bc.store.Put(b)
+	if err := bc.store.Put(b); err != nil {
+		return err
+	}
+
+	if err := bc.indexTransactions(b); err != nil {
+		return err
+	}
+
+	bc.notifyNewBlock(b)
+
+	return nil
+}
+
+func (bc *Blockchain) notifyNewBlock(b *Block) {
+	bc.lock.RLock()
+	handlers := bc.newBlockHandlers
+	bc.lock.RUnlock()
+
+	for _, fn := range handlers {
+		fn(b)
+	}
+}
+
+// appendBlock applies the block to the state and appends it to the in memory
+// chain, it does not touch the store.
+func (bc *Blockchain) appendBlock(b *Block) error {
+	state, undo, err := bc.handleTransactions(b)
+	if err != nil {
+		return err
+	}
+
+	bc.lock.Lock()
+	bc.accountState = state
+	bc.headers = append(bc.headers, b.Header)
+	bc.blocks = append(bc.blocks, b)
+	bc.undos = append(bc.undos, undo)
+	bc.dropUndos()
+	bc.pruneSideBlocks()
+	bc.headerIndex[b.Hash(bc.blockHasher)] = b.Header
+	bc.txCount += uint64(len(b.Transactions))
+	bc.pruneHeaders()
+	bc.lock.Unlock()
+
+	return nil
+}
+
+// pruneHeaders drops the headers and blocks that fell out of the window from
+// memory. To avoid copying on every block it only prunes once twice the
+// window is held. The caller has to hold the lock.
+func (bc *Blockchain) pruneHeaders() {
+	window := int(bc.headerWindow)
+	if window == 0 || len(bc.headers) <= 2*window {
+		return
+	}
+
+	drop := len(bc.headers) - window
+	for _, b := range bc.blocks[:drop] {
+		delete(bc.headerIndex, b.Hash(bc.blockHasher))
+	}
+
+	bc.headers = append([]*Header{}, bc.headers[drop:]...)
+	bc.blocks = append([]*Block{}, bc.blocks[drop:]...)
+	bc.undos = append([]*stateUndo{}, bc.undos[drop:]...)
+	bc.offset += uint32(drop)
+}
+
+// dropUndos releases the undos of the blocks a reorg can't roll back any
+// more. The caller has to hold the lock.
+func (bc *Blockchain) dropUndos() {
+	depth := int(bc.maxReorgDepth)
+	if depth == 0 {
+		return
+	}
+
+	for i := len(bc.undos) - depth - 1; i >= 0 && bc.undos[i] != nil; i-- {
+		bc.undos[i] = nil
+	}
+}
+
+func (bc *Blockchain) indexTransactions(b *Block) error {
+	for _, tx := range b.Transactions {
+		if err := bc.store.PutTxIndex(tx.Hash(bc.txHasher), b.Height); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions removes the transactions of a block that left the
+// main chain from the index. Entries pointing at another height are kept.
+func (bc *Blockchain) unindexTransactions(b *Block) error {
+	for _, tx := range b.Transactions {
+		hash := tx.Hash(bc.txHasher)
+		if height, err := bc.store.GetTxIndex(hash); err != nil || height != b.Height {
+			continue
+		}
+
+		if err := bc.store.DeleteTxIndex(hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFromStore rebuilds the chain from the blocks in the store. The stored
+// genesis has to match the given one and transactions missing from the
+// transaction index are indexed again.
+func (bc *Blockchain) loadFromStore(genesis *Block) error {
+	stored, err := bc.store.Get(0)
+	if err != nil {
+		return err
+	}
+
+	if stored.Hash(bc.blockHasher) != genesis.Hash(bc.blockHasher) {
+		return fmt.Errorf("stored genesis (%s) does not match genesis (%s)", stored.Hash(bc.blockHasher), genesis.Hash(bc.blockHasher))
+	}
+
+	for height := uint32(0); height < bc.store.Len(); height++ {
+		b, err := bc.store.Get(height)
+		if err != nil {
+			return err
+		}
+
+		if err := bc.appendBlock(b); err != nil {
+			return err
+		}
+
+		for _, tx := range b.Transactions {
+			hash := tx.Hash(bc.txHasher)
+			if indexed, err := bc.store.GetTxIndex(hash); err == nil && indexed == height {
+				continue
+			}
+
+			if err := bc.store.PutTxIndex(hash, height); err != nil {
+				return err
+			}
+		}
+	}
+
+	bc.logger.Log("msg", "loaded chain from store", "height", bc.Height())
+
+	return nil
 }
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"crypto/sha256"
+	"math"
 	"testing"
 
+	"github.com/ayushn2/blockchainz/crypto"
 	"github.com/ayushn2/blockchainz/types"
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
@@ -28,6 +31,28 @@ func TestNewBlockchain(t *testing.T) {
 	assert.Equal(t, bc.Height(), uint32(0))
 }
 
+func TestNewBlockchainValidatesGenesis(t *testing.T) {
+	unsigned := randomBlock(t, 0, types.Hash{})
+	unsigned.Signature = nil
+	_, err := NewBlockchain(log.NewNopLogger(), unsigned)
+	assert.ErrorIs(t, err, ErrInvalidGenesis)
+
+	badDataHash := randomBlock(t, 0, types.Hash{})
+	badDataHash.Transactions = nil
+	_, err = NewBlockchain(log.NewNopLogger(), badDataHash)
+	assert.ErrorIs(t, err, ErrInvalidGenesis)
+
+	_, err = NewBlockchain(log.NewNopLogger(), randomBlock(t, 1, types.Hash{}))
+	assert.ErrorIs(t, err, ErrInvalidGenesis)
+
+	// A genesis from the config is signed by the genesis key.
+	genesis, err := (&Genesis{}).Block()
+	assert.Nil(t, err)
+	assert.Equal(t, genesisKey.PublicKey(), genesis.Validator)
+	_, err = NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+}
+
 func TestHasBlock(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 	assert.True(t, bc.HasBlock(0))
@@ -55,15 +80,456 @@ func TestAddBlockToHigh(t *testing.T) {
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))
 }
 
-func newBlockchainWithGenesis(t *testing.T) *Blockchain {
+func TestGenesisAlloc(t *testing.T) {
+	funded := crypto.GeneratePrivateKey()
+	unfunded := crypto.GeneratePrivateKey()
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	genesis := &Genesis{
+		Alloc: map[types.Address]uint64{
+			funded.PublicKey().Address(): 1000,
+		},
+	}
+	genesisBlock, err := genesis.Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesisBlock)
+	assert.Nil(t, err)
+
+	balance, err := bc.GetBalance(funded.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), balance)
+
+	tx := &Transaction{To: to, Value: 100}
+	assert.Nil(t, tx.Sign(funded))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+
+	balance, err = bc.GetBalance(funded.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(900), balance)
+
+	balance, err = bc.GetBalance(to)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+
+	tx = &Transaction{To: to, Value: 100}
+	assert.Nil(t, tx.Sign(unfunded))
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc, tx)), ErrInsufficientBalance)
+	assert.Equal(t, uint32(1), bc.Height())
+
+	balance, err = bc.GetBalance(to)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+}
+
+func TestGenesisAllocHash(t *testing.T) {
+	addr := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	a, err := (&Genesis{Alloc: map[types.Address]uint64{addr: 1000}}).Block()
+	assert.Nil(t, err)
+	b, err := (&Genesis{Alloc: map[types.Address]uint64{addr: 1000}}).Block()
+	assert.Nil(t, err)
+	c, err := (&Genesis{Alloc: map[types.Address]uint64{addr: 1001}}).Block()
+	assert.Nil(t, err)
+
+	assert.Equal(t, a.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+	assert.NotEqual(t, a.Hash(BlockHasher{}), c.Hash(BlockHasher{}))
+}
+
+func TestBlockHashAlgorithm(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	tx := randomTxWithSignature(t)
+
+	b := nextBlock(t, bc, &tx)
+	b.HashAlgorithm = HashSHA3_256
+	dataHash, err := CalculateDataHashWithAlgorithm(HashSHA3_256, b.Transactions)
+	assert.Nil(t, err)
+	b.DataHash = dataHash
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(b))
+
+	genesis, err := (&Genesis{HashAlgorithm: HashSHA3_256}).Block()
+	assert.Nil(t, err)
+	bc, err = NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	b = nextBlock(t, bc, &tx)
+	assert.Equal(t, HashSHA3_256, b.HashAlgorithm)
+	assert.Nil(t, bc.AddBlock(b))
+	data, err := b.Header.Bytes()
+	assert.Nil(t, err)
+	assert.NotEqual(t, HashSHA256.Sum(data), BlockHasher{}.Hash(b.Header))
+}
+
+func TestAddKnownBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	b := nextBlock(t, bc)
+	assert.Nil(t, bc.AddBlock(b))
+	assert.True(t, bc.HasBlockHash(b.Hash(BlockHasher{})))
+
+	// Without a signature the block would fail verification, a known block
+	// has to be rejected before it gets there.
+	b.Signature = nil
+	assert.Equal(t, ErrBlockKnown, bc.AddBlock(b))
+}
+
+func BenchmarkValidateKnownBlock(b *testing.B) {
+	bc := newBlockchainWithGenesis(b)
+	block := nextBlock(b, bc, randomTxx(b, 100)...)
+	assert.Nil(b, bc.AddBlock(block))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bc.validator.ValidateBlock(block)
+	}
+}
+
+func BenchmarkVerifyBlock(b *testing.B) {
+	bc := newBlockchainWithGenesis(b)
+	block := nextBlock(b, bc, randomTxx(b, 100)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		block.Verify()
+	}
+}
+
+func TestCoinbase(t *testing.T) {
+	funded := crypto.GeneratePrivateKey()
+	validator := crypto.GeneratePrivateKey()
+	genesis, err := (&Genesis{
+		Alloc: map[types.Address]uint64{funded.PublicKey().Address(): 1000},
+	}).Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	bc.SetBlockReward(50)
+
+	newTx := func() *Transaction {
+		tx := &Transaction{To: crypto.GeneratePrivateKey().PublicKey().Address(), Value: 100, Fee: 10}
+		assert.Nil(t, tx.Sign(funded))
+		return tx
+	}
+
+	// Missing coinbase.
+	assert.NotNil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, newTx())))
+
+	// Inflated coinbase.
+	coinbase := NewCoinbaseTransaction(validator.PublicKey().Address(), 61)
+	assert.NotNil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, newTx())))
+
+	// Coinbase paying someone else than the validator.
+	coinbase = NewCoinbaseTransaction(funded.PublicKey().Address(), 60)
+	assert.NotNil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, newTx())))
+
+	// Second coinbase.
+	coinbase = NewCoinbaseTransaction(validator.PublicKey().Address(), 60)
+	extra := NewCoinbaseTransaction(validator.PublicKey().Address(), 1)
+	assert.NotNil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, newTx(), extra)))
+	assert.Equal(t, uint32(0), bc.Height())
+
+	coinbase = NewCoinbaseTransaction(validator.PublicKey().Address(), 60)
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, newTx())))
+
+	balance, err := bc.GetBalance(validator.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(60), balance)
+
+	balance, err = bc.GetBalance(funded.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(890), balance)
+}
+
+func TestCoinbaseFeeOverflow(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetBlockReward(50)
+
+	validator := crypto.GeneratePrivateKey()
+	tx := &Transaction{To: validator.PublicKey().Address(), Fee: math.MaxUint64 - 10}
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	_, err := CoinbaseAmount(50, []*Transaction{tx})
+	assert.ErrorIs(t, err, ErrOverflow)
+
+	// The wrapped sum of the reward and the fee.
+	coinbase := NewCoinbaseTransaction(validator.PublicKey().Address(), 39)
+	err = bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, tx))
+	assert.ErrorIs(t, err, ErrOverflow)
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestCoinbaseWithoutReward(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	coinbase := NewCoinbaseTransaction(validator.PublicKey().Address(), 1000)
+	assert.NotNil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase)))
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetBlockReward(50)
+	bc.SetCoinbaseMaturity(2)
+
+	validator := crypto.GeneratePrivateKey()
+	addr := validator.PublicKey().Address()
+	spend := func() *Transaction {
+		tx := &Transaction{To: crypto.GeneratePrivateKey().PublicKey().Address(), Value: 40, Fee: 10}
+		assert.Nil(t, tx.Sign(validator))
+		return tx
+	}
+
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, NewCoinbaseTransaction(addr, 50))))
+
+	// The coinbase of height 1 matures at height 3.
+	coinbase := NewCoinbaseTransaction(addr, 60)
+	err := bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, spend()))
+	assert.ErrorIs(t, err, ErrImmatureCoinbase)
+	assert.Equal(t, uint32(1), bc.Height())
+
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, NewCoinbaseTransaction(addr, 50))))
+
+	coinbase = NewCoinbaseTransaction(addr, 60)
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, spend())))
+
+	balance, err := bc.GetBalance(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(110), balance)
+
+	// Only the coinbase of height 2 matured, the one of height 3 is locked.
+	coinbase = NewCoinbaseTransaction(addr, 70)
+	err = bc.AddBlock(nextBlockSignedBy(t, bc, validator, coinbase, spend(), spend()))
+	assert.ErrorIs(t, err, ErrImmatureCoinbase)
+}
+
+func BenchmarkGetHeaderWhileAddingBlocks(b *testing.B) {
+	genesis := randomBlock(b, 0, types.Hash{})
+	source, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(b, err)
+
+	blocks := make([]*Block, 200)
+	for i := range blocks {
+		blocks[i] = nextBlock(b, source)
+		assert.Nil(b, source.AddBlock(blocks[i]))
+	}
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(b, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, block := range blocks {
+			bc.AddBlock(block)
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bc.GetHeader(bc.Height())
+		}
+	})
+	b.StopTimer()
+
+	<-done
+}
+
+// countingHasher hashes headers differently from BlockHasher and counts how
+// often it is called.
+type countingHasher struct {
+	calls int
+}
+
+func (h *countingHasher) Hash(header *Header) types.Hash {
+	h.calls++
+	hash := BlockHasher{}.Hash(header)
+	return sha256.Sum256(hash[:])
+}
+
+func TestBlockchainWithBlockHasher(t *testing.T) {
+	hasher := &countingHasher{}
+	bc, err := NewBlockchain(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), WithBlockHasher(hasher))
+	assert.Nil(t, err)
+	assert.Equal(t, hasher, bc.BlockHasher())
+
+	// A block linked by the default hash doesn't extend this chain.
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc)))
+
+	b, err := NewBlockFromPrevHeaderWithHasher(hasher, bc.LastHeader(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	calls := hasher.calls
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Greater(t, hasher.calls, calls)
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.True(t, bc.HasBlockHash(hasher.Hash(b.Header)))
+	assert.False(t, bc.HasBlockHash(BlockHasher{}.Hash(b.Header)))
+}
+
+func newBlockchainWithGenesis(t testing.TB) *Blockchain {
 	bc, err := NewBlockchain(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}))
 	assert.Nil(t, err)
 
 	return bc
 }
 
-func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
+func getPrevBlockHash(t testing.TB, bc *Blockchain, height uint32) types.Hash {
 	prevHeader, err := bc.GetHeader(height - 1)
 	assert.Nil(t, err)
 	return BlockHasher{}.Hash(prevHeader)
 }
+
+// nextBlock returns a signed block with the given transactions on top of
+// the current tip of the chain.
+func nextBlock(t testing.TB, bc *Blockchain, txx ...*Transaction) *Block {
+	return nextBlockSignedBy(t, bc, crypto.GeneratePrivateKey(), txx...)
+}
+
+func nextBlockSignedBy(t testing.TB, bc *Blockchain, privKey crypto.PrivateKey, txx ...*Transaction) *Block {
+	b, err := NewBlockFromPrevHeader(bc.LastHeader(), txx)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func randomTxx(t testing.TB, n int) []*Transaction {
+	txx := make([]*Transaction, n)
+	for i := 0; i < n; i++ {
+		tx := randomTxWithSignature(t)
+		txx[i] = &tx
+	}
+
+	return txx
+}
+
+func TestGetBlocks(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	blocks, err := bc.GetBlocks(2, 5)
+	assert.Nil(t, err)
+	if assert.Len(t, blocks, 4) {
+		for i, b := range blocks {
+			assert.Equal(t, uint32(2+i), b.Height)
+
+			want, err := bc.GetBlock(b.Height)
+			assert.Nil(t, err)
+			assert.Equal(t, want.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+		}
+	}
+
+	blocks, err = bc.GetBlocks(0, bc.Height())
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 11)
+
+	_, err = bc.GetBlocks(5, 2)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	_, err = bc.GetBlocks(0, MaxBlockRange)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	_, err = bc.GetBlocks(0, math.MaxUint32)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	_, err = bc.GetBlocks(5, 11)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
+
+func TestLastHeader(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis, bc.LastHeader())
+
+	b := nextBlock(t, bc)
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, b.Header, bc.LastHeader())
+}
+
+func TestHeaderByHash(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	b := nextBlock(t, bc)
+	assert.Nil(t, bc.AddBlock(b))
+
+	header, err := bc.HeaderByHash(b.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, b.Header, header)
+
+	_, err = bc.HeaderByHash(types.Hash{})
+	assert.NotNil(t, err)
+}
+
+func TestAddOtherGenesis(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, bc.AddBlock(genesis), ErrBlockKnown)
+
+	assert.ErrorIs(t, bc.AddBlock(randomBlock(t, 0, types.Hash{})), ErrGenesisMismatch)
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestHeaderWindow(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetHeaderWindow(10)
+
+	lenBlocks := 50
+	for i := 0; i < lenBlocks; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	assert.Equal(t, uint32(lenBlocks), bc.Height())
+	assert.LessOrEqual(t, len(bc.headers), 20)
+	assert.Equal(t, len(bc.headers), len(bc.blocks))
+
+	stored, err := bc.store.Get(5)
+	assert.Nil(t, err)
+
+	header, err := bc.GetHeader(5)
+	assert.Nil(t, err)
+	assert.Equal(t, stored.Hash(BlockHasher{}), BlockHasher{}.Hash(header))
+
+	block, err := bc.GetBlock(5)
+	assert.Nil(t, err)
+	assert.Equal(t, stored.Hash(BlockHasher{}), block.Hash(BlockHasher{}))
+
+	_, err = bc.GetHeader(uint32(lenBlocks + 1))
+	assert.NotNil(t, err)
+}
+
+func TestAuthorizedValidators(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	authorized := crypto.GeneratePrivateKey()
+	bc.SetValidators([]types.Address{authorized.PublicKey().Address()})
+
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc)), ErrUnauthorizedValidator)
+	assert.Equal(t, uint32(0), bc.Height())
+
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, authorized)))
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// Without a validator set any validator is allowed.
+	bc.SetValidators(nil)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+}
+
+func TestCheckpoints(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	trusted := randomBlock(t, 1, genesisHash)
+	bc.SetCheckpoints(map[uint32]types.Hash{1: trusted.Hash(BlockHasher{})})
+
+	assert.ErrorIs(t, bc.AddBlock(randomBlock(t, 1, genesisHash)), ErrCheckpointMismatch)
+	assert.Equal(t, uint32(0), bc.Height())
+
+	assert.Nil(t, bc.AddBlock(trusted))
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// A side branch conflicting with the checkpoint is rejected as well.
+	assert.ErrorIs(t, bc.AddBlock(randomBlock(t, 1, genesisHash)), ErrCheckpointMismatch)
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 2, BlockHasher{}.Hash(trusted.Header))))
+}
@@ -0,0 +1,338 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ayushn2/blockchainz/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	blocksBucket  = []byte("blocks")
+	txIndexBucket = []byte("txindex")
+)
+
+// compactTxSize is the number of bytes copied per transaction while
+// compacting.
+const compactTxSize = 64 << 20
+
+// BoltStorage persists blocks and the transaction index in a bolt database
+// so a node can be restarted without losing its chain.
+type BoltStorage struct {
+	path string
+
+	// mu guards db, it is only locked exclusively to swap in the compacted
+	// database.
+	mu sync.RWMutex
+	db *bolt.DB
+	// writeLock serializes the writes with compaction, so no write is lost
+	// while the database is copied.
+	writeLock sync.Mutex
+
+	compactLock sync.Mutex
+	stopCompact chan struct{}
+	// compactDone is closed once the compaction goroutine returned.
+	compactDone chan struct{}
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	// A compaction interrupted by a crash leaves the copy behind, the
+	// database itself is only replaced once the copy is complete.
+	if err := os.Remove(compactPath(path)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{blocksBucket, txIndexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{
+		path: path,
+		db:   db,
+	}, nil
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+}
+
+// compactPath returns the path the database is compacted into.
+func compactPath(path string) string {
+	return path + ".compact"
+}
+
+func (s *BoltStorage) Put(b *Block) error {
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewGobBlockEncoder(buf)); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(blocksBucket)
+		if n := blockCount(bucket); n != b.Height {
+			return fmt.Errorf("cannot store block with height (%d) => store has (%d) blocks", b.Height, n)
+		}
+
+		return bucket.Put(heightKey(b.Height), buf.Bytes())
+	})
+}
+
+func (s *BoltStorage) Get(height uint32) (*Block, error) {
+	var data []byte
+	s.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(blocksBucket).Get(heightKey(height)); v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+
+	if data == nil {
+		return nil, fmt.Errorf("block with height (%d) not found", height)
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewGobBlockDecoder(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *BoltStorage) Len() uint32 {
+	var n uint32
+	s.view(func(tx *bolt.Tx) error {
+		n = blockCount(tx.Bucket(blocksBucket))
+		return nil
+	})
+
+	return n
+}
+
+func (s *BoltStorage) Truncate(height uint32) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(blocksBucket)
+
+		// Deleting while moving the cursor skips keys, collect them first.
+		var keys [][]byte
+		c := bucket.Cursor()
+		for k, _ := c.Seek(heightKey(height + 1)); k != nil; k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) PutTxIndex(hash types.Hash, height uint32) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(txIndexBucket).Put(hash.ToSlice(), heightKey(height))
+	})
+}
+
+func (s *BoltStorage) DeleteTxIndex(hash types.Hash) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(txIndexBucket).Delete(hash.ToSlice())
+	})
+}
+
+func (s *BoltStorage) GetTxIndex(hash types.Hash) (uint32, error) {
+	var (
+		height uint32
+		found  bool
+	)
+	s.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(txIndexBucket).Get(hash.ToSlice()); v != nil {
+			height = binary.BigEndian.Uint32(v)
+			found = true
+		}
+		return nil
+	})
+
+	if !found {
+		return 0, fmt.Errorf("transaction (%s) not found", hash)
+	}
+
+	return height, nil
+}
+
+// Size returns the size of the database in bytes.
+func (s *BoltStorage) Size() int64 {
+	var size int64
+	s.view(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+
+	return size
+}
+
+// Compact rewrites the database into a new file without the free pages
+// left behind by deleted blocks and replaces the database with it. Reads
+// go on while the blocks are copied, writes wait until the compacted
+// database is in place. The database is replaced by a rename, a crash
+// during compaction leaves it untouched.
+func (s *BoltStorage) Compact() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	tmp := compactPath(s.path)
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := openBolt(tmp)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	err = bolt.Compact(dst, s.db, compactTxSize)
+	s.mu.RUnlock()
+
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot compact (%s): %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+
+	if rerr := os.Rename(tmp, s.path); rerr != nil {
+		os.Remove(tmp)
+		// Carry on with the database as it was.
+		if s.db, err = openBolt(s.path); err != nil {
+			return err
+		}
+		return fmt.Errorf("cannot replace (%s) with compacted database: %w", s.path, rerr)
+	}
+
+	s.db, err = openBolt(s.path)
+
+	return err
+}
+
+// SetCompactionInterval compacts the database every interval, errors are
+// passed to onError when it is set. A zero interval stops compacting. A
+// running compaction is waited for, neither Compact nor onError run after
+// compacting was stopped.
+func (s *BoltStorage) SetCompactionInterval(interval time.Duration, onError func(error)) {
+	s.compactLock.Lock()
+	defer s.compactLock.Unlock()
+
+	if s.stopCompact != nil {
+		close(s.stopCompact)
+		<-s.compactDone
+		s.stopCompact, s.compactDone = nil, nil
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.stopCompact, s.compactDone = stop, done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// Both channels may be ready, stopping wins.
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				if err := s.Compact(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *BoltStorage) Close() error {
+	s.SetCompactionInterval(0, nil)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Close()
+}
+
+func (s *BoltStorage) view(fn func(*bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.View(fn)
+}
+
+func (s *BoltStorage) update(fn func(*bolt.Tx) error) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.Update(fn)
+}
+
+// blockCount returns the number of blocks in the bucket, blocks are stored
+// without gaps so this is the height of the last block plus one.
+func blockCount(bucket *bolt.Bucket) uint32 {
+	k, _ := bucket.Cursor().Last()
+	if k == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(k) + 1
+}
+
+// heightKey encodes the height big endian so bolt keeps the blocks sorted.
+func heightKey(height uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, height)
+
+	return key
+}
@@ -1,9 +1,17 @@
 package core
 
 import (
+	"bytes"
 	"crypto/elliptic"
+	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
 )
 
 type Encoder[T any] interface {
@@ -72,6 +80,248 @@ func (dec *GobBlockDecoder) Decode(b *Block) error{
 	return gob.NewDecoder(dec.r).Decode(b)
 }
 
+// jsonTx is the JSON form of a transaction, keys, signatures and addresses
+// are hex encoded.
+type jsonTx struct {
+	Data      []byte         `json:"data,omitempty"`
+	To        string         `json:"to"`
+	Value     uint64         `json:"value"`
+	Fee       uint64         `json:"fee"`
+	Timestamp int64          `json:"timestamp,omitempty"`
+	Nonce     uint64         `json:"nonce,omitempty"`
+	SigScheme byte           `json:"sigScheme,omitempty"`
+	From      string         `json:"from,omitempty"`
+	Signature string         `json:"signature,omitempty"`
+	Inputs    []jsonTxInput  `json:"inputs,omitempty"`
+	Outputs   []jsonTxOutput `json:"outputs,omitempty"`
+}
+
+type jsonTxInput struct {
+	TxHash    string `json:"txHash"`
+	Index     uint32 `json:"index"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type jsonTxOutput struct {
+	To    string `json:"to"`
+	Value uint64 `json:"value"`
+}
+
+// JSONTxEncoder is an encoder for transactions using JSON, for peers and
+// clients that don't speak gob.
+type JSONTxEncoder struct {
+	w io.Writer
+}
+
+func NewJSONTxEncoder(w io.Writer) *JSONTxEncoder {
+	return &JSONTxEncoder{w: w}
+}
+
+func (e *JSONTxEncoder) Encode(tx *Transaction) error {
+	j := jsonTx{
+		Data:  tx.Data,
+		To:    tx.To.String(),
+		Value:     tx.Value,
+		Fee:       tx.Fee,
+		Timestamp: tx.Timestamp,
+		Nonce:     tx.Nonce,
+		SigScheme: byte(tx.SigScheme),
+	}
+	if !tx.From.IsZero() {
+		j.From = hex.EncodeToString(tx.From.ToSlice())
+	}
+	if tx.Signature != nil {
+		j.Signature = tx.Signature.String()
+	}
+
+	for _, in := range tx.Inputs {
+		jin := jsonTxInput{
+			TxHash: in.PrevOut.TxHash.String(),
+			Index:  in.PrevOut.Index,
+		}
+		if !in.PublicKey.IsZero() {
+			jin.PublicKey = hex.EncodeToString(in.PublicKey.ToSlice())
+		}
+		if in.Signature != nil {
+			jin.Signature = in.Signature.String()
+		}
+		j.Inputs = append(j.Inputs, jin)
+	}
+
+	for _, out := range tx.Outputs {
+		j.Outputs = append(j.Outputs, jsonTxOutput{
+			To:    out.To.String(),
+			Value: out.Value,
+		})
+	}
+
+	return json.NewEncoder(e.w).Encode(j)
+}
+
+// JSONTxDecoder is a decoder for transactions encoded by the JSONTxEncoder.
+type JSONTxDecoder struct {
+	r io.Reader
+}
+
+func NewJSONTxDecoder(r io.Reader) *JSONTxDecoder {
+	return &JSONTxDecoder{r: r}
+}
+
+func (d *JSONTxDecoder) Decode(tx *Transaction) error {
+	var j jsonTx
+	if err := json.NewDecoder(d.r).Decode(&j); err != nil {
+		return err
+	}
+
+	to, err := addressFromHex(j.To)
+	if err != nil {
+		return err
+	}
+
+	decoded := Transaction{
+		Data:  j.Data,
+		To:    to,
+		Value:     j.Value,
+		Fee:       j.Fee,
+		Timestamp: j.Timestamp,
+		Nonce:     j.Nonce,
+		SigScheme: crypto.SigScheme(j.SigScheme),
+	}
+	if len(j.From) > 0 {
+		if decoded.From, err = publicKeyFromHex(j.From); err != nil {
+			return err
+		}
+	}
+	if len(j.Signature) > 0 {
+		if decoded.Signature, err = crypto.SignatureFromString(j.Signature); err != nil {
+			return err
+		}
+	}
+
+	for _, jin := range j.Inputs {
+		hash, err := types.HashFromString(jin.TxHash)
+		if err != nil {
+			return err
+		}
+
+		in := &TxInput{PrevOut: OutPoint{TxHash: hash, Index: jin.Index}}
+		if len(jin.PublicKey) > 0 {
+			if in.PublicKey, err = publicKeyFromHex(jin.PublicKey); err != nil {
+				return err
+			}
+		}
+		if len(jin.Signature) > 0 {
+			if in.Signature, err = crypto.SignatureFromString(jin.Signature); err != nil {
+				return err
+			}
+		}
+		decoded.Inputs = append(decoded.Inputs, in)
+	}
+
+	for _, jout := range j.Outputs {
+		to, err := addressFromHex(jout.To)
+		if err != nil {
+			return err
+		}
+		decoded.Outputs = append(decoded.Outputs, &TxOutput{To: to, Value: jout.Value})
+	}
+
+	*tx = decoded
+
+	return nil
+}
+
+func addressFromHex(s string) (types.Address, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return types.Address{}, err
+	}
+
+	if len(b) != 20 {
+		return types.Address{}, fmt.Errorf("invalid address (%s): given bytes with length %d should be 20", s, len(b))
+	}
+
+	return types.AddressFromBytes(b), nil
+}
+
+func publicKeyFromHex(s string) (crypto.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return crypto.PublicKey{}, err
+	}
+
+	return crypto.PublicKeyFromBytes(b)
+}
+
+// CanonicalTxEncoder writes transactions in a fixed binary layout that does
+// not depend on gob. It is used for hashing, so every node computes the same
+// data hash for the same transactions. Variable length fields are prefixed
+// with their length and optional fields with a presence byte.
+type CanonicalTxEncoder struct {
+	w io.Writer
+}
+
+func NewCanonicalTxEncoder(w io.Writer) *CanonicalTxEncoder {
+	return &CanonicalTxEncoder{w: w}
+}
+
+func (e *CanonicalTxEncoder) Encode(tx *Transaction) error {
+	buf := &bytes.Buffer{}
+
+	writeLengthPrefixed(buf, tx.Data)
+	buf.Write(tx.To.ToSlice())
+	binary.Write(buf, binary.BigEndian, tx.Value)
+	binary.Write(buf, binary.BigEndian, tx.Fee)
+	binary.Write(buf, binary.BigEndian, tx.Timestamp)
+	binary.Write(buf, binary.BigEndian, tx.Nonce)
+	buf.WriteByte(byte(tx.SigScheme))
+
+	if !tx.From.IsZero() {
+		buf.WriteByte(1)
+		writeLengthPrefixed(buf, tx.From.ToSlice())
+	} else {
+		buf.WriteByte(0)
+	}
+
+	if tx.Signature != nil && tx.Signature.R != nil && tx.Signature.S != nil {
+		buf.WriteByte(1)
+		writeLengthPrefixed(buf, tx.Signature.R.Bytes())
+		writeLengthPrefixed(buf, tx.Signature.S.Bytes())
+	} else {
+		buf.WriteByte(0)
+	}
+
+	binary.Write(buf, binary.BigEndian, uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		buf.Write(in.PrevOut.TxHash.ToSlice())
+		binary.Write(buf, binary.BigEndian, in.PrevOut.Index)
+
+		if !in.PublicKey.IsZero() && in.Signature != nil && in.Signature.R != nil && in.Signature.S != nil {
+			buf.WriteByte(1)
+			writeLengthPrefixed(buf, in.PublicKey.ToSlice())
+			writeLengthPrefixed(buf, in.Signature.R.Bytes())
+			writeLengthPrefixed(buf, in.Signature.S.Bytes())
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+
+	binary.Write(buf, binary.BigEndian, uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		buf.Write(out.To.ToSlice())
+		binary.Write(buf, binary.BigEndian, out.Value)
+	}
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+func writeLengthPrefixed(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
 // Ensure elliptic.P256 is registered with gob on package initialization.
 // init() is called automatically when the package is imported.
 func init() {
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"testing/quick"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalTxEncoderStable(t *testing.T) {
+	curve := elliptic.P256()
+	tx := &Transaction{
+		Data:  []byte("canonical"),
+		To:    types.AddressFromBytes(bytes.Repeat([]byte{0xab}, 20)),
+		Value: 100,
+		Fee:   2,
+		From: crypto.PublicKey{
+			Key: &ecdsa.PublicKey{Curve: curve, X: curve.Params().Gx, Y: curve.Params().Gy},
+		},
+		Signature: &crypto.Signature{R: big.NewInt(1), S: big.NewInt(2)},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewCanonicalTxEncoder(buf)))
+	assert.Equal(t, "0000000963616e6f6e6963616cabababababababababababababababababababab0000000000000064000000000000000200000000000000000000000000000000000100000021036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c29601000000010100000001020000000000000000", hex.EncodeToString(buf.Bytes()))
+}
+
+func TestEqualTransactionsEqualDataHash(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+
+	f := func(data []byte, to [20]byte, value, fee uint64) bool {
+		tx := &Transaction{
+			Data:  data,
+			To:    types.Address(to),
+			Value: value,
+			Fee:   fee,
+		}
+		if err := tx.Sign(privKey); err != nil {
+			return false
+		}
+
+		// The same transaction after a trip through gob.
+		buf := &bytes.Buffer{}
+		if err := tx.Encode(NewGobTxEncoder(buf)); err != nil {
+			return false
+		}
+		decoded := new(Transaction)
+		if err := decoded.Decode(NewGobTxDecoder(buf)); err != nil {
+			return false
+		}
+
+		a, err := CalculateDataHash([]*Transaction{tx})
+		if err != nil {
+			return false
+		}
+		b, err := CalculateDataHash([]*Transaction{decoded})
+		if err != nil {
+			return false
+		}
+
+		return a == b
+	}
+
+	assert.Nil(t, quick.Check(f, nil))
+}
+
+func TestJSONTxEncodeDecode(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+
+	account := &Transaction{
+		Data:  []byte("json"),
+		To:    crypto.GeneratePrivateKey().PublicKey().Address(),
+		Value: 100,
+		Fee:   2,
+	}
+	assert.Nil(t, account.Sign(privKey))
+
+	utxo := &Transaction{
+		Fee: 1,
+		Inputs: []*TxInput{
+			{PrevOut: OutPoint{TxHash: types.Hash{1}, Index: 3}},
+		},
+		Outputs: []*TxOutput{
+			{To: privKey.PublicKey().Address(), Value: 7},
+		},
+	}
+	assert.Nil(t, utxo.SignInput(0, privKey))
+
+	for _, tx := range []*Transaction{account, utxo} {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, tx.Encode(NewJSONTxEncoder(buf)))
+
+		decoded := new(Transaction)
+		assert.Nil(t, decoded.Decode(NewJSONTxDecoder(buf)))
+
+		assert.True(t, tx.Equal(decoded))
+		assert.Equal(t, tx.Hash(TxHasher{}), decoded.Hash(TxHasher{}))
+		assert.Nil(t, decoded.Verify())
+
+		a, err := CalculateDataHash([]*Transaction{tx})
+		assert.Nil(t, err)
+		b, err := CalculateDataHash([]*Transaction{decoded})
+		assert.Nil(t, err)
+		assert.Equal(t, a, b)
+	}
+}
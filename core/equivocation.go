@@ -0,0 +1,181 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+)
+
+// equivocationWindow is the number of heights below the highest observed
+// block the signers are remembered for.
+const equivocationWindow = 1024
+
+// SignedHeader is a block header together with the signature of its
+// validator, enough to prove the validator signed it.
+type SignedHeader struct {
+	Header    *Header
+	Signature *crypto.Signature
+	SigScheme crypto.SigScheme
+}
+
+// Equivocation is the evidence of a validator signing two different blocks
+// at the same height, which can be used to slash the validator.
+type Equivocation struct {
+	Validator crypto.PublicKey
+	Height    uint32
+	// First is the block seen first, Second the conflicting one.
+	First  SignedHeader
+	Second SignedHeader
+}
+
+// Verify checks that both headers are at the height, differ and are signed
+// by the validator, so the evidence can be checked by any node.
+func (e *Equivocation) Verify() error {
+	return e.VerifyWithHasher(BlockHasher{})
+}
+
+// VerifyWithHasher verifies the evidence of a chain hashing its headers with
+// the given hasher.
+func (e *Equivocation) VerifyWithHasher(hasher Hasher[*Header]) error {
+	if e.First.Header == nil || e.Second.Header == nil {
+		return fmt.Errorf("equivocation of (%s) at height (%d) is missing a header", e.Validator.Address(), e.Height)
+	}
+
+	if e.First.Header.Height != e.Height || e.Second.Header.Height != e.Height {
+		return fmt.Errorf("equivocation at height (%d) has headers at heights (%d) and (%d)", e.Height, e.First.Header.Height, e.Second.Header.Height)
+	}
+
+	if hasher.Hash(e.First.Header) == hasher.Hash(e.Second.Header) {
+		return fmt.Errorf("equivocation of (%s) at height (%d) has the same header twice", e.Validator.Address(), e.Height)
+	}
+
+	for _, signed := range []SignedHeader{e.First, e.Second} {
+		b := &Block{
+			Header:    signed.Header,
+			Validator: e.Validator,
+			Signature: signed.Signature,
+			SigScheme: signed.SigScheme,
+		}
+		if err := b.verifySignature(); err != nil {
+			return fmt.Errorf("equivocation of (%s) at height (%d): %w", e.Validator.Address(), e.Height, err)
+		}
+	}
+
+	return nil
+}
+
+func signedHeader(b *Block) SignedHeader {
+	return SignedHeader{
+		Header:    b.Header,
+		Signature: b.Signature,
+		SigScheme: b.SigScheme,
+	}
+}
+
+// equivocationDetector remembers the header of the first block every
+// validator signed at the recent heights and records the evidence when a
+// validator signs another one. Only the signed header is kept, not the
+// transactions of the block.
+type equivocationDetector struct {
+	mu      sync.Mutex
+	signed  map[uint32]map[types.Address]SignedHeader
+	highest uint32
+	// reported holds the validators and heights evidence was recorded for,
+	// further conflicting blocks add nothing.
+	reported map[uint32]map[types.Address]bool
+	evidence []*Equivocation
+	hasher   Hasher[*Header]
+}
+
+func newEquivocationDetector(hasher Hasher[*Header]) *equivocationDetector {
+	return &equivocationDetector{
+		signed:   make(map[uint32]map[types.Address]SignedHeader),
+		reported: make(map[uint32]map[types.Address]bool),
+		hasher:   hasher,
+	}
+}
+
+// observe records the signer of the block, whose signature has to be valid,
+// and returns the evidence if the validator signed another block at the
+// same height before.
+func (d *equivocationDetector) observe(b *Block) *Equivocation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if b.Height+equivocationWindow < d.highest {
+		return nil
+	}
+
+	if b.Height > d.highest {
+		d.highest = b.Height
+		for height := range d.signed {
+			if height+equivocationWindow < d.highest {
+				delete(d.signed, height)
+				delete(d.reported, height)
+			}
+		}
+	}
+
+	addr := b.Validator.Address()
+	if d.signed[b.Height] == nil {
+		d.signed[b.Height] = make(map[types.Address]SignedHeader)
+	}
+
+	first, ok := d.signed[b.Height][addr]
+	if !ok {
+		d.signed[b.Height][addr] = signedHeader(b)
+		return nil
+	}
+
+	if d.hasher.Hash(first.Header) == b.Hash(d.hasher) || d.reported[b.Height][addr] {
+		return nil
+	}
+
+	if d.reported[b.Height] == nil {
+		d.reported[b.Height] = make(map[types.Address]bool)
+	}
+	d.reported[b.Height][addr] = true
+
+	e := &Equivocation{
+		Validator: b.Validator,
+		Height:    b.Height,
+		First:     first,
+		Second:    signedHeader(b),
+	}
+	d.evidence = append(d.evidence, e)
+
+	return e
+}
+
+// Equivocations returns the evidence of validators that signed two blocks
+// at the same height, in the order it was found.
+func (bc *Blockchain) Equivocations() []*Equivocation {
+	bc.equivocations.mu.Lock()
+	defer bc.equivocations.mu.Unlock()
+
+	return append([]*Equivocation{}, bc.equivocations.evidence...)
+}
+
+// observeSigner checks the block for an equivocation of its validator. Only
+// the signature of the block needs to be valid, a validator signing a block
+// that is invalid otherwise still signed it.
+func (bc *Blockchain) observeSigner(b *Block) {
+	if b.Height == 0 {
+		return
+	}
+
+	e := bc.equivocations.observe(b)
+	if e == nil {
+		return
+	}
+
+	bc.logger.Log(
+		"msg", "validator equivocation",
+		"validator", e.Validator.Address(),
+		"height", e.Height,
+		"first", bc.blockHasher.Hash(e.First.Header),
+		"second", bc.blockHasher.Hash(e.Second.Header),
+	)
+}
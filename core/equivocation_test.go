@@ -0,0 +1,94 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEquivocationDetected(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+
+	first := nextBlockSignedBy(t, bc, validator, randomTxx(t, 1)...)
+	second := nextBlockSignedBy(t, bc, validator, randomTxx(t, 1)...)
+	other := nextBlockSignedBy(t, bc, crypto.GeneratePrivateKey(), randomTxx(t, 1)...)
+
+	assert.Nil(t, bc.AddBlock(first))
+	// A competing block of another validator is a fork, not an equivocation.
+	assert.Nil(t, bc.AddBlock(other))
+	assert.Empty(t, bc.Equivocations())
+
+	assert.Nil(t, bc.AddBlock(second))
+	evidence := bc.Equivocations()
+	if assert.Len(t, evidence, 1) {
+		e := evidence[0]
+		assert.Equal(t, uint32(1), e.Height)
+		assert.Equal(t, validator.PublicKey().Address(), e.Validator.Address())
+		assert.Equal(t, first.Hash(BlockHasher{}), BlockHasher{}.Hash(e.First.Header))
+		assert.Equal(t, second.Hash(BlockHasher{}), BlockHasher{}.Hash(e.Second.Header))
+		assert.Equal(t, second.Signature, e.Second.Signature)
+		assert.Nil(t, e.Verify())
+
+		forged := *e
+		forged.Validator = crypto.GeneratePrivateKey().PublicKey()
+		assert.NotNil(t, forged.Verify())
+
+		forged = *e
+		forged.Second = forged.First
+		assert.NotNil(t, forged.Verify())
+	}
+
+	// A third block signed at the same height is rejected as invalid, the
+	// validator is reported once per height.
+	third := nextBlockSignedBy(t, bc, validator)
+	third.Height = 1
+	third.PrevBlockHash = first.PrevBlockHash
+	third.GasUsed = 1
+	assert.Nil(t, third.Sign(validator))
+	assert.NotNil(t, bc.AddBlock(third))
+	assert.Len(t, bc.Equivocations(), 1)
+}
+
+func TestEquivocationOfRejectedBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator)))
+
+	// The second block at height 1 doesn't pass validation, it is signed
+	// by the validator all the same.
+	bad, err := NewBlockFromPrevHeader(bc.LastHeader(), nil)
+	assert.Nil(t, err)
+	bad.Height = 1
+	bad.GasUsed = 1
+	assert.Nil(t, bad.Sign(validator))
+	assert.NotNil(t, bc.AddBlock(bad))
+
+	assert.Len(t, bc.Equivocations(), 1)
+}
+
+func TestEquivocationIgnoresUnauthorizedSigners(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator, stranger := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc.SetValidators([]types.Address{validator.PublicKey().Address()})
+
+	assert.Nil(t, bc.AddBlock(nextBlockSignedBy(t, bc, validator)))
+
+	// Blocks of a key that may not sign are rejected and not remembered.
+	for i := 0; i < 2; i++ {
+		b, err := NewBlockFromPrevHeader(bc.LastHeader(), randomTxx(t, 1))
+		assert.Nil(t, err)
+		b.Height = 1
+		assert.Nil(t, b.Sign(stranger))
+		assert.NotNil(t, bc.AddBlock(b))
+	}
+	assert.Empty(t, bc.Equivocations())
+	assert.Empty(t, bc.equivocations.signed[1][stranger.PublicKey().Address()].Header)
+
+	// The detector keeps the signed header only.
+	first := bc.equivocations.signed[1][validator.PublicKey().Address()]
+	assert.Equal(t, bc.LastHeader(), first.Header)
+}
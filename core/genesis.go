@@ -0,0 +1,97 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+)
+
+var ErrInvalidGenesis = errors.New("invalid genesis block")
+
+// genesisKey signs the blocks created from a Genesis. Every node derives the
+// genesis from the same config and has to end up with the same block, so the
+// key is known to all of them. The block hash leaves out the signature, a
+// genesis signed by another key has the same hash.
+var genesisKey = crypto.PrivateKeyFromSeed([]byte("blockchainz genesis"))
+
+// Genesis describes the initial state of the chain. The alloc is included
+// as unsigned transactions in the genesis block, so nodes with a different
+// alloc end up with a different genesis hash and will never agree on a chain.
+type Genesis struct {
+	Timestamp int64
+	Alloc     map[types.Address]uint64
+	// HashAlgorithm is used for the header and data hash of every block.
+	HashAlgorithm HashAlgorithm
+}
+
+// Block creates the genesis block, signed by a key every node knows.
+func (g *Genesis) Block() (*Block, error) {
+	addrs := make([]types.Address, 0, len(g.Alloc))
+	for addr := range g.Alloc {
+		addrs = append(addrs, addr)
+	}
+
+	// Map iteration order is random, sort to get the same data hash on every node.
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+
+	txx := make([]*Transaction, 0, len(addrs))
+	for _, addr := range addrs {
+		txx = append(txx, &Transaction{
+			To:    addr,
+			Value: g.Alloc[addr],
+		})
+	}
+
+	dataHash, err := CalculateDataHashWithAlgorithm(g.HashAlgorithm, txx)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &Header{
+		Version:       1,
+		DataHash:      dataHash,
+		Height:        0,
+		Timestamp:     g.Timestamp,
+		HashAlgorithm: g.HashAlgorithm,
+	}
+
+	b, err := NewBlock(header, txx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.Sign(genesisKey); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// validateGenesis checks that the genesis is at height zero, signed and has
+// the right data hash. Its transactions are the unsigned alloc and are not
+// verified.
+func validateGenesis(b *Block, hasher Hasher[*Header]) error {
+	if b == nil || b.Header == nil {
+		return fmt.Errorf("%w: block has no header", ErrInvalidGenesis)
+	}
+
+	if b.Height != 0 {
+		return fmt.Errorf("%w: height (%d) => expected (0)", ErrInvalidGenesis, b.Height)
+	}
+
+	if err := b.verifySignature(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidGenesis, err)
+	}
+
+	if err := b.verifyDataHash(hasher); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidGenesis, err)
+	}
+
+	return nil
+}
@@ -2,9 +2,105 @@ package core
 
 import (
 	"crypto/sha256"
+	"fmt"
+	"hash"
+
 	"github.com/ayushn2/blockchainz/types"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// HashAlgorithm selects the hash function used for the header and data hash
+// of a block. It is carried in the header, and set for the whole chain by the
+// genesis, so every node hashes a block the same way.
+type HashAlgorithm byte
+
+const (
+	HashSHA256 HashAlgorithm = iota
+	HashSHA3_256
+	HashBLAKE2b256
 )
 
+func (a HashAlgorithm) Valid() bool {
+	return a <= HashBLAKE2b256
+}
+
+// Sum hashes the given data, an unknown algorithm results in a zero hash.
+func (a HashAlgorithm) Sum(data []byte) types.Hash {
+	switch a {
+	case HashSHA256:
+		return types.Hash(sha256.Sum256(data))
+	case HashSHA3_256:
+		return types.Hash(sha3.Sum256(data))
+	case HashBLAKE2b256:
+		return types.Hash(blake2b.Sum256(data))
+	default:
+		return types.Hash{}
+	}
+}
+
+// New returns a streaming hash.Hash for the algorithm, nil for an unknown
+// algorithm.
+func (a HashAlgorithm) New() hash.Hash {
+	switch a {
+	case HashSHA256:
+		return sha256.New()
+	case HashSHA3_256:
+		return sha3.New256()
+	case HashBLAKE2b256:
+		// New256 only fails for keys longer than 64 bytes.
+		h, _ := blake2b.New256(nil)
+		return h
+	default:
+		return nil
+	}
+}
+
+// HashDomain is written in front of the data before it is hashed, so the
+// same bytes hashed for different purposes never result in the same hash.
+// A transaction can't be crafted to hash to the hash of a header.
+type HashDomain byte
+
+const (
+	// DomainHeader is used for block header hashes.
+	DomainHeader HashDomain = 0x01
+	// DomainTx is used for transaction hashes.
+	DomainTx HashDomain = 0x02
+	// DomainData is used for the data hash committing to the transactions
+	// of a block.
+	DomainData HashDomain = 0x03
+)
+
+// SumDomain hashes the given data in the given domain, an unknown algorithm
+// results in a zero hash.
+func (a HashAlgorithm) SumDomain(domain HashDomain, data []byte) types.Hash {
+	h := a.New()
+	if h == nil {
+		return types.Hash{}
+	}
+
+	h.Write([]byte{byte(domain)})
+	h.Write(data)
+
+	var sum types.Hash
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+func (a HashAlgorithm) String() string {
+	switch a {
+	case HashSHA256:
+		return "SHA-256"
+	case HashSHA3_256:
+		return "SHA3-256"
+	case HashBLAKE2b256:
+		return "BLAKE2b-256"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(a))
+	}
+}
+
 // Generic hasher interface for any type T.
 // Requires a Hash method that takes T and returns types.Hash.
 type Hasher[T any] interface {
@@ -13,16 +109,20 @@ type Hasher[T any] interface {
 
 type BlockHasher struct{}
 
+// Hash returns the zero hash if the header can't be encoded, a header only
+// holds plain values so this can only happen for a nil header. No block has
+// the zero hash, blocks without a header are rejected with ErrNilHeader.
 func (BlockHasher) Hash(head *Header) types.Hash {
-	 h := sha256.Sum256(head.Bytes())
+	data, err := head.Bytes()
+	if err != nil {
+		return types.Hash{}
+	}
 
-	 return types.Hash(h)
+	return head.HashAlgorithm.SumDomain(DomainHeader, data)
 }
 
 type TxHasher struct{}
 
 func (TxHasher) Hash(tx *Transaction) types.Hash {
-	h := sha256.Sum256(tx.Data)
-
-	return types.Hash(h)
+	return HashSHA256.SumDomain(DomainTx, tx.signingBytes())
 }
\ No newline at end of file
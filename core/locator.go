@@ -0,0 +1,61 @@
+package core
+
+import "github.com/ayushn2/blockchainz/types"
+
+// locatorDenseHashes is the number of hashes right below the tip in a block
+// locator, the gaps between the following hashes double.
+const locatorDenseHashes = 10
+
+// BlockLocator returns the hashes of blocks on the main chain, starting at the
+// tip. The first hashes are the direct predecessors of the tip, after them the
+// gap doubles with every hash and the genesis always comes last. A peer finds
+// the common ancestor of both chains in it without getting every header.
+func (bc *Blockchain) BlockLocator() []types.Hash {
+	var (
+		height  = bc.Height()
+		step    = uint32(1)
+		locator = []types.Hash{}
+	)
+
+	for {
+		header, err := bc.GetHeader(height)
+		if err != nil {
+			bc.logger.Log("msg", "block locator stopped", "height", height, "err", err)
+			break
+		}
+		locator = append(locator, bc.blockHasher.Hash(header))
+
+		if height == 0 {
+			break
+		}
+
+		if len(locator) >= locatorDenseHashes {
+			step *= 2
+		}
+
+		if height < step {
+			height = 0
+		} else {
+			height -= step
+		}
+	}
+
+	return locator
+}
+
+// CommonAncestor returns the height of the first block of the locator that is
+// on our main chain. The genesis is returned when none of them is, which
+// peers sharing the genesis only see when the block is out of the header
+// window.
+func (bc *Blockchain) CommonAncestor(locator []types.Hash) uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	for _, hash := range locator {
+		if header, ok := bc.headerIndex[hash]; ok {
+			return header.Height
+		}
+	}
+
+	return 0
+}
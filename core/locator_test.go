@@ -0,0 +1,60 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockLocator(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	for i := 0; i < 40; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	heights := []uint32{40, 39, 38, 37, 36, 35, 34, 33, 32, 31, 29, 25, 17, 1, 0}
+	locator := bc.BlockLocator()
+	assert.Equal(t, len(heights), len(locator))
+	for i, height := range heights {
+		header, err := bc.GetHeader(height)
+		assert.Nil(t, err)
+		assert.Equal(t, BlockHasher{}.Hash(header), locator[i])
+	}
+
+	genesis := newBlockchainWithGenesis(t)
+	assert.Equal(t, []types.Hash{getPrevBlockHash(t, genesis, 1)}, genesis.BlockLocator())
+}
+
+func TestBlockLocatorCommonAncestor(t *testing.T) {
+	genesis := randomBlock(t, 0, types.Hash{})
+	a, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	b, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	for i := 0; i < 23; i++ {
+		block := nextBlock(t, a)
+		assert.Nil(t, a.AddBlock(block))
+		assert.Nil(t, b.AddBlock(block))
+	}
+
+	// The chains diverge above height 23, a grows far longer than b.
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, a.AddBlock(nextBlock(t, a)))
+	}
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, b.AddBlock(nextBlock(t, b)))
+	}
+
+	assert.Equal(t, uint32(23), a.CommonAncestor(b.BlockLocator()))
+	// The locator of the longer chain skips the fork point, the closest
+	// common block below it is found instead.
+	assert.Equal(t, uint32(14), b.CommonAncestor(a.BlockLocator()))
+
+	// Peers on the same chain agree on the tip.
+	assert.Equal(t, a.Height(), a.CommonAncestor(a.BlockLocator()))
+	// Without a known hash only the genesis is common.
+	assert.Equal(t, uint32(0), a.CommonAncestor([]types.Hash{{0x1}}))
+}
@@ -0,0 +1,56 @@
+package core
+
+import "sort"
+
+// SetMedianTimeSpan enables the median time past rule, a block has to be
+// younger than the median timestamp of the span blocks before it. Bitcoin
+// uses a span of 11 blocks. Zero disables the rule.
+func (bc *Blockchain) SetMedianTimeSpan(span uint32) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.medianTimeSpan = span
+}
+
+func (bc *Blockchain) MedianTimeSpan() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.medianTimeSpan
+}
+
+// medianTimePast returns the median timestamp of the given header and the
+// headers before it, up to span headers. The ancestors are taken from the
+// branch of the header, which may be a side block.
+func (bc *Blockchain) medianTimePast(header *Header, span uint32) (int64, error) {
+	timestamps := make([]int64, 0, span)
+	for {
+		timestamps = append(timestamps, header.Timestamp)
+		if uint32(len(timestamps)) >= span || header.Height == 0 {
+			break
+		}
+
+		bc.lock.RLock()
+		parent, ok := bc.sideBlocks[header.PrevBlockHash]
+		bc.lock.RUnlock()
+
+		if ok {
+			header = parent.Header
+			continue
+		}
+
+		// A side branch forks off the main chain, from there on the
+		// ancestors are main chain blocks.
+		prev, err := bc.GetHeader(header.Height - 1)
+		if err != nil {
+			return 0, err
+		}
+		header = prev
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return timestamps[len(timestamps)/2], nil
+}
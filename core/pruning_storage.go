@@ -0,0 +1,94 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrBlockPruned = errors.New("block pruned")
+
+// headerStore is implemented by stores that keep headers of blocks they no
+// longer hold in full.
+type headerStore interface {
+	GetHeader(height uint32) (*Header, error)
+}
+
+// PruningStore is an in memory store that keeps the full blocks of the most
+// recent depth heights only, older blocks are reduced to their header and
+// signature. Getting a pruned block fails with ErrBlockPruned while its
+// header stays available, so new blocks can still be validated. A chain on
+// a pruning store reorgs with the undo data of the blocks it holds in
+// memory, a branch forking off below them is refused.
+type PruningStore struct {
+	*MemoryStore
+	depth uint32
+	// pruned is the number of blocks, from genesis on, reduced to their
+	// header.
+	pruned uint32
+}
+
+func NewPruningStore(depth uint32) *PruningStore {
+	return &PruningStore{
+		MemoryStore: NewMemorystore(),
+		depth:       depth,
+	}
+}
+
+func (s *PruningStore) Put(b *Block) error {
+	if err := s.MemoryStore.Put(b); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for ; int(s.pruned)+int(s.depth) < len(s.blocks); s.pruned++ {
+		full := s.blocks[s.pruned]
+		s.blocks[s.pruned] = &Block{
+			Header:    full.Header,
+			Validator: full.Validator,
+			Signature: full.Signature,
+			SigScheme: full.SigScheme,
+		}
+	}
+
+	return nil
+}
+
+func (s *PruningStore) Get(height uint32) (*Block, error) {
+	s.lock.RLock()
+	pruned := s.pruned
+	s.lock.RUnlock()
+
+	if height < pruned {
+		return nil, fmt.Errorf("%w: block with height (%d) => oldest full block (%d)", ErrBlockPruned, height, pruned)
+	}
+
+	return s.MemoryStore.Get(height)
+}
+
+func (s *PruningStore) GetHeader(height uint32) (*Header, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.blocks) {
+		return nil, fmt.Errorf("block with height (%d) not found", height)
+	}
+
+	return s.blocks[height].Header, nil
+}
+
+func (s *PruningStore) Truncate(height uint32) error {
+	if err := s.MemoryStore.Truncate(height); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(s.pruned) > len(s.blocks) {
+		s.pruned = uint32(len(s.blocks))
+	}
+
+	return nil
+}
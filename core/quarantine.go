@@ -0,0 +1,93 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ayushn2/blockchainz/types"
+)
+
+// quarantineSize is the number of rejected blocks the chain remembers.
+const quarantineSize = 128
+
+// QuarantinedBlock records a block that was rejected, so operators can see
+// why a peer keeps sending bad blocks.
+type QuarantinedBlock struct {
+	Hash   types.Hash
+	Height uint32
+	// Reason is the error the block was rejected with.
+	Reason string
+	// Source is where the block came from, usually the address of a peer.
+	// It is empty when the block was added without a source.
+	Source string
+	Time   time.Time
+}
+
+// Quarantine keeps the most recently rejected blocks in a fixed size
+// buffer, older entries are overwritten.
+type Quarantine struct {
+	mu      sync.Mutex
+	entries []QuarantinedBlock
+	// next is the index the next entry is written to.
+	next int
+	full bool
+
+	hasher Hasher[*Header]
+}
+
+func NewQuarantine(size int) *Quarantine {
+	return NewQuarantineWithHasher(size, BlockHasher{})
+}
+
+// NewQuarantineWithHasher creates a quarantine recording the blocks by the
+// hashes of the given hasher, which should be the hasher of the chain.
+func NewQuarantineWithHasher(size int, hasher Hasher[*Header]) *Quarantine {
+	return &Quarantine{
+		entries: make([]QuarantinedBlock, size),
+		hasher:  hasher,
+	}
+}
+
+// Add records the block as rejected with the given reason.
+func (q *Quarantine) Add(b *Block, source string, reason error) {
+	entry := QuarantinedBlock{
+		Hash:   b.Hash(q.hasher),
+		Height: b.Height,
+		Reason: reason.Error(),
+		Source: source,
+		Time:   time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries[q.next] = entry
+	q.next = (q.next + 1) % len(q.entries)
+	if q.next == 0 {
+		q.full = true
+	}
+}
+
+// Entries returns a copy of the quarantined blocks, oldest first.
+func (q *Quarantine) Entries() []QuarantinedBlock {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.full {
+		return append([]QuarantinedBlock{}, q.entries[:q.next]...)
+	}
+
+	return append(append([]QuarantinedBlock{}, q.entries[q.next:]...), q.entries[:q.next]...)
+}
+
+// Get returns the most recent entry for the block with the given hash.
+func (q *Quarantine) Get(hash types.Hash) (QuarantinedBlock, bool) {
+	entries := q.Entries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Hash == hash {
+			return entries[i], true
+		}
+	}
+
+	return QuarantinedBlock{}, false
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantineRecordsRejectedBlocks(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	bad := nextBlock(t, bc)
+	bad.GasUsed = 1
+	assert.Nil(t, bad.Sign(crypto.GeneratePrivateKey()))
+
+	err := bc.AddBlockFrom(bad, "PEER")
+	assert.ErrorIs(t, err, ErrGasUsedMismatch)
+
+	entry, ok := bc.Quarantine().Get(bad.Hash(BlockHasher{}))
+	if assert.True(t, ok) {
+		assert.Equal(t, uint32(1), entry.Height)
+		assert.Equal(t, "PEER", entry.Source)
+		assert.Equal(t, err.Error(), entry.Reason)
+		assert.False(t, entry.Time.IsZero())
+	}
+
+	// Known blocks are expected and not recorded.
+	good := nextBlock(t, bc)
+	assert.Nil(t, bc.AddBlock(good))
+	assert.ErrorIs(t, bc.AddBlockFrom(good, "PEER"), ErrBlockKnown)
+	assert.Len(t, bc.Quarantine().Entries(), 1)
+}
+
+func TestQuarantineBounded(t *testing.T) {
+	q := NewQuarantine(2)
+
+	blocks := make([]*Block, 3)
+	for i := range blocks {
+		blocks[i] = randomBlock(t, uint32(i+1), types.Hash{})
+		q.Add(blocks[i], "PEER", ErrGasUsedMismatch)
+	}
+
+	entries := q.Entries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, blocks[1].Hash(BlockHasher{}), entries[0].Hash)
+		assert.Equal(t, blocks[2].Hash(BlockHasher{}), entries[1].Hash)
+	}
+
+	_, ok := q.Get(blocks[0].Hash(BlockHasher{}))
+	assert.False(t, ok)
+}
+
+func TestQuarantineUsesChainHasher(t *testing.T) {
+	hasher := &countingHasher{}
+	bc, err := NewBlockchain(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), WithBlockHasher(hasher))
+	assert.Nil(t, err)
+
+	bad, err := NewBlockFromPrevHeaderWithHasher(hasher, bc.LastHeader(), nil)
+	assert.Nil(t, err)
+	bad.GasUsed = 1
+	assert.Nil(t, bad.Sign(crypto.GeneratePrivateKey()))
+
+	assert.ErrorIs(t, bc.AddBlockFrom(bad, "PEER"), ErrGasUsedMismatch)
+
+	_, ok := bc.Quarantine().Get(hasher.Hash(bad.Header))
+	assert.True(t, ok)
+	_, ok = bc.Quarantine().Get(BlockHasher{}.Hash(bad.Header))
+	assert.False(t, ok)
+}
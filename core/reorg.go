@@ -0,0 +1,453 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ayushn2/blockchainz/types"
+)
+
+var ErrReorgTooDeep = errors.New("reorg too deep")
+
+// SetTrackUncles enables recording the valid blocks that did not make it on
+// the main chain, they can then be retrieved with Uncles.
+func (bc *Blockchain) SetTrackUncles(track bool) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.trackUncles = track
+}
+
+// Uncles returns the valid blocks with the given height that are not on the
+// main chain. It is always empty when uncle tracking is disabled.
+func (bc *Blockchain) Uncles(height uint32) []*Block {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return append([]*Block{}, bc.uncles[height]...)
+}
+
+// SetMaxReorgDepth limits the number of blocks a reorg may roll back, a
+// longer competing branch forking off deeper is refused. Zero removes the
+// limit.
+func (bc *Blockchain) SetMaxReorgDepth(depth uint32) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.maxReorgDepth = depth
+}
+
+// OnReorg registers a function that is called after a reorg with the blocks
+// removed from the main chain and the blocks of the branch that replaced them.
+// It is also called after a Rollback, without added blocks.
+func (bc *Blockchain) OnReorg(fn func(orphaned, added []*Block)) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.reorgHandlers = append(bc.reorgHandlers, fn)
+}
+
+// Children returns the known blocks building on the block with the given
+// hash, the main chain block first followed by the side blocks ordered by
+// hash. More than one child means the chain forked at that block.
+func (bc *Blockchain) Children(hash types.Hash) []*Block {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	var side []*Block
+	for _, b := range bc.sideBlocks {
+		if b.PrevBlockHash == hash {
+			side = append(side, b)
+		}
+	}
+	sort.Slice(side, func(i, j int) bool {
+		a, b := side[i].Hash(bc.blockHasher), side[j].Hash(bc.blockHasher)
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	parent, ok := bc.headerIndex[hash]
+	if !ok || parent.Height+1 < bc.offset || parent.Height >= bc.height() {
+		return side
+	}
+
+	return append([]*Block{bc.blocks[parent.Height+1-bc.offset]}, side...)
+}
+
+// isSideBlock returns true if the block is new and builds on a block other
+// than the tip of the main chain.
+func (bc *Blockchain) isSideBlock(b *Block) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	hash := b.Hash(bc.blockHasher)
+	if _, ok := bc.headerIndex[hash]; ok {
+		return false
+	}
+	if _, ok := bc.sideBlocks[hash]; ok {
+		return false
+	}
+
+	if parent, ok := bc.sideBlocks[b.PrevBlockHash]; ok {
+		return parent.Height+1 == b.Height
+	}
+
+	parent, ok := bc.headerIndex[b.PrevBlockHash]
+	if !ok {
+		return false
+	}
+
+	return parent.Height+1 == b.Height && b.Height <= bc.height()
+}
+
+// addSideBlock keeps a valid block that is not on the main chain and switches
+// to its branch when the branch becomes longer than the main chain. A block
+// forking off too deep to ever be reorged to is refused.
+func (bc *Blockchain) addSideBlock(b *Block) error {
+	parent, err := bc.parentHeader(b)
+	if err != nil {
+		return err
+	}
+
+	if err := bc.checkForkDepth(b); err != nil {
+		return err
+	}
+
+	if b.HashAlgorithm != parent.HashAlgorithm {
+		return fmt.Errorf("block (%s) uses hash algorithm (%s) => parent uses (%s)", b.Hash(bc.blockHasher), b.HashAlgorithm, parent.HashAlgorithm)
+	}
+
+	if err := b.VerifyWithHasher(bc.blockHasher); err != nil {
+		return err
+	}
+
+	validator := NewBlockValidator(bc)
+	if err := validator.validateCheckpoint(b); err != nil {
+		return err
+	}
+
+	if err := validator.validateMedianTimePast(b, parent); err != nil {
+		return err
+	}
+
+	if err := validator.validateGasUsed(b); err != nil {
+		return err
+	}
+
+	if err := validator.validateValidator(b); err != nil {
+		return err
+	}
+
+	if err := validator.validateCoinbase(b); err != nil {
+		return err
+	}
+
+	bc.lock.Lock()
+	bc.sideBlocks[b.Hash(bc.blockHasher)] = b
+	bc.lock.Unlock()
+
+	if b.Height <= bc.Height() {
+		bc.logger.Log(
+			"msg", "new side block",
+			"hash", b.Hash(bc.blockHasher),
+			"height", b.Height,
+		)
+		bc.addUncle(b)
+		return nil
+	}
+
+	return bc.reorg(b)
+}
+
+// checkForkDepth fails with ErrReorgTooDeep if the branch of the side block
+// forks off below the blocks in memory or would roll back more than
+// maxReorgDepth blocks.
+func (bc *Blockchain) checkForkDepth(b *Block) error {
+	_, forkHeight, err := bc.sideBranch(b)
+	if err != nil {
+		return err
+	}
+
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if forkHeight < bc.offset {
+		return fmt.Errorf("%w: block (%s) forks off at height (%d) => oldest block in memory (%d)", ErrReorgTooDeep, b.Hash(bc.blockHasher), forkHeight, bc.offset)
+	}
+
+	if depth := bc.height() - forkHeight; bc.maxReorgDepth > 0 && depth > bc.maxReorgDepth {
+		return fmt.Errorf("%w: block (%s) forks off (%d) blocks below the tip => maximum (%d)", ErrReorgTooDeep, b.Hash(bc.blockHasher), depth, bc.maxReorgDepth)
+	}
+
+	return nil
+}
+
+// pruneSideBlocks drops the side blocks no branch can be reorged to any
+// more, because they are below the blocks in memory or more than
+// maxReorgDepth blocks below the tip. The caller has to hold the lock.
+func (bc *Blockchain) pruneSideBlocks() {
+	tip := bc.height()
+	for hash, b := range bc.sideBlocks {
+		if b.Height <= bc.offset || (bc.maxReorgDepth > 0 && b.Height+bc.maxReorgDepth <= tip) {
+			delete(bc.sideBlocks, hash)
+		}
+	}
+}
+
+func (bc *Blockchain) parentHeader(b *Block) (*Header, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if parent, ok := bc.sideBlocks[b.PrevBlockHash]; ok {
+		return parent.Header, nil
+	}
+
+	if header, ok := bc.headerIndex[b.PrevBlockHash]; ok {
+		return header, nil
+	}
+
+	return nil, fmt.Errorf("parent (%s) of block (%s) not found", b.PrevBlockHash, b.Hash(bc.blockHasher))
+}
+
+// sideBranch returns the side blocks leading up to the given block, oldest
+// first, and the height of the main chain block the branch forks off.
+func (bc *Blockchain) sideBranch(tip *Block) ([]*Block, uint32, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	branch := []*Block{tip}
+	for {
+		prevHash := branch[0].PrevBlockHash
+		if header, ok := bc.headerIndex[prevHash]; ok {
+			return branch, header.Height, nil
+		}
+
+		parent, ok := bc.sideBlocks[prevHash]
+		if !ok {
+			return nil, 0, fmt.Errorf("parent (%s) of block (%s) not found", prevHash, branch[0].Hash(bc.blockHasher))
+		}
+
+		branch = append([]*Block{parent}, branch...)
+	}
+}
+
+// reorg replaces the main chain above the fork point with the side branch
+// ending in the given block. The account state is rebuilt by reverting the
+// main chain down to the fork point and applying the branch, if any block of
+// the branch fails to apply the main chain is kept.
+func (bc *Blockchain) reorg(tip *Block) error {
+	branch, forkHeight, err := bc.sideBranch(tip)
+	if err != nil {
+		return err
+	}
+
+	bc.lock.RLock()
+	if forkHeight < bc.offset {
+		bc.lock.RUnlock()
+		return fmt.Errorf("%w: block (%s) forks off at height (%d) => oldest block in memory (%d)", ErrReorgTooDeep, tip.Hash(bc.blockHasher), forkHeight, bc.offset)
+	}
+	orphaned := append([]*Block{}, bc.blocks[forkHeight+1-bc.offset:]...)
+	maxDepth := bc.maxReorgDepth
+	bc.lock.RUnlock()
+
+	if maxDepth > 0 && len(orphaned) > int(maxDepth) {
+		return fmt.Errorf("%w: block (%s) with height (%d) would roll back (%d) blocks => maximum (%d)", ErrReorgTooDeep, tip.Hash(bc.blockHasher), tip.Height, len(orphaned), maxDepth)
+	}
+
+	state, err := bc.revertState(forkHeight)
+	if err != nil {
+		return fmt.Errorf("cannot reorg to block (%s) with height (%d): %w", tip.Hash(bc.blockHasher), tip.Height, err)
+	}
+
+	undos := make([]*stateUndo, len(branch))
+	for i, b := range branch {
+		if undos[i], err = bc.applyBlock(state, b); err != nil {
+			return fmt.Errorf("cannot reorg to block (%s) with height (%d): %w", tip.Hash(bc.blockHasher), tip.Height, err)
+		}
+	}
+
+	bc.lock.Lock()
+	for _, b := range orphaned {
+		hash := b.Hash(bc.blockHasher)
+		delete(bc.headerIndex, hash)
+		bc.sideBlocks[hash] = b
+		bc.txCount -= uint64(len(b.Transactions))
+	}
+
+	bc.headers = bc.headers[:forkHeight+1-bc.offset]
+	bc.blocks = bc.blocks[:forkHeight+1-bc.offset]
+	bc.undos = append(bc.undos[:forkHeight+1-bc.offset], undos...)
+	for _, b := range branch {
+		hash := b.Hash(bc.blockHasher)
+		delete(bc.sideBlocks, hash)
+		bc.headers = append(bc.headers, b.Header)
+		bc.blocks = append(bc.blocks, b)
+		bc.headerIndex[hash] = b.Header
+		bc.txCount += uint64(len(b.Transactions))
+	}
+	bc.accountState = state
+	bc.dropUndos()
+	bc.pruneSideBlocks()
+	bc.lock.Unlock()
+
+	for _, b := range orphaned {
+		bc.addUncle(b)
+	}
+	for _, b := range branch {
+		bc.removeUncle(b)
+	}
+
+	bc.logger.Log(
+		"msg", "reorg",
+		"fork", forkHeight,
+		"orphaned", len(orphaned),
+		"height", tip.Height,
+		"hash", tip.Hash(bc.blockHasher),
+	)
+
+	if err := bc.store.Truncate(forkHeight); err != nil {
+		return err
+	}
+
+	for _, b := range orphaned {
+		if err := bc.unindexTransactions(b); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range branch {
+		if err := bc.store.Put(b); err != nil {
+			return err
+		}
+
+		if err := bc.indexTransactions(b); err != nil {
+			return err
+		}
+
+		bc.notifyNewBlock(b)
+	}
+
+	bc.notifyReorg(orphaned, branch)
+
+	return nil
+}
+
+// Rollback removes the blocks above the given height from the chain and the
+// store, to recover from a bad state by hand. The account state is reverted
+// to the given height and the reorg handlers are called with the
+// removed blocks, so their transactions can go back to the mempool. The
+// removed blocks are dropped, they are not kept as side blocks.
+func (bc *Blockchain) Rollback(height uint32) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	bc.lock.RLock()
+	if tip := bc.height(); height > tip {
+		bc.lock.RUnlock()
+		return fmt.Errorf("cannot roll back to height (%d) => current height (%d)", height, tip)
+	}
+	if height < bc.offset {
+		bc.lock.RUnlock()
+		return fmt.Errorf("%w: cannot roll back to height (%d) => oldest block in memory (%d)", ErrReorgTooDeep, height, bc.offset)
+	}
+	removed := append([]*Block{}, bc.blocks[height+1-bc.offset:]...)
+	bc.lock.RUnlock()
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	state, err := bc.revertState(height)
+	if err != nil {
+		return fmt.Errorf("cannot roll back to height (%d): %w", height, err)
+	}
+
+	bc.lock.Lock()
+	for _, b := range removed {
+		delete(bc.headerIndex, b.Hash(bc.blockHasher))
+		bc.txCount -= uint64(len(b.Transactions))
+	}
+	bc.headers = bc.headers[:height+1-bc.offset]
+	bc.blocks = bc.blocks[:height+1-bc.offset]
+	bc.undos = bc.undos[:height+1-bc.offset]
+	bc.accountState = state
+	bc.lock.Unlock()
+
+	bc.logger.Log(
+		"msg", "rollback",
+		"height", height,
+		"removed", len(removed),
+	)
+
+	if err := bc.store.Truncate(height); err != nil {
+		return err
+	}
+
+	for _, b := range removed {
+		if err := bc.unindexTransactions(b); err != nil {
+			return err
+		}
+	}
+
+	bc.notifyReorg(removed, nil)
+
+	return nil
+}
+
+// revertState returns a copy of the account state with the blocks above the
+// given height reverted. It fails with ErrReorgTooDeep when the undo of a
+// block is no longer kept.
+func (bc *Blockchain) revertState(height uint32) (*AccountState, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if height < bc.offset {
+		return nil, fmt.Errorf("%w: cannot revert to height (%d) => oldest block in memory (%d)", ErrReorgTooDeep, height, bc.offset)
+	}
+
+	state := bc.accountState.Copy()
+	for i := len(bc.undos) - 1; i > int(height-bc.offset); i-- {
+		if bc.undos[i] == nil {
+			return nil, fmt.Errorf("%w: cannot revert block (%d) => undo dropped", ErrReorgTooDeep, bc.offset+uint32(i))
+		}
+		state.revert(bc.undos[i])
+	}
+
+	return state, nil
+}
+
+func (bc *Blockchain) notifyReorg(orphaned, added []*Block) {
+	bc.lock.RLock()
+	handlers := bc.reorgHandlers
+	bc.lock.RUnlock()
+
+	for _, fn := range handlers {
+		fn(orphaned, added)
+	}
+}
+
+func (bc *Blockchain) addUncle(b *Block) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if !bc.trackUncles {
+		return
+	}
+
+	bc.uncles[b.Height] = append(bc.uncles[b.Height], b)
+}
+
+func (bc *Blockchain) removeUncle(b *Block) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	hash := b.Hash(bc.blockHasher)
+	uncles := bc.uncles[b.Height]
+	for i, uncle := range uncles {
+		if uncle.Hash(bc.blockHasher) == hash {
+			bc.uncles[b.Height] = append(uncles[:i], uncles[i+1:]...)
+			return
+		}
+	}
+}
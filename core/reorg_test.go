@@ -0,0 +1,273 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorgRecordsUncles(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetTrackUncles(true)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	a1 := randomBlock(t, 1, genesisHash)
+	assert.Nil(t, bc.AddBlock(a1))
+
+	// A competing block at the same height is kept on the side.
+	b1 := randomBlock(t, 1, genesisHash)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, []*Block{b1}, bc.Uncles(1))
+	assert.Equal(t, ErrBlockKnown, bc.AddBlock(b1))
+
+	// Extending the side branch makes it the longest chain.
+	b2 := randomBlock(t, 2, BlockHasher{}.Hash(b1.Header))
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.True(t, bc.HasBlockHash(b1.Hash(BlockHasher{})))
+	assert.False(t, bc.HasBlockHash(a1.Hash(BlockHasher{})))
+	assert.Equal(t, []*Block{a1}, bc.Uncles(1))
+	assert.Empty(t, bc.Uncles(2))
+
+	stored, err := bc.store.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1.Hash(BlockHasher{}), stored.Hash(BlockHasher{}))
+	assert.Equal(t, uint32(3), bc.store.Len())
+
+	// Only the transactions of the new branch are indexed.
+	_, err = bc.GetTransaction(a1.Transactions[0].Hash(TxHasher{}))
+	assert.NotNil(t, err)
+	_, err = bc.GetTransaction(b1.Transactions[0].Hash(TxHasher{}))
+	assert.Nil(t, err)
+}
+
+func TestForkKeepsSiblings(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	a1 := randomBlock(t, 1, genesisHash)
+	b1 := randomBlock(t, 1, genesisHash)
+	assert.Nil(t, bc.AddBlock(a1))
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, uint32(1), bc.Height())
+
+	children := bc.Children(genesisHash)
+	if assert.Len(t, children, 2) {
+		assert.Equal(t, a1, children[0])
+		assert.Equal(t, b1, children[1])
+	}
+	assert.Empty(t, bc.Children(BlockHasher{}.Hash(a1.Header)))
+
+	// The stored sibling takes over once its branch is longer.
+	b2 := randomBlock(t, 2, BlockHasher{}.Hash(b1.Header))
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(2), bc.Height())
+
+	children = bc.Children(genesisHash)
+	if assert.Len(t, children, 2) {
+		assert.Equal(t, b1, children[0])
+		assert.Equal(t, a1, children[1])
+	}
+	assert.Equal(t, []*Block{b2}, bc.Children(BlockHasher{}.Hash(b1.Header)))
+}
+
+func TestUnclesDisabledByDefault(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, genesisHash)))
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, genesisHash)))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Empty(t, bc.Uncles(1))
+}
+
+func TestMaxReorgDepth(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetMaxReorgDepth(1)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	a1 := randomBlock(t, 1, genesisHash)
+	assert.Nil(t, bc.AddBlock(a1))
+	a2 := randomBlock(t, 2, BlockHasher{}.Hash(a1.Header))
+	assert.Nil(t, bc.AddBlock(a2))
+
+	// A branch forking off at the genesis would roll back two blocks, it is
+	// refused before it is kept on the side.
+	b1 := randomBlock(t, 1, genesisHash)
+	assert.ErrorIs(t, bc.AddBlock(b1), ErrReorgTooDeep)
+	assert.Len(t, bc.Children(genesisHash), 1)
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.True(t, bc.HasBlockHash(a2.Hash(BlockHasher{})))
+
+	// Forking off a1 only rolls back a single block.
+	c2 := randomBlock(t, 2, BlockHasher{}.Hash(a1.Header))
+	assert.Nil(t, bc.AddBlock(c2))
+	c3 := randomBlock(t, 3, BlockHasher{}.Hash(c2.Header))
+	assert.Nil(t, bc.AddBlock(c3))
+	assert.Equal(t, uint32(3), bc.Height())
+	assert.True(t, bc.HasBlockHash(c3.Hash(BlockHasher{})))
+	assert.False(t, bc.HasBlockHash(a2.Hash(BlockHasher{})))
+}
+
+func TestSideBlocksBelowMaxReorgDepthAreDropped(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetMaxReorgDepth(2)
+
+	a1 := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.AddBlock(a1))
+	a2 := randomBlock(t, 2, getPrevBlockHash(t, bc, 2))
+	assert.Nil(t, bc.AddBlock(a2))
+
+	side := randomBlock(t, 2, BlockHasher{}.Hash(a1.Header))
+	assert.Nil(t, bc.AddBlock(side))
+	assert.Len(t, bc.Children(BlockHasher{}.Hash(a1.Header)), 2)
+
+	// Once the tip is two blocks above the side block, a branch through it
+	// would roll back three blocks.
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 3, getPrevBlockHash(t, bc, 3))))
+	assert.Len(t, bc.Children(BlockHasher{}.Hash(a1.Header)), 2)
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 4, getPrevBlockHash(t, bc, 4))))
+	assert.Equal(t, []*Block{a2}, bc.Children(BlockHasher{}.Hash(a1.Header)))
+}
+
+// countingStore counts the blocks read from the store.
+type countingStore struct {
+	*MemoryStore
+	gets int
+}
+
+func (s *countingStore) Get(height uint32) (*Block, error) {
+	s.gets++
+	return s.MemoryStore.Get(height)
+}
+
+func TestReorgRevertsToForkOnly(t *testing.T) {
+	funded := crypto.GeneratePrivateKey()
+	genesis, err := (&Genesis{
+		Alloc: map[types.Address]uint64{funded.PublicKey().Address(): 1000},
+	}).Block()
+	assert.Nil(t, err)
+
+	store := &countingStore{MemoryStore: NewMemorystore()}
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+	// Only the blocks from height 4 on stay in memory, the older ones are
+	// read from the store.
+	bc.SetHeaderWindow(2)
+
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+	for i := 0; i < 5; i++ {
+		tx := &Transaction{To: to, Value: 10, Data: []byte{byte(i)}}
+		assert.Nil(t, tx.Sign(funded))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+	}
+
+	// A branch replacing the tip pays another account.
+	other := crypto.GeneratePrivateKey().PublicKey().Address()
+	tx := &Transaction{To: other, Value: 100}
+	assert.Nil(t, tx.Sign(funded))
+	prev, err := bc.GetHeader(4)
+	assert.Nil(t, err)
+	b5, err := NewBlockFromPrevHeader(prev, []*Transaction{tx})
+	assert.Nil(t, err)
+	assert.Nil(t, b5.Sign(crypto.GeneratePrivateKey()))
+	b6 := randomBlock(t, 6, BlockHasher{}.Hash(b5.Header))
+
+	store.gets = 0
+	assert.Nil(t, bc.AddBlock(b5))
+	assert.Nil(t, bc.AddBlock(b6))
+	assert.Equal(t, uint32(6), bc.Height())
+	assert.Zero(t, store.gets)
+
+	balance, err := bc.GetBalance(to)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(40), balance)
+	balance, err = bc.GetBalance(other)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+	balance, err = bc.GetBalance(funded.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(860), balance)
+}
+
+func TestReorgPrunedChain(t *testing.T) {
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), NewPruningStore(2), randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+	bc.SetHeaderWindow(2)
+
+	for i := uint32(1); i <= 6; i++ {
+		assert.Nil(t, bc.AddBlock(randomBlock(t, i, getPrevBlockHash(t, bc, i))))
+	}
+	_, err = bc.store.Get(0)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+
+	// A one block reorg doesn't need the pruned blocks.
+	b6 := randomBlock(t, 6, getPrevBlockHash(t, bc, 6))
+	assert.Nil(t, bc.AddBlock(b6))
+	b7 := randomBlock(t, 7, BlockHasher{}.Hash(b6.Header))
+	assert.Nil(t, bc.AddBlock(b7))
+	assert.Equal(t, uint32(7), bc.Height())
+	assert.True(t, bc.HasBlockHash(b6.Hash(BlockHasher{})))
+
+	assert.Nil(t, bc.Rollback(6))
+	assert.Equal(t, uint32(6), bc.Height())
+}
+
+func TestRollback(t *testing.T) {
+	funded := crypto.GeneratePrivateKey()
+	genesis, err := (&Genesis{
+		Alloc: map[types.Address]uint64{funded.PublicKey().Address(): 1000},
+	}).Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+	txx := make([]*Transaction, 5)
+	for i := range txx {
+		txx[i] = &Transaction{To: to, Value: 10, Data: []byte{byte(i)}}
+		assert.Nil(t, txx[i].Sign(funded))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, txx[i])))
+	}
+
+	var removed []*Block
+	bc.OnReorg(func(orphaned, added []*Block) {
+		removed = orphaned
+		assert.Empty(t, added)
+	})
+
+	assert.NotNil(t, bc.Rollback(6))
+
+	tip := bc.LastHeader()
+	assert.Nil(t, bc.Rollback(2))
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.Equal(t, uint32(3), bc.store.Len())
+	assert.Len(t, removed, 3)
+	assert.False(t, bc.HasBlockHash(BlockHasher{}.Hash(tip)))
+
+	balance, err := bc.GetBalance(to)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(20), balance)
+	balance, err = bc.GetBalance(funded.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(980), balance)
+
+	// The transactions of the removed blocks are no longer indexed.
+	_, err = bc.GetTransaction(txx[1].Hash(TxHasher{}))
+	assert.Nil(t, err)
+	for _, tx := range txx[2:] {
+		_, err = bc.GetTransaction(tx.Hash(TxHasher{}))
+		assert.NotNil(t, err)
+	}
+
+	// The chain grows again on top of the remaining blocks.
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	assert.Equal(t, uint32(3), bc.Height())
+	_, err = bc.GetTransaction(txx[2].Hash(TxHasher{}))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"github.com/ayushn2/blockchainz/types"
+)
+
+// stateUndo holds the entries of the account state a block overwrote, with
+// their values from before the block. Reverting it on the state after the
+// block gives back the state before the block, so a reorg only has to undo
+// the blocks above the fork point instead of replaying the chain.
+type stateUndo struct {
+	accounts map[types.Address]undoBalance
+	locked   map[types.Address]undoLocks
+	utxos    map[OutPoint]undoOutput
+}
+
+// The undo entries record whether the key existed, a key that didn't exist
+// is deleted again on revert.
+type (
+	undoBalance struct {
+		balance uint64
+		ok      bool
+	}
+	undoLocks struct {
+		locks []lockedBalance
+		ok    bool
+	}
+	undoOutput struct {
+		out TxOutput
+		ok  bool
+	}
+)
+
+func newStateUndo() *stateUndo {
+	return &stateUndo{
+		accounts: make(map[types.Address]undoBalance),
+		locked:   make(map[types.Address]undoLocks),
+		utxos:    make(map[OutPoint]undoOutput),
+	}
+}
+
+// record makes the state collect the entries it changes from now on, until
+// the returned undo is taken with stopRecording.
+func (s *AccountState) record() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.undo = newStateUndo()
+}
+
+// stopRecording returns the entries changed since record was called.
+func (s *AccountState) stopRecording() *stateUndo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	undo := s.undo
+	s.undo = nil
+
+	return undo
+}
+
+// The save functions keep the value of an entry before its first change,
+// the caller has to hold the lock.
+func (s *AccountState) saveAccount(addr types.Address) {
+	if s.undo == nil {
+		return
+	}
+	if _, ok := s.undo.accounts[addr]; ok {
+		return
+	}
+
+	balance, ok := s.accounts[addr]
+	s.undo.accounts[addr] = undoBalance{balance: balance, ok: ok}
+}
+
+func (s *AccountState) saveLocks(addr types.Address) {
+	if s.undo == nil {
+		return
+	}
+	if _, ok := s.undo.locked[addr]; ok {
+		return
+	}
+
+	locks, ok := s.locked[addr]
+	s.undo.locked[addr] = undoLocks{locks: append([]lockedBalance{}, locks...), ok: ok}
+}
+
+func (s *AccountState) saveOutput(op OutPoint) {
+	if s.undo == nil {
+		return
+	}
+	if _, ok := s.undo.utxos[op]; ok {
+		return
+	}
+
+	out, ok := s.utxos[op]
+	s.undo.utxos[op] = undoOutput{out: out, ok: ok}
+}
+
+// revert restores the entries of the undo on the state.
+func (s *AccountState) revert(undo *stateUndo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr, u := range undo.accounts {
+		if u.ok {
+			s.accounts[addr] = u.balance
+		} else {
+			delete(s.accounts, addr)
+		}
+	}
+
+	for addr, u := range undo.locked {
+		if u.ok {
+			s.locked[addr] = append([]lockedBalance{}, u.locks...)
+		} else {
+			delete(s.locked, addr)
+		}
+	}
+
+	for op, u := range undo.utxos {
+		if u.ok {
+			s.utxos[op] = u.out
+		} else {
+			delete(s.utxos, op)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package core
+
+import "github.com/ayushn2/blockchainz/types"
+
+// ChainStats is a summary of the chain for operators and APIs.
+type ChainStats struct {
+	Height            uint32
+	TipHash           types.Hash
+	TotalTransactions uint64
+	Accounts          int
+	// StorageSize is the size of the store in bytes, it is zero for stores
+	// that can't tell their size like the in memory store.
+	StorageSize int64
+}
+
+// sizer is implemented by stores that know how many bytes they take.
+type sizer interface {
+	Size() int64
+}
+
+// Stats returns a summary of the chain. It only reads counters that are kept
+// up to date while blocks are added, so it is cheap to call.
+func (bc *Blockchain) Stats() ChainStats {
+	bc.lock.RLock()
+	tip := bc.headers[len(bc.headers)-1]
+	stats := ChainStats{
+		Height:            bc.height(),
+		TipHash:           bc.blockHasher.Hash(tip),
+		TotalTransactions: bc.txCount,
+		Accounts:          bc.accountState.Len(),
+	}
+	bc.lock.RUnlock()
+
+	if s, ok := bc.store.(sizer); ok {
+		stats.StorageSize = s.Size()
+	}
+
+	return stats
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainStats(t *testing.T) {
+	funded := crypto.GeneratePrivateKey()
+	genesis, err := (&Genesis{
+		Alloc: map[types.Address]uint64{
+			funded.PublicKey().Address(): 1000,
+		},
+	}).Block()
+	assert.Nil(t, err)
+
+	store, err := NewBoltStorage(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer store.Close()
+
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		tx := &Transaction{
+			To:    crypto.GeneratePrivateKey().PublicKey().Address(),
+			Value: 10,
+		}
+		assert.Nil(t, tx.Sign(funded))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+	}
+
+	stats := bc.Stats()
+	assert.Equal(t, uint32(3), stats.Height)
+	assert.Equal(t, BlockHasher{}.Hash(bc.LastHeader()), stats.TipHash)
+	// The genesis mint plus one transfer per block.
+	assert.Equal(t, uint64(4), stats.TotalTransactions)
+	assert.Equal(t, 4, stats.Accounts)
+	assert.Greater(t, stats.StorageSize, int64(0))
+}
@@ -1,16 +1,108 @@
 package core
 
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ayushn2/blockchainz/types"
+)
+
 type Storage interface {
 	Put(*Block) error
+	Get(height uint32) (*Block, error)
+	// Len returns the number of blocks in the store.
+	Len() uint32
+	// Truncate removes all blocks above the given height.
+	Truncate(height uint32) error
+	// PutTxIndex records the height of the block the transaction with the
+	// given hash is included in.
+	PutTxIndex(hash types.Hash, height uint32) error
+	GetTxIndex(hash types.Hash) (uint32, error)
+	// DeleteTxIndex removes the transaction from the index, deleting a
+	// transaction that isn't indexed is not an error.
+	DeleteTxIndex(hash types.Hash) error
 }
 
 type MemoryStore struct {
+	lock    sync.RWMutex
+	blocks  []*Block
+	txIndex map[types.Hash]uint32
 }
 
 func NewMemorystore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		txIndex: make(map[types.Hash]uint32),
+	}
 }
 
 func (s *MemoryStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(b.Height) != len(s.blocks) {
+		return fmt.Errorf("cannot store block with height (%d) => store has (%d) blocks", b.Height, len(s.blocks))
+	}
+
+	s.blocks = append(s.blocks, b)
+
 	return nil
 }
+
+func (s *MemoryStore) Get(height uint32) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.blocks) {
+		return nil, fmt.Errorf("block with height (%d) not found", height)
+	}
+
+	return s.blocks[height], nil
+}
+
+func (s *MemoryStore) Len() uint32 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return uint32(len(s.blocks))
+}
+
+func (s *MemoryStore) Truncate(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(height) < len(s.blocks) {
+		s.blocks = s.blocks[:height+1]
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) PutTxIndex(hash types.Hash, height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.txIndex[hash] = height
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteTxIndex(hash types.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.txIndex, hash)
+
+	return nil
+}
+
+func (s *MemoryStore) GetTxIndex(hash types.Hash) (uint32, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	height, ok := s.txIndex[hash]
+	if !ok {
+		return 0, fmt.Errorf("transaction (%s) not found", hash)
+	}
+
+	return height, nil
+}
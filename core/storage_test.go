@@ -0,0 +1,199 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	tx := randomTxWithSignature(t)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, &tx)))
+
+	found, err := bc.GetTransaction(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, &tx, found)
+
+	_, err = bc.GetTransaction(types.Hash{})
+	assert.NotNil(t, err)
+}
+
+func TestStorageDeleteTxIndex(t *testing.T) {
+	bolt, err := NewBoltStorage(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer bolt.Close()
+
+	for _, store := range []Storage{NewMemorystore(), bolt} {
+		hash := types.Hash{1}
+		assert.Nil(t, store.PutTxIndex(hash, 3))
+		assert.Nil(t, store.DeleteTxIndex(hash))
+		_, err := store.GetTxIndex(hash)
+		assert.NotNil(t, err)
+
+		// Deleting an unknown transaction is fine.
+		assert.Nil(t, store.DeleteTxIndex(hash))
+	}
+}
+
+func TestBoltStorageReopen(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "chain.db")
+		genesis = randomBlock(t, 0, types.Hash{})
+		privKey = crypto.GeneratePrivateKey()
+		tx      = NewTransaction([]byte("persisted"))
+	)
+	assert.Nil(t, tx.Sign(privKey))
+
+	store, err := NewBoltStorage(path)
+	assert.Nil(t, err)
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	assert.Nil(t, store.Close())
+
+	store, err = NewBoltStorage(path)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	bc, err = NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), bc.Height())
+
+	found, err := bc.GetTransaction(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, tx.Data, found.Data)
+	assert.Nil(t, found.Verify())
+
+	// A store created with another genesis should not be loaded.
+	_, err = NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.NotNil(t, err)
+}
+
+func TestBoltStorageTruncate(t *testing.T) {
+	store, err := NewBoltStorage(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer store.Close()
+
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	assert.Nil(t, store.Truncate(2))
+	assert.Equal(t, uint32(3), store.Len())
+	_, err = store.Get(3)
+	assert.NotNil(t, err)
+}
+
+func TestBoltStorageCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	store, err := NewBoltStorage(path)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	privKey := crypto.GeneratePrivateKey()
+	var blocks []*Block
+	for i := 0; i < 100; i++ {
+		tx := NewTransaction(make([]byte, 4096))
+		tx.Timestamp = int64(i)
+		assert.Nil(t, tx.Sign(privKey))
+
+		b := nextBlock(t, bc, tx)
+		assert.Nil(t, bc.AddBlock(b))
+		blocks = append(blocks, b)
+	}
+
+	assert.Nil(t, store.Truncate(10))
+	fileSize := func() int64 {
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		return info.Size()
+	}
+	before, sizeBefore := fileSize(), store.Size()
+
+	assert.Nil(t, store.Compact())
+	assert.Less(t, fileSize(), before)
+	assert.Less(t, store.Size(), sizeBefore)
+
+	assert.Equal(t, uint32(11), store.Len())
+	for _, b := range blocks[:10] {
+		stored, err := store.Get(b.Height)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), stored.Hash(BlockHasher{}))
+
+		height, err := store.GetTxIndex(b.Transactions[0].Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, b.Height, height)
+	}
+	_, err = os.Stat(compactPath(path))
+	assert.True(t, os.IsNotExist(err))
+
+	// The compacted database takes new blocks.
+	assert.Nil(t, bc.Rollback(10))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	assert.Equal(t, uint32(12), store.Len())
+
+	// Scheduled compaction picks up later deletions.
+	assert.Nil(t, bc.Rollback(2))
+	before = fileSize()
+	store.SetCompactionInterval(10*time.Millisecond, func(err error) { assert.Nil(t, err) })
+	assert.Eventually(t, func() bool {
+		return fileSize() < before
+	}, time.Second, 10*time.Millisecond)
+	done := store.compactDone
+	store.SetCompactionInterval(0, nil)
+	assert.Equal(t, uint32(3), store.Len())
+
+	// Stopping waits for the compaction goroutine to return.
+	select {
+	case <-done:
+	default:
+		t.Fatal("compaction still running after it was stopped")
+	}
+}
+
+func TestPruningStore(t *testing.T) {
+	store := NewPruningStore(5)
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+	bc.SetHeaderWindow(5)
+
+	var blocks []*Block
+	for i := 0; i < 30; i++ {
+		b := nextBlock(t, bc, randomTxx(t, 1)...)
+		assert.Nil(t, bc.AddBlock(b))
+		blocks = append(blocks, b)
+	}
+
+	// The body of an old block is gone, its header is still there.
+	_, err = bc.GetBlock(2)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	header, err := bc.GetHeader(2)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[1].Hash(BlockHasher{}), BlockHasher{}.Hash(header))
+
+	_, err = bc.GetTransaction(blocks[1].Transactions[0].Hash(TxHasher{}))
+	assert.ErrorIs(t, err, ErrBlockPruned)
+
+	// Recent blocks are kept in full.
+	b, err := bc.GetBlock(28)
+	assert.Nil(t, err)
+	assert.Len(t, b.Transactions, 1)
+
+	// New blocks are still validated against the chain.
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	assert.NotNil(t, bc.AddBlock(randomBlock(t, 32, types.Hash{})))
+	assert.Equal(t, uint32(31), bc.Height())
+}
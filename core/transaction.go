@@ -1,19 +1,61 @@
 package core
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/ayushn2/blockchainz/crypto"
 	"github.com/ayushn2/blockchainz/types"
 )
 
+var ErrTxTimestamp = errors.New("transaction timestamp out of range")
+
+const (
+	// TxGas is the gas used by every transaction.
+	TxGas uint64 = 1000
+	// TxDataGas is the gas used per byte of transaction data.
+	TxDataGas uint64 = 10
+)
+
 type Transaction struct {
-	Data      []byte
+	Data  []byte
+	To    types.Address
+	Value uint64
+	// Fee is paid by the sender to the validator including the transaction.
+	Fee uint64
+	// Timestamp is the time the sender created the transaction at, in unix
+	// nanoseconds. It is signed, zero means the sender declared no time.
+	Timestamp int64
+	// Nonce numbers the transactions of a sender. It is signed, a pending
+	// transaction with the same sender and nonce can be replaced by one
+	// paying a higher fee. Zero means the transaction has no nonce and is
+	// never replaced.
+	Nonce uint64
+
 	From      crypto.PublicKey
 	Signature *crypto.Signature
+	// SigScheme is the scheme of the signature, set when signing. Its zero
+	// value is ECDSA, the scheme of transactions signed before it existed.
+	SigScheme crypto.SigScheme
+
+	// Inputs and Outputs, when set, move value between unspent outputs
+	// instead of accounts. A transaction with inputs is signed per input and
+	// has no sender, one without inputs pays its outputs from the sender.
+	Inputs  []*TxInput
+	Outputs []*TxOutput
 
 	// cached version of the tx data hash
 	hash types.Hash
+	// sender caches the address of From, senderKey is the key it was
+	// derived from so a changed From is noticed.
+	sender    types.Address
+	senderKey *ecdsa.PublicKey
 }
 
 func NewTransaction(data []byte) *Transaction {
@@ -22,6 +64,93 @@ func NewTransaction(data []byte) *Transaction {
 	}
 }
 
+// NewCoinbaseTransaction returns the unsigned transaction paying the reward
+// and fees of a block to its validator.
+func NewCoinbaseTransaction(to types.Address, amount uint64) *Transaction {
+	return &Transaction{
+		To:    to,
+		Value: amount,
+	}
+}
+
+// CoinbaseAmount returns the amount the coinbase of a block with the given
+// transactions pays, the reward plus their fees. A sum that doesn't fit into
+// an uint64 fails with ErrOverflow.
+func CoinbaseAmount(reward uint64, txx []*Transaction) (uint64, error) {
+	amount := reward
+	for _, tx := range txx {
+		var err error
+		if amount, err = safeAdd(amount, tx.Fee); err != nil {
+			return 0, fmt.Errorf("coinbase amount: %w", err)
+		}
+	}
+
+	return amount, nil
+}
+
+// Cost returns the total amount debited from the sender, the value plus the
+// fee. A sum that does not fit into an uint64 saturates at math.MaxUint64 so
+// it can never be covered by a balance.
+func (tx *Transaction) Cost() uint64 {
+	if tx.Value > math.MaxUint64-tx.Fee {
+		return math.MaxUint64
+	}
+
+	return tx.Value + tx.Fee
+}
+
+// Gas returns the gas used by the transaction, TxGas plus TxDataGas for
+// every byte of data. A coinbase uses no gas.
+func (tx *Transaction) Gas() uint64 {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	return TxGas + TxDataGas*uint64(len(tx.Data))
+}
+
+// Size returns the length of the canonical encoding of the transaction in
+// bytes, the space it takes up in a block.
+func (tx *Transaction) Size() int {
+	buf := &bytes.Buffer{}
+	NewCanonicalTxEncoder(buf).Encode(tx)
+
+	return buf.Len()
+}
+
+// FeePerByte returns the fee paid per byte of the transaction, so
+// transactions of different sizes can be compared.
+func (tx *Transaction) FeePerByte() float64 {
+	return float64(tx.Fee) / float64(tx.Size())
+}
+
+// IsCoinbase returns true if the transaction has no sender and no signature,
+// which is only valid for the first transaction of a block.
+func (tx *Transaction) IsCoinbase() bool {
+	return tx.From.IsZero() && tx.Signature == nil && !tx.IsUTXO()
+}
+
+// Sender returns the address of the sender, the zero address if the
+// transaction has no sender. The address is derived once and cached until
+// From changes.
+func (tx *Transaction) Sender() types.Address {
+	if tx.From.IsZero() {
+		return types.ZeroAddress()
+	}
+
+	// Deriving the address of an Ed25519 key is a single hash.
+	if tx.From.Key == nil {
+		return tx.From.Address()
+	}
+
+	if tx.senderKey != tx.From.Key {
+		tx.sender = tx.From.Address()
+		tx.senderKey = tx.From.Key
+	}
+
+	return tx.sender
+}
+
 func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 	if tx.hash.IsZero() {
 		tx.hash = hasher.Hash(tx)
@@ -29,24 +158,158 @@ func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 	return tx.hash
 }
 
+// signingBytes returns the fields of the transaction that are covered by
+// the signature. The sender is included so the same payload sent by two
+// accounts does not end up with the same hash.
+func (tx *Transaction) signingBytes() []byte {
+	buf := &bytes.Buffer{}
+	// Data is length prefixed, so its bytes can't be read as the fields
+	// following it.
+	binary.Write(buf, binary.LittleEndian, uint32(len(tx.Data)))
+	buf.Write(tx.Data)
+	buf.Write(tx.To.ToSlice())
+	binary.Write(buf, binary.LittleEndian, tx.Value)
+	binary.Write(buf, binary.LittleEndian, tx.Fee)
+	binary.Write(buf, binary.LittleEndian, tx.Timestamp)
+	binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	buf.WriteByte(byte(tx.SigScheme))
+	if !tx.From.IsZero() {
+		buf.Write(tx.From.ToSlice())
+	}
+	tx.writeUTXO(buf)
+
+	return buf.Bytes()
+}
+
+// ContentHash returns the hash of the logical content of the transaction,
+// the sender and the signed fields. It stays the same however the
+// transaction is encoded or signed.
+func (tx *Transaction) ContentHash() types.Hash {
+	content := &Transaction{
+		Data:      tx.Data,
+		To:        tx.To,
+		Value:     tx.Value,
+		Fee:       tx.Fee,
+		Timestamp: tx.Timestamp,
+		Nonce:     tx.Nonce,
+		From:      tx.From,
+		SigScheme: tx.SigScheme,
+		Outputs:   tx.Outputs,
+	}
+	for _, in := range tx.Inputs {
+		content.Inputs = append(content.Inputs, &TxInput{PrevOut: in.PrevOut})
+	}
+
+	buf := &bytes.Buffer{}
+	NewCanonicalTxEncoder(buf).Encode(content)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+// Equal returns true if both transactions have the same sender and the same
+// signed fields, the signatures themselves may differ.
+func (tx *Transaction) Equal(other *Transaction) bool {
+	if other == nil {
+		return false
+	}
+
+	if tx.From.IsZero() != other.From.IsZero() {
+		return false
+	}
+	if !tx.From.IsZero() && !bytes.Equal(tx.From.ToSlice(), other.From.ToSlice()) {
+		return false
+	}
+
+	var a, b bytes.Buffer
+	tx.writeUTXO(&a)
+	other.writeUTXO(&b)
+
+	return bytes.Equal(tx.Data, other.Data) &&
+		tx.To == other.To &&
+		tx.Value == other.Value &&
+		tx.Fee == other.Fee &&
+		tx.Timestamp == other.Timestamp &&
+		tx.Nonce == other.Nonce &&
+		tx.SigScheme == other.SigScheme &&
+		len(tx.Inputs) == len(other.Inputs) &&
+		len(tx.Outputs) == len(other.Outputs) &&
+		bytes.Equal(a.Bytes(), b.Bytes())
+}
+
+// ValidateTimestamp checks that the declared time of the transaction is at
+// most tolerance away from now, a transaction without a timestamp fails.
+func (tx *Transaction) ValidateTimestamp(now time.Time, tolerance time.Duration) error {
+	if tx.Timestamp == 0 {
+		return fmt.Errorf("%w: transaction (%s) has no timestamp", ErrTxTimestamp, tx.Hash(TxHasher{}))
+	}
+
+	diff := now.Sub(time.Unix(0, tx.Timestamp))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > tolerance {
+		return fmt.Errorf("%w: transaction (%s) is (%s) off => tolerance (%s)", ErrTxTimestamp, tx.Hash(TxHasher{}), diff, tolerance)
+	}
+
+	return nil
+}
+
 func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(tx.Data)
+	return tx.sign(privKey, privKey.PublicKey(), privKey.Scheme())
+}
+
+// SignAll signs every transaction with the key. The public key, the scheme
+// and the sender address are derived once for the whole batch. Signing
+// stops at the first failure, the transactions before it stay signed.
+func SignAll(privKey crypto.PrivateKey, txx []*Transaction) error {
+	var (
+		pub    = privKey.PublicKey()
+		scheme = privKey.Scheme()
+		sender = pub.Address()
+	)
+
+	for i, tx := range txx {
+		if err := tx.sign(privKey, pub, scheme); err != nil {
+			return fmt.Errorf("transaction (%d): %w", i, err)
+		}
+		tx.sender, tx.senderKey = sender, pub.Key
+	}
+
+	return nil
+}
+
+func (tx *Transaction) sign(privKey crypto.PrivateKey, pub crypto.PublicKey, scheme crypto.SigScheme) error {
+	tx.From = pub
+	tx.SigScheme = scheme
+	// A hash cached before the sender was set is stale.
+	tx.hash = types.Hash{}
+
+	hash := TxHasher{}.Hash(tx)
+	sig, err := privKey.Sign(hash.ToSlice())
 	if err != nil {
 		return err
 	}
 
-	tx.From = privKey.PublicKey()
 	tx.Signature = sig
 
 	return nil
 }
 
 func (tx *Transaction) Verify() error {
+	if tx.IsUTXO() {
+		return tx.verifyInputs()
+	}
+
 	if tx.Signature == nil {
 		return fmt.Errorf("transaction has no signature")
 	}
 
-	if !tx.Signature.Verify(tx.From, tx.Data) {
+	if tx.From.IsZero() {
+		return fmt.Errorf("transaction has no sender")
+	}
+
+	hash := TxHasher{}.Hash(tx)
+	if !tx.Signature.VerifyWithScheme(tx.SigScheme, tx.From, hash[:]) {
 		return fmt.Errorf("invalid transaction signature")
 	}
 
@@ -2,9 +2,13 @@ package core
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -48,7 +52,7 @@ func TestTxEncodeDecode(t *testing.T){
 	assert.Equal(t, &tx, txDecoded)
 }
 
-func randomTxWithSignature(t *testing.T) Transaction {
+func randomTxWithSignature(t testing.TB) Transaction {
 	privKey := crypto.GeneratePrivateKey()
 	tx := Transaction{
 		Data: []byte("test transaction"),
@@ -56,4 +60,208 @@ func randomTxWithSignature(t *testing.T) Transaction {
 	err := tx.Sign(privKey)
 	assert.Nil(t, err, "Transaction should be signed successfully")
 	return tx
-}
\ No newline at end of file
+}
+func TestTransactionEqual(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	a := NewTransaction([]byte("foo"))
+	assert.Nil(t, a.Sign(privKey))
+	b := NewTransaction([]byte("foo"))
+	assert.Nil(t, b.Sign(privKey))
+
+	assert.True(t, a.Equal(b))
+	assert.Equal(t, a.ContentHash(), b.ContentHash())
+
+	c := NewTransaction([]byte("foo"))
+	assert.Nil(t, c.Sign(crypto.GeneratePrivateKey()))
+	assert.False(t, a.Equal(c))
+	assert.NotEqual(t, a.Hash(TxHasher{}), c.Hash(TxHasher{}))
+}
+
+func TestTransactionTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tx := &Transaction{
+		Data:      []byte("timed"),
+		Timestamp: now.UnixNano(),
+	}
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, tx.Verify())
+
+	assert.Nil(t, tx.ValidateTimestamp(now.Add(time.Minute), time.Minute))
+	assert.Nil(t, tx.ValidateTimestamp(now.Add(-time.Minute), time.Minute))
+	assert.ErrorIs(t, tx.ValidateTimestamp(now.Add(time.Hour), time.Minute), ErrTxTimestamp)
+	assert.ErrorIs(t, tx.ValidateTimestamp(now.Add(-time.Hour), time.Minute), ErrTxTimestamp)
+
+	// The timestamp is signed.
+	tx.Timestamp++
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify())
+
+	assert.ErrorIs(t, (&Transaction{}).ValidateTimestamp(now, time.Minute), ErrTxTimestamp)
+}
+
+func TestTransactionSigScheme(t *testing.T) {
+	tx := &Transaction{Data: []byte("ed25519")}
+	assert.Nil(t, tx.Sign(crypto.GenerateEd25519PrivateKey()))
+	assert.Equal(t, crypto.SchemeEd25519, tx.SigScheme)
+	assert.Nil(t, tx.Verify())
+
+	// Verified under the wrong scheme the signature fails.
+	tx.SigScheme = crypto.SchemeECDSA
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify())
+
+	// Transactions signed before schemes were tagged default to ECDSA.
+	legacy := &Transaction{Data: []byte("ecdsa")}
+	assert.Nil(t, legacy.Sign(crypto.GeneratePrivateKey()))
+	assert.Equal(t, crypto.SchemeECDSA, legacy.SigScheme)
+	assert.Nil(t, legacy.Verify())
+
+	// The scheme survives encoding.
+	buf := &bytes.Buffer{}
+	signed := &Transaction{Data: []byte("encoded")}
+	assert.Nil(t, signed.Sign(crypto.GenerateEd25519PrivateKey()))
+	assert.Nil(t, signed.Encode(NewGobTxEncoder(buf)))
+	decoded := new(Transaction)
+	assert.Nil(t, decoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, decoded.Verify())
+	assert.Equal(t, signed.Sender(), decoded.Sender())
+}
+
+func TestTransactionNonceSigned(t *testing.T) {
+	tx := &Transaction{Data: []byte("nonce"), Nonce: 1}
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, tx.Verify())
+
+	other := &Transaction{Data: []byte("nonce"), Nonce: 2, From: tx.From}
+	assert.False(t, tx.Equal(other))
+	assert.NotEqual(t, tx.ContentHash(), other.ContentHash())
+
+	tx.Nonce++
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify())
+}
+
+func TestTransactionCost(t *testing.T) {
+	tx := &Transaction{Value: 100, Fee: 5}
+	assert.Equal(t, uint64(105), tx.Cost())
+
+	tx = &Transaction{Value: math.MaxUint64, Fee: 0}
+	assert.Equal(t, uint64(math.MaxUint64), tx.Cost())
+
+	tx = &Transaction{Value: math.MaxUint64, Fee: 1}
+	assert.Equal(t, uint64(math.MaxUint64), tx.Cost())
+
+	tx = &Transaction{Value: math.MaxUint64 / 2, Fee: math.MaxUint64/2 + 2}
+	assert.Equal(t, uint64(math.MaxUint64), tx.Cost())
+}
+
+func TestTransactionFeePerByte(t *testing.T) {
+	small := &Transaction{Data: []byte("a"), Fee: 100}
+	large := &Transaction{Data: bytes.Repeat([]byte("a"), 100), Fee: 100}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, small.Encode(NewCanonicalTxEncoder(buf)))
+	assert.Equal(t, buf.Len(), small.Size())
+	assert.Equal(t, small.Size()+99, large.Size())
+
+	assert.Equal(t, float64(100)/float64(small.Size()), small.FeePerByte())
+	assert.Greater(t, small.FeePerByte(), large.FeePerByte())
+}
+
+func TestTransactionSender(t *testing.T) {
+	tx := NewTransaction([]byte("foo"))
+	assert.True(t, tx.Sender().IsZero())
+
+	privKey := crypto.GeneratePrivateKey()
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Equal(t, privKey.PublicKey().Address(), tx.Sender())
+
+	// A new sender is picked up.
+	other := crypto.GeneratePrivateKey()
+	tx.From = other.PublicKey()
+	assert.Equal(t, other.PublicKey().Address(), tx.Sender())
+}
+
+func TestSignAll(t *testing.T) {
+	for _, privKey := range []crypto.PrivateKey{crypto.GeneratePrivateKey(), crypto.GenerateEd25519PrivateKey()} {
+		txx := unsignedTxx(10)
+		assert.Nil(t, SignAll(privKey, txx))
+		for _, tx := range txx {
+			assert.Nil(t, tx.Verify())
+			assert.Equal(t, privKey.Scheme(), tx.SigScheme)
+			assert.Equal(t, privKey.PublicKey().Address(), tx.Sender())
+		}
+
+		// A transaction changed after signing no longer verifies.
+		txx[3].Data = []byte("tampered")
+		txx[3].hash = types.Hash{}
+		assert.NotNil(t, txx[3].Verify())
+	}
+}
+
+func BenchmarkSignIndividually(b *testing.B) {
+	privKey := crypto.GeneratePrivateKey()
+	txx := unsignedTxx(100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, tx := range txx {
+			if err := tx.Sign(privKey); err != nil {
+				b.Fatal(err)
+			}
+			tx.Sender()
+		}
+	}
+}
+
+func BenchmarkSignAll(b *testing.B) {
+	privKey := crypto.GeneratePrivateKey()
+	txx := unsignedTxx(100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := SignAll(privKey, txx); err != nil {
+			b.Fatal(err)
+		}
+		for _, tx := range txx {
+			tx.Sender()
+		}
+	}
+}
+
+func unsignedTxx(n int) []*Transaction {
+	txx := make([]*Transaction, n)
+	for i := range txx {
+		txx[i] = NewTransaction([]byte(fmt.Sprintf("tx %d", i)))
+	}
+
+	return txx
+}
+
+// The benchmarks resolve the sender of every transaction in a block a few
+// times, as building and applying a block does.
+func BenchmarkSenderDerived(b *testing.B) {
+	txx := randomTxx(b, 1000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for pass := 0; pass < 3; pass++ {
+			for _, tx := range txx {
+				tx.From.Address()
+			}
+		}
+	}
+}
+
+func BenchmarkSenderCached(b *testing.B) {
+	txx := randomTxx(b, 1000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for pass := 0; pass < 3; pass++ {
+			for _, tx := range txx {
+				tx.Sender()
+			}
+		}
+	}
+}
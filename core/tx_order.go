@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ayushn2/blockchainz/types"
+)
+
+var ErrTxDependencyCycle = errors.New("transaction dependency cycle")
+
+// applyOrder returns the indexes of the transactions of a block in the order
+// they are applied. A transaction spending an output of another transaction
+// in the same block is applied after it, apart from that the block order is
+// kept, so the coinbase stays first. Transactions depending on each other in
+// a cycle can't be applied in any order and fail with ErrTxDependencyCycle.
+//
+// Account transfers have no nonce to order them by, they are applied in
+// block order and a transfer spending funds received later in the block
+// fails.
+func applyOrder(hasher Hasher[*Transaction], txx []*Transaction) ([]int, error) {
+	index := make(map[types.Hash]int, len(txx))
+	for i, tx := range txx {
+		index[tx.Hash(hasher)] = i
+	}
+
+	var (
+		// dependents holds the transactions spending outputs of a
+		// transaction, pending the number of transactions a transaction
+		// waits for.
+		dependents = make(map[int][]int)
+		pending    = make([]int, len(txx))
+	)
+	for i, tx := range txx {
+		for _, in := range tx.Inputs {
+			if j, ok := index[in.PrevOut.TxHash]; ok {
+				dependents[j] = append(dependents[j], i)
+				pending[i]++
+			}
+		}
+	}
+
+	ready := []int{}
+	for i := range txx {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	order := make([]int, 0, len(txx))
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+
+		for _, j := range dependents[i] {
+			if pending[j]--; pending[j] == 0 {
+				// Keep ready sorted, the lowest index is applied next.
+				k := sort.SearchInts(ready, j)
+				ready = append(ready, 0)
+				copy(ready[k+1:], ready[k:])
+				ready[k] = j
+			}
+		}
+	}
+
+	if len(order) < len(txx) {
+		return nil, fmt.Errorf("%w: (%d) of (%d) transactions can't be applied", ErrTxDependencyCycle, len(txx)-len(order), len(txx))
+	}
+
+	return order, nil
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+)
+
+var (
+	ErrOutputNotFound = errors.New("unspent output not found")
+	ErrUTXOImbalance  = errors.New("inputs and outputs don't balance")
+)
+
+// OutPoint references an output of a transaction.
+type OutPoint struct {
+	TxHash types.Hash
+	Index  uint32
+}
+
+func (op OutPoint) String() string {
+	return fmt.Sprintf("%s:%d", op.TxHash, op.Index)
+}
+
+// TxInput spends an unspent output, it is signed by the key the output pays
+// to.
+type TxInput struct {
+	PrevOut   OutPoint
+	PublicKey crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+// TxOutput pays the value to the address, it can be spent once by an input
+// of a later transaction.
+type TxOutput struct {
+	To    types.Address
+	Value uint64
+}
+
+// IsUTXO returns true if the transaction spends unspent outputs instead of
+// an account balance.
+func (tx *Transaction) IsUTXO() bool {
+	return len(tx.Inputs) > 0
+}
+
+// OutPoint returns the reference to the output of the transaction with the
+// given index.
+func (tx *Transaction) OutPoint(index uint32) OutPoint {
+	return tx.OutPointWithHasher(TxHasher{}, index)
+}
+
+// OutPointWithHasher returns the reference to the output of the transaction
+// with the given index on a chain hashing transactions with hasher.
+func (tx *Transaction) OutPointWithHasher(hasher Hasher[*Transaction], index uint32) OutPoint {
+	return OutPoint{
+		TxHash: tx.Hash(hasher),
+		Index:  index,
+	}
+}
+
+// SignInput signs the input with the given index, the key has to be the one
+// the spent output pays to. Inputs and outputs can't be changed afterwards
+// without invalidating the signatures.
+func (tx *Transaction) SignInput(i int, privKey crypto.PrivateKey) error {
+	if i < 0 || i >= len(tx.Inputs) {
+		return fmt.Errorf("input index (%d) out of range => transaction has (%d) inputs", i, len(tx.Inputs))
+	}
+
+	// A hash cached before the inputs were complete is stale.
+	tx.hash = types.Hash{}
+
+	hash := TxHasher{}.Hash(tx)
+	sig, err := privKey.Sign(hash.ToSlice())
+	if err != nil {
+		return err
+	}
+
+	tx.Inputs[i].PublicKey = privKey.PublicKey()
+	tx.Inputs[i].Signature = sig
+
+	return nil
+}
+
+func (tx *Transaction) verifyInputs() error {
+	hash := TxHasher{}.Hash(tx)
+
+	for i, in := range tx.Inputs {
+		if in.Signature == nil || in.PublicKey.IsZero() {
+			return fmt.Errorf("input (%d) of transaction (%s) is not signed", i, hash)
+		}
+
+		// The scheme of the key decides how the input is verified, every
+		// input may use another one.
+		if !in.Signature.VerifyWithScheme(in.PublicKey.Scheme(), in.PublicKey, hash[:]) {
+			return fmt.Errorf("input (%d) of transaction (%s) has an invalid signature", i, hash)
+		}
+	}
+
+	return nil
+}
+
+// writeUTXO writes the spent outputs and the new outputs of the transaction,
+// the input signatures are left out so every input can be signed on its own.
+// Both lists are prefixed with their length, so the same bytes can't be
+// read as another split into inputs and outputs.
+func (tx *Transaction) writeUTXO(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		buf.Write(in.PrevOut.TxHash.ToSlice())
+		binary.Write(buf, binary.LittleEndian, in.PrevOut.Index)
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		buf.Write(out.To.ToSlice())
+		binary.Write(buf, binary.LittleEndian, out.Value)
+	}
+}
+
+// outputsValue returns the sum of the outputs of the transaction.
+func (tx *Transaction) outputsValue(hasher Hasher[*Transaction]) (uint64, error) {
+	var sum uint64
+	for _, out := range tx.Outputs {
+		var err error
+		if sum, err = safeAdd(sum, out.Value); err != nil {
+			return 0, fmt.Errorf("transaction (%s) outputs: %w", tx.Hash(hasher), err)
+		}
+	}
+
+	return sum, nil
+}
+
+// applyUTXO spends the inputs of the transaction and adds its outputs. The
+// inputs have to pay exactly the outputs plus the fee.
+func applyUTXO(state *AccountState, tx *Transaction, hasher Hasher[*Transaction]) error {
+	hash := tx.Hash(hasher)
+
+	if tx.Value > 0 {
+		return fmt.Errorf("transaction (%s) spends outputs and transfers (%d) from an account", hash, tx.Value)
+	}
+
+	var in uint64
+	for _, input := range tx.Inputs {
+		out, err := state.SpendOutput(input.PrevOut)
+		if err != nil {
+			return err
+		}
+
+		if out.To != input.PublicKey.Address() {
+			return fmt.Errorf("output (%s) pays (%s) => spent by (%s)", input.PrevOut, out.To, input.PublicKey.Address())
+		}
+
+		if in, err = safeAdd(in, out.Value); err != nil {
+			return fmt.Errorf("transaction (%s) inputs: %w", hash, err)
+		}
+	}
+
+	out, err := tx.outputsValue(hasher)
+	if err != nil {
+		return err
+	}
+	if out, err = safeAdd(out, tx.Fee); err != nil {
+		return fmt.Errorf("transaction (%s) outputs: %w", hash, err)
+	}
+
+	if in != out {
+		return fmt.Errorf("%w: transaction (%s) spends (%d) => outputs and fee (%d)", ErrUTXOImbalance, hash, in, out)
+	}
+
+	return addOutputs(state, tx, hasher)
+}
+
+func addOutputs(state *AccountState, tx *Transaction, hasher Hasher[*Transaction]) error {
+	for i, out := range tx.Outputs {
+		if err := state.AddOutput(tx.OutPointWithHasher(hasher, uint32(i)), *out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *AccountState) AddOutput(op OutPoint, out TxOutput) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.utxos[op]; ok {
+		return fmt.Errorf("output (%s) already exists", op)
+	}
+
+	s.saveOutput(op)
+	s.utxos[op] = out
+
+	return nil
+}
+
+// SpendOutput removes the unspent output and returns it, spending an output
+// twice fails with ErrOutputNotFound.
+func (s *AccountState) SpendOutput(op OutPoint) (TxOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out, ok := s.utxos[op]
+	if !ok {
+		return TxOutput{}, fmt.Errorf("%w: (%s)", ErrOutputNotFound, op)
+	}
+
+	s.saveOutput(op)
+	delete(s.utxos, op)
+
+	return out, nil
+}
+
+func (s *AccountState) GetOutput(op OutPoint) (TxOutput, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out, ok := s.utxos[op]
+	if !ok {
+		return TxOutput{}, fmt.Errorf("%w: (%s)", ErrOutputNotFound, op)
+	}
+
+	return out, nil
+}
+
+// GetUnspentOutput returns the output if it is not spent yet.
+func (bc *Blockchain) GetUnspentOutput(op OutPoint) (TxOutput, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.accountState.GetOutput(op)
+}
@@ -0,0 +1,290 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// newUTXOChain returns a chain with an output of 100 paid to owner by a
+// funded account.
+func newUTXOChain(t *testing.T, owner crypto.PrivateKey) (*Blockchain, OutPoint) {
+	funded := crypto.GeneratePrivateKey()
+	genesis, err := (&Genesis{
+		Alloc: map[types.Address]uint64{funded.PublicKey().Address(): 1000},
+	}).Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	tx := &Transaction{
+		Outputs: []*TxOutput{{To: owner.PublicKey().Address(), Value: 100}},
+	}
+	assert.Nil(t, tx.Sign(funded))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+
+	balance, err := bc.GetBalance(funded.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(900), balance)
+
+	return bc, tx.OutPoint(0)
+}
+
+func TestSpendUTXO(t *testing.T) {
+	owner := crypto.GeneratePrivateKey()
+	bc, op := newUTXOChain(t, owner)
+
+	out, err := bc.GetUnspentOutput(op)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), out.Value)
+
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+	tx := &Transaction{
+		Fee:    10,
+		Inputs: []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{
+			{To: to, Value: 60},
+			{To: owner.PublicKey().Address(), Value: 30},
+		},
+	}
+	assert.Nil(t, tx.SignInput(0, owner))
+	assert.Nil(t, tx.Verify())
+	assert.False(t, tx.IsCoinbase())
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+
+	_, err = bc.GetUnspentOutput(op)
+	assert.ErrorIs(t, err, ErrOutputNotFound)
+
+	out, err = bc.GetUnspentOutput(tx.OutPoint(0))
+	assert.Nil(t, err)
+	assert.Equal(t, TxOutput{To: to, Value: 60}, out)
+}
+
+func TestSpendUTXOWithEd25519Key(t *testing.T) {
+	owner := crypto.GenerateEd25519PrivateKey()
+	bc, op := newUTXOChain(t, owner)
+
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+	tx := &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: to, Value: 100}},
+	}
+	assert.Nil(t, tx.SignInput(0, owner))
+	assert.Nil(t, tx.Verify())
+
+	// The key survives the encoding the transaction is relayed in.
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
+	decoded := new(Transaction)
+	assert.Nil(t, decoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, decoded.Verify())
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+
+	out, err := bc.GetUnspentOutput(tx.OutPoint(0))
+	assert.Nil(t, err)
+	assert.Equal(t, TxOutput{To: to, Value: 100}, out)
+
+	// Another Ed25519 key can't spend the output.
+	bc, op = newUTXOChain(t, owner)
+	tx = &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: to, Value: 100}},
+	}
+	assert.Nil(t, tx.SignInput(0, crypto.GenerateEd25519PrivateKey()))
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+}
+
+func TestUTXODoubleSpend(t *testing.T) {
+	owner := crypto.GeneratePrivateKey()
+	bc, op := newUTXOChain(t, owner)
+
+	spend := func(to types.Address) *Transaction {
+		tx := &Transaction{
+			Inputs:  []*TxInput{{PrevOut: op}},
+			Outputs: []*TxOutput{{To: to, Value: 100}},
+		}
+		assert.Nil(t, tx.SignInput(0, owner))
+		return tx
+	}
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, spend(crypto.GeneratePrivateKey().PublicKey().Address()))))
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc, spend(owner.PublicKey().Address()))), ErrOutputNotFound)
+	assert.Equal(t, uint32(2), bc.Height())
+
+	// Spending the same output twice in one block fails as well.
+	bc, op = newUTXOChain(t, owner)
+	a, b := spend(owner.PublicKey().Address()), spend(crypto.GeneratePrivateKey().PublicKey().Address())
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc, a, b)), ErrOutputNotFound)
+}
+
+func TestUTXORejectsInvalidSpend(t *testing.T) {
+	owner := crypto.GeneratePrivateKey()
+	bc, op := newUTXOChain(t, owner)
+
+	// The outputs pay more than the input holds.
+	tx := &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: owner.PublicKey().Address(), Value: 101}},
+	}
+	assert.Nil(t, tx.SignInput(0, owner))
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc, tx)), ErrUTXOImbalance)
+
+	// Only the owner of the output can spend it.
+	tx = &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: owner.PublicKey().Address(), Value: 100}},
+	}
+	assert.Nil(t, tx.SignInput(0, crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, tx)))
+
+	// Changing the outputs after signing invalidates the input signature.
+	tx = &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: owner.PublicKey().Address(), Value: 100}},
+	}
+	assert.Nil(t, tx.SignInput(0, owner))
+	tx.Outputs[0].To = crypto.GeneratePrivateKey().PublicKey().Address()
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify())
+
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestUTXODependentTransactionsInOneBlock(t *testing.T) {
+	owner, next := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc, op := newUTXOChain(t, owner)
+
+	parent := &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: next.PublicKey().Address(), Value: 100}},
+	}
+	assert.Nil(t, parent.SignInput(0, owner))
+
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+	child := &Transaction{
+		Fee:     10,
+		Inputs:  []*TxInput{{PrevOut: parent.OutPoint(0)}},
+		Outputs: []*TxOutput{{To: to, Value: 90}},
+	}
+	assert.Nil(t, child.SignInput(0, next))
+
+	other := randomTxWithSignature(t)
+	txx := []*Transaction{child, &other, parent}
+	order, err := applyOrder(TxHasher{}, txx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 0}, order)
+
+	// The child comes first in the block, it is applied after its parent.
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, child, parent)))
+
+	_, err = bc.GetUnspentOutput(parent.OutPoint(0))
+	assert.ErrorIs(t, err, ErrOutputNotFound)
+
+	out, err := bc.GetUnspentOutput(child.OutPoint(0))
+	assert.Nil(t, err)
+	assert.Equal(t, TxOutput{To: to, Value: 90}, out)
+}
+
+// txCountingHasher hashes transactions differently from TxHasher.
+type txCountingHasher struct {
+	calls int
+}
+
+func (h *txCountingHasher) Hash(tx *Transaction) types.Hash {
+	h.calls++
+	hash := TxHasher{}.Hash(tx)
+	return sha256.Sum256(hash[:])
+}
+
+func TestUTXOWithTxHasher(t *testing.T) {
+	funded, owner, next := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	genesis, err := (&Genesis{
+		Alloc: map[types.Address]uint64{funded.PublicKey().Address(): 1000},
+	}).Block()
+	assert.Nil(t, err)
+
+	hasher := &txCountingHasher{}
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis, WithTxHasher(hasher))
+	assert.Nil(t, err)
+
+	funding := &Transaction{
+		Outputs: []*TxOutput{{To: owner.PublicKey().Address(), Value: 100}},
+	}
+	assert.Nil(t, funding.Sign(funded))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, funding)))
+
+	op := funding.OutPointWithHasher(bc.TxHasher(), 0)
+	assert.NotEqual(t, TxHasher{}.Hash(funding), op.TxHash)
+
+	out, err := bc.GetUnspentOutput(op)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), out.Value)
+
+	// The child spends its parent in the same block, both are referenced
+	// by the hash of the chain.
+	parent := &Transaction{
+		Inputs:  []*TxInput{{PrevOut: op}},
+		Outputs: []*TxOutput{{To: next.PublicKey().Address(), Value: 100}},
+	}
+	assert.Nil(t, parent.SignInput(0, owner))
+
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+	child := &Transaction{
+		Inputs:  []*TxInput{{PrevOut: parent.OutPointWithHasher(bc.TxHasher(), 0)}},
+		Outputs: []*TxOutput{{To: to, Value: 100}},
+	}
+	assert.Nil(t, child.SignInput(0, next))
+
+	assert.Nil(t, bc.CheckTransaction(parent))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, child, parent)))
+	assert.NotZero(t, hasher.calls)
+
+	_, err = bc.GetUnspentOutput(op)
+	assert.ErrorIs(t, err, ErrOutputNotFound)
+
+	out, err = bc.GetUnspentOutput(child.OutPointWithHasher(bc.TxHasher(), 0))
+	assert.Nil(t, err)
+	assert.Equal(t, TxOutput{To: to, Value: 100}, out)
+}
+
+// Without length prefixes 8 inputs and 1 input with 9 outputs could be
+// written as the same 288 bytes.
+func TestUTXOSigningBytesUnambiguous(t *testing.T) {
+	const inputSize, outputSize = 36, 28
+	raw := make([]byte, 8*inputSize)
+	_, err := rand.Read(raw)
+	assert.Nil(t, err)
+
+	input := func(b []byte) *TxInput {
+		return &TxInput{PrevOut: OutPoint{
+			TxHash: types.HashFromBytes(b[:32]),
+			Index:  binary.LittleEndian.Uint32(b[32:inputSize]),
+		}}
+	}
+
+	inputsOnly := NewTransaction(nil)
+	for i := 0; i < 8; i++ {
+		inputsOnly.Inputs = append(inputsOnly.Inputs, input(raw[i*inputSize:]))
+	}
+
+	mixed := NewTransaction(nil)
+	mixed.Inputs = []*TxInput{input(raw)}
+	for i := 0; i < 9; i++ {
+		b := raw[inputSize+i*outputSize:]
+		mixed.Outputs = append(mixed.Outputs, &TxOutput{
+			To:    types.AddressFromBytes(b[:20]),
+			Value: binary.LittleEndian.Uint64(b[20:outputSize]),
+		})
+	}
+
+	assert.NotEqual(t, inputsOnly.signingBytes(), mixed.signingBytes())
+}
@@ -3,14 +3,81 @@ package core
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
-var ErrBlockKnown = errors.New("block already known")
+var (
+	ErrBlockKnown            = errors.New("block already known")
+	ErrGenesisMismatch       = errors.New("genesis block mismatch")
+	ErrUnauthorizedValidator = errors.New("unauthorized validator")
+	ErrCheckpointMismatch    = errors.New("checkpoint mismatch")
+	ErrGasUsedMismatch       = errors.New("gas used mismatch")
+	ErrTimestampTooOld       = errors.New("block timestamp not after median time past")
+	// ErrBlockOutOfOrder is returned for a block that doesn't build on a
+	// block we know, it may become valid once its parent arrives.
+	ErrBlockOutOfOrder = errors.New("block out of order")
+)
 
 type Validator interface {
 	ValidateBlock(*Block) error
 }
 
+// ChainValidator runs several validators in order, so custom rules can be
+// layered on top of the BlockValidator. A block is valid if every validator
+// accepts it.
+type ChainValidator struct {
+	validators []Validator
+}
+
+func NewChainValidator(validators ...Validator) *ChainValidator {
+	return &ChainValidator{
+		validators: validators,
+	}
+}
+
+// ValidateBlock runs all validators, even after one of them failed. A single
+// error is returned as is, several are returned as ValidationErrors.
+func (v *ChainValidator) ValidateBlock(b *Block) error {
+	var errs ValidationErrors
+	for _, validator := range v.validators {
+		if err := validator.ValidateBlock(b); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
+}
+
+// ValidationErrors holds the errors of the validators that rejected a block.
+type ValidationErrors []error
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("(%d) validation errors: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the errors matches the target.
+func (errs ValidationErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type BlockValidator struct {
 	bc *Blockchain
 }
@@ -22,13 +89,37 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 }
 
 func (v *BlockValidator) ValidateBlock(b *Block) error {
+	// Blocks we already have are common when peers relay them, bail out
+	// before any of the expensive checks.
+	if v.bc.HasBlockHash(b.Hash(v.bc.blockHasher)) {
+		return ErrBlockKnown
+	}
+
+	// The hash of a known genesis was matched above, any other block with
+	// height 0 belongs to another chain.
+	if b.Height == 0 {
+		genesis, err := v.bc.GetHeader(0)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: block (%s) => local genesis (%s)", ErrGenesisMismatch, b.Hash(v.bc.blockHasher), v.bc.blockHasher.Hash(genesis))
+	}
+
+	if !b.HashAlgorithm.Valid() {
+		return fmt.Errorf("block with height (%d) has an unknown hash algorithm (%s)", b.Height, b.HashAlgorithm)
+	}
+
+	if err := v.validateCheckpoint(b); err != nil {
+		return err
+	}
+
 	if v.bc.HasBlock(b.Height) {
-		// return fmt.Errorf("chain already contains block (%d) with hash (%s)", b.Height, b.Hash(BlockHasher{}))
+		// return fmt.Errorf("chain already contains block (%d) with hash (%s)", b.Height, b.Hash(v.bc.blockHasher))
 		return ErrBlockKnown
 	}
 
 	if b.Height != v.bc.Height()+1 {
-		return fmt.Errorf("block (%s) with height (%d) is too high => current height (%d)", b.Hash(BlockHasher{}), b.Height, v.bc.Height())
+		return fmt.Errorf("%w: block (%s) with height (%d) is too high => current height (%d)", ErrBlockOutOfOrder, b.Hash(v.bc.blockHasher), b.Height, v.bc.Height())
 	}
 
 	prevHeader, err := v.bc.GetHeader(b.Height - 1)
@@ -36,14 +127,127 @@ func (v *BlockValidator) ValidateBlock(b *Block) error {
 		return err
 	}
 
-	hash := BlockHasher{}.Hash(prevHeader)
+	if b.HashAlgorithm != prevHeader.HashAlgorithm {
+		return fmt.Errorf("block (%s) uses hash algorithm (%s) => chain uses (%s)", b.Hash(v.bc.blockHasher), b.HashAlgorithm, prevHeader.HashAlgorithm)
+	}
+
+	hash := v.bc.blockHasher.Hash(prevHeader)
 	if hash != b.PrevBlockHash {
-		return fmt.Errorf("the hash of the previous block (%s) is invalid", b.PrevBlockHash)
+		return fmt.Errorf("%w: the hash of the previous block (%s) is invalid", ErrBlockOutOfOrder, b.PrevBlockHash)
+	}
+
+	if err := v.validateMedianTimePast(b, prevHeader); err != nil {
+		return err
+	}
+
+	if err := b.VerifyWithHasher(v.bc.blockHasher); err != nil {
+		return err
 	}
 
-	if err := b.Verify(); err != nil {
+	if err := v.validateGasUsed(b); err != nil {
 		return err
 	}
 
+	if err := v.validateValidator(b); err != nil {
+		return err
+	}
+
+	return v.validateCoinbase(b)
+}
+
+// validateCheckpoint checks that a block at a checkpoint height has the
+// trusted hash.
+func (v *BlockValidator) validateCheckpoint(b *Block) error {
+	want, ok := v.bc.checkpoint(b.Height)
+	if !ok {
+		return nil
+	}
+
+	if hash := b.Hash(v.bc.blockHasher); hash != want {
+		return fmt.Errorf("%w: block (%s) with height (%d) => checkpoint (%s)", ErrCheckpointMismatch, hash, b.Height, want)
+	}
+
+	return nil
+}
+
+// validateMedianTimePast checks that the block is younger than the median
+// timestamp of the blocks before it, when the chain has a median time span.
+func (v *BlockValidator) validateMedianTimePast(b *Block, parent *Header) error {
+	span := v.bc.MedianTimeSpan()
+	if span == 0 {
+		return nil
+	}
+
+	median, err := v.bc.medianTimePast(parent, span)
+	if err != nil {
+		return err
+	}
+
+	if b.Timestamp <= median {
+		return fmt.Errorf("%w: block (%s) with timestamp (%d) => median time past (%d)", ErrTimestampTooOld, b.Hash(v.bc.blockHasher), b.Timestamp, median)
+	}
+
+	return nil
+}
+
+// validateGasUsed checks that the gas used in the header matches the
+// transactions of the block.
+func (v *BlockValidator) validateGasUsed(b *Block) error {
+	if gas := CalculateGasUsed(b.Transactions); b.GasUsed != gas {
+		return fmt.Errorf("%w: block (%s) claims (%d) => transactions use (%d)", ErrGasUsedMismatch, b.Hash(v.bc.blockHasher), b.GasUsed, gas)
+	}
+
+	return nil
+}
+
+// validateValidator checks that the block is signed by one of the validators
+// of the chain.
+func (v *BlockValidator) validateValidator(b *Block) error {
+	addr := b.Validator.Address()
+	if !v.bc.IsValidator(addr) {
+		return fmt.Errorf("%w: block (%s) signed by (%s)", ErrUnauthorizedValidator, b.Hash(v.bc.blockHasher), addr)
+	}
+
+	return nil
+}
+
+// validateCoinbase checks that a block has exactly one coinbase, as its first
+// transaction, paying the block reward plus fees to the validator. When the
+// chain has no block reward a block may not contain a coinbase at all.
+func (v *BlockValidator) validateCoinbase(b *Block) error {
+	reward := v.bc.BlockReward()
+
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() && (i > 0 || reward == 0) {
+			return fmt.Errorf("block (%s) has an unexpected coinbase at index (%d)", b.Hash(v.bc.blockHasher), i)
+		}
+	}
+
+	if reward == 0 {
+		return nil
+	}
+
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("block (%s) has no coinbase", b.Hash(v.bc.blockHasher))
+	}
+
+	amount, err := CoinbaseAmount(reward, b.Transactions[1:])
+	if err != nil {
+		return fmt.Errorf("block (%s): %w", b.Hash(v.bc.blockHasher), err)
+	}
+
+	coinbase := b.Transactions[0]
+	if len(coinbase.Outputs) > 0 {
+		return fmt.Errorf("block (%s) coinbase has outputs", b.Hash(v.bc.blockHasher))
+	}
+
+	if coinbase.To != b.Validator.Address() {
+		return fmt.Errorf("block (%s) coinbase pays (%s) => validator is (%s)", b.Hash(v.bc.blockHasher), coinbase.To, b.Validator.Address())
+	}
+
+	if coinbase.Value != amount {
+		return fmt.Errorf("block (%s) coinbase pays (%d) => expected (%d)", b.Hash(v.bc.blockHasher), coinbase.Value, amount)
+	}
+
 	return nil
 }
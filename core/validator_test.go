@@ -0,0 +1,95 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+var errBlockTooLarge = errors.New("block too large")
+
+// maxTxValidator rejects blocks with more than max transactions.
+type maxTxValidator struct {
+	max int
+}
+
+func (v maxTxValidator) ValidateBlock(b *Block) error {
+	if len(b.Transactions) > v.max {
+		return fmt.Errorf("%w: (%d) transactions => maximum (%d)", errBlockTooLarge, len(b.Transactions), v.max)
+	}
+
+	return nil
+}
+
+func TestChainValidator(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetValidator(NewChainValidator(NewBlockValidator(bc), maxTxValidator{max: 2}))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, randomTxx(t, 2)...)))
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc, randomTxx(t, 3)...)), errBlockTooLarge)
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// The built-in rules still apply.
+	b := nextBlock(t, bc)
+	b.Height = 5
+	assert.NotNil(t, bc.AddBlock(b))
+
+	// Both validators reject the block, both errors are reported.
+	b = nextBlock(t, bc, randomTxx(t, 3)...)
+	b.Signature = nil
+	err := bc.AddBlock(b)
+	assert.ErrorIs(t, err, errBlockTooLarge)
+	assert.Len(t, err.(ValidationErrors), 2)
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestValidateGasUsed(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	txx := randomTxx(t, 2)
+
+	b := nextBlock(t, bc, txx...)
+	assert.Equal(t, txx[0].Gas()+txx[1].Gas(), b.GasUsed)
+	assert.Equal(t, TxGas+TxDataGas*uint64(len(txx[0].Data)), txx[0].Gas())
+
+	wrong := nextBlock(t, bc, txx...)
+	wrong.GasUsed--
+	assert.Nil(t, wrong.Sign(crypto.GeneratePrivateKey()))
+	assert.ErrorIs(t, bc.AddBlock(wrong), ErrGasUsedMismatch)
+	assert.Equal(t, uint32(0), bc.Height())
+
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestValidateMedianTimePast(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetMedianTimeSpan(3)
+
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	blockAt := func(offset int64) *Block {
+		b, err := NewBlockFromPrevHeader(bc.LastHeader(), nil)
+		assert.Nil(t, err)
+		b.Timestamp = genesis.Timestamp + offset
+		assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+		return b
+	}
+
+	assert.Nil(t, bc.AddBlock(blockAt(100)))
+	assert.Nil(t, bc.AddBlock(blockAt(300)))
+
+	// The median of the genesis and both blocks is the first block.
+	assert.ErrorIs(t, bc.AddBlock(blockAt(50)), ErrTimestampTooOld)
+	assert.ErrorIs(t, bc.AddBlock(blockAt(100)), ErrTimestampTooOld)
+	assert.Equal(t, uint32(2), bc.Height())
+
+	// Older than the tip is fine as long as it is past the median.
+	assert.Nil(t, bc.AddBlock(blockAt(150)))
+	assert.Equal(t, uint32(3), bc.Height())
+
+	bc.SetMedianTimeSpan(0)
+	assert.Nil(t, bc.AddBlock(blockAt(0)))
+}
@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedOffset is added to a child index to derive a hardened child, its
+// public key can't be derived from the public key of the parent.
+const HardenedOffset uint32 = 0x80000000
+
+// masterKeySecret is the HMAC key for the master key, as SLIP-0010 defines
+// it for the P256 curve.
+var masterKeySecret = []byte("Nist256p1 seed")
+
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// ExtendedKey is a private key of a hierarchical deterministic wallet, BIP32
+// derivation on the P256 curve as specified by SLIP-0010. Every child key is
+// derived from the key and chain code of its parent, so a single seed is
+// enough to restore all keys of the wallet.
+type ExtendedKey struct {
+	key       PrivateKey
+	chainCode []byte
+	depth     uint8
+}
+
+// NewMasterKey derives the root key of the wallet from the seed, which has
+// to be between 16 and 64 bytes long.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("seed of (%d) bytes => expected 16 to 64 bytes", len(seed))
+	}
+
+	i := hmacSHA512(masterKeySecret, seed)
+	for !validScalar(i[:32]) {
+		i = hmacSHA512(masterKeySecret, i)
+	}
+
+	return &ExtendedKey{
+		key:       privateKeyFromScalar(i[:32]),
+		chainCode: i[32:],
+	}, nil
+}
+
+// PrivateKey returns the key to sign with.
+func (k *ExtendedKey) PrivateKey() PrivateKey {
+	return k.key
+}
+
+// ChainCode returns the chain code the children of the key are derived
+// with.
+func (k *ExtendedKey) ChainCode() []byte {
+	return append([]byte{}, k.chainCode...)
+}
+
+// Depth returns the number of derivations from the master key.
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+// Child derives the child key with the given index, indexes from
+// HardenedOffset on derive hardened children.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, fmt.Errorf("key at depth (%d) can't have children", k.depth)
+	}
+
+	n := elliptic.P256().Params().N
+	parent := k.key.key.D
+
+	data := make([]byte, 0, 37)
+	if index >= HardenedOffset {
+		data = append(data, 0)
+		data = append(data, parent.FillBytes(make([]byte, 32))...)
+	} else {
+		data = append(data, k.key.PublicKey().ToSlice()...)
+	}
+	data = appendIndex(data, index)
+
+	for {
+		i := hmacSHA512(k.chainCode, data)
+
+		child := new(big.Int).SetBytes(i[:32])
+		if child.Cmp(n) < 0 {
+			child.Add(child, parent).Mod(child, n)
+			if child.Sign() != 0 {
+				return &ExtendedKey{
+					key:       privateKeyFromScalar(child.FillBytes(make([]byte, 32))),
+					chainCode: i[32:],
+					depth:     k.depth + 1,
+				}, nil
+			}
+		}
+
+		// The derived key is invalid, derive again from the chain code.
+		data = appendIndex(append([]byte{1}, i[32:]...), index)
+	}
+}
+
+// Derive follows the path of child indexes starting at the key.
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	key := k
+	for _, index := range path {
+		child, err := key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+
+	return key, nil
+}
+
+// ParsePath parses a derivation path like "m/44'/0'/1", an index followed by
+// an apostrophe or "h" is hardened.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: (%s) does not start with m", ErrInvalidPath, path)
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if trimmed := strings.TrimRight(part, "'h"); len(trimmed) == len(part)-1 {
+			part = trimmed
+			offset = HardenedOffset
+		}
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, fmt.Errorf("%w: (%s) has an invalid index (%s)", ErrInvalidPath, path, part)
+		}
+
+		indexes = append(indexes, uint32(index)+offset)
+	}
+
+	return indexes, nil
+}
+
+func appendIndex(b []byte, index uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], index)
+
+	return append(b, buf[:]...)
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// validScalar returns true if b is a valid P256 private key, in [1, n-1].
+func validScalar(b []byte) bool {
+	d := new(big.Int).SetBytes(b)
+
+	return d.Sign() > 0 && d.Cmp(elliptic.P256().Params().N) < 0
+}
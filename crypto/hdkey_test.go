@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHDKeyDeterministic(t *testing.T) {
+	seed := []byte("a wallet seed of 32 bytes length")
+	path, err := ParsePath("m/44'/0'/0/7")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{44 + HardenedOffset, HardenedOffset, 0, 7}, path)
+
+	derive := func() *ExtendedKey {
+		master, err := NewMasterKey(seed)
+		assert.Nil(t, err)
+		key, err := master.Derive(path)
+		assert.Nil(t, err)
+		return key
+	}
+
+	a, b := derive(), derive()
+	assert.Equal(t, uint8(4), a.Depth())
+	assert.Equal(t, a.PrivateKey().key.D, b.PrivateKey().key.D)
+	assert.Equal(t, a.ChainCode(), b.ChainCode())
+	assert.Equal(t, a.PrivateKey().PublicKey().Address(), b.PrivateKey().PublicKey().Address())
+
+	// Siblings and hardened children are different keys.
+	master, err := NewMasterKey(seed)
+	assert.Nil(t, err)
+	parent, err := master.Derive(path[:3])
+	assert.Nil(t, err)
+	sibling, err := parent.Child(8)
+	assert.Nil(t, err)
+	hardened, err := parent.Child(7 + HardenedOffset)
+	assert.Nil(t, err)
+	assert.NotEqual(t, a.PrivateKey().PublicKey().Address(), sibling.PrivateKey().PublicKey().Address())
+	assert.NotEqual(t, a.PrivateKey().PublicKey().Address(), hardened.PrivateKey().PublicKey().Address())
+
+	// Derived keys sign like any other key.
+	sig, err := a.PrivateKey().Sign([]byte("foo"))
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(a.PrivateKey().PublicKey(), []byte("foo")))
+}
+
+// TestHDKeySLIP10 checks the first test vector of SLIP-0010 for nist256p1.
+func TestHDKeySLIP10(t *testing.T) {
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	assert.Nil(t, err)
+
+	master, err := NewMasterKey(seed)
+	assert.Nil(t, err)
+	assert.Equal(t, "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2", hex.EncodeToString(master.PrivateKey().key.D.FillBytes(make([]byte, 32))))
+	assert.Equal(t, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", hex.EncodeToString(master.ChainCode()))
+
+	child, err := master.Child(HardenedOffset)
+	assert.Nil(t, err)
+	assert.Equal(t, "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c", hex.EncodeToString(child.PrivateKey().key.D.FillBytes(make([]byte, 32))))
+	assert.Equal(t, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", hex.EncodeToString(child.ChainCode()))
+}
+
+func TestHDKeyInvalidInput(t *testing.T) {
+	_, err := NewMasterKey([]byte("short"))
+	assert.NotNil(t, err)
+
+	for _, path := range []string{"", "44'/0", "m/x", "m/2147483648", "m/1''"} {
+		_, err := ParsePath(path)
+		assert.ErrorIs(t, err, ErrInvalidPath, path)
+	}
+
+	path, err := ParsePath("m")
+	assert.Nil(t, err)
+	assert.Empty(t, path)
+}
@@ -1,21 +1,35 @@
 package crypto
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"math/big"
+
 	"github.com/ayushn2/blockchainz/types"
 ) 
 
 
 type PrivateKey struct {
 	key *ecdsa.PrivateKey
+	// ed is set instead of key for Ed25519 keys.
+	ed ed25519.PrivateKey
+	// pub is set once when the key is created and never changed afterwards,
+	// so it can be read from multiple goroutines without locking.
+	pub PublicKey
 }
 
 func (k PrivateKey) Sign(data []byte) (*Signature, error){
-	r, s, err := ecdsa.Sign(rand.Reader, k.key, data)
+	if k.ed != nil {
+		return signEd25519(k.ed, data), nil
+	}
+
+	r, s, err := signDeterministic(k.key, data)
 	if err!=nil{
 		return nil, err
 	}
@@ -26,31 +40,136 @@ func (k PrivateKey) Sign(data []byte) (*Signature, error){
 		}, nil
 }
 
+// SignContext signs the data like Sign unless the context is done, so a
+// loop signing many messages stops once it is cancelled.
+func (k PrivateKey) SignContext(ctx context.Context, data []byte) (*Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return k.Sign(data)
+}
+
 func GeneratePrivateKey() PrivateKey{
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil{
 		panic(err)
 	}
 	
+	return newPrivateKey(key)
+}
+
+func newPrivateKey(key *ecdsa.PrivateKey) PrivateKey {
 	return PrivateKey{
 		key: key,
+		pub: PublicKey{
+			Key: &key.PublicKey,
+		},
 	}
 }
 
-func (k PrivateKey) PublicKey() PublicKey {
-	return PublicKey{
-		Key: &k.key.PublicKey,
+// privateKeyFromScalar creates the P256 private key with the given scalar.
+func privateKeyFromScalar(d []byte) PrivateKey {
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: curve},
+		D:         new(big.Int).SetBytes(d),
 	}
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d)
+
+	return newPrivateKey(key)
+}
+
+// PrivateKeyFromSeed derives a P256 private key from the seed, the same seed
+// always results in the same key. Anyone knowing the seed knows the key, it
+// is meant for keys that are public by design.
+func PrivateKeyFromSeed(seed []byte) PrivateKey {
+	n := elliptic.P256().Params().N
+	h := sha256.Sum256(seed)
+
+	// Map the hash into [1, n-1].
+	d := new(big.Int).SetBytes(h[:])
+	d.Mod(d, new(big.Int).Sub(n, big.NewInt(1)))
+	d.Add(d, big.NewInt(1))
+
+	return privateKeyFromScalar(d.Bytes())
+}
+
+func (k PrivateKey) PublicKey() PublicKey {
+	return k.pub
 }
 
 type PublicKey struct {
 	Key *ecdsa.PublicKey
+	// Ed25519 is set instead of Key for keys of the Ed25519 scheme.
+	Ed25519 ed25519.PublicKey
 }
 
+// IsZero returns true if the key is not set, for either scheme.
+func (k PublicKey) IsZero() bool {
+	return k.Key == nil && k.Ed25519 == nil
+}
+
+// ToSlice returns the compressed P256 point, 33 bytes, or the 32 byte
+// Ed25519 key.
 func (k PublicKey) ToSlice() []byte {
+	if k.Ed25519 != nil {
+		return append([]byte{}, k.Ed25519...)
+	}
+
 	return elliptic.MarshalCompressed(k.Key, k.Key.X, k.Key.Y)
 }
 
+// GobEncode encodes the public key as a compressed curve point, the
+// ecdsa.PublicKey itself cannot be gob encoded because of its curve.
+func (k PublicKey) GobEncode() ([]byte, error) {
+	if k.IsZero() {
+		return []byte{}, nil
+	}
+
+	return k.ToSlice(), nil
+}
+
+// PublicKeyFromBytes parses a key as returned by ToSlice.
+func PublicKeyFromBytes(b []byte) (PublicKey, error) {
+	var k PublicKey
+	if len(b) == 0 {
+		return k, fmt.Errorf("invalid public key bytes")
+	}
+
+	err := k.GobDecode(b)
+
+	return k, err
+}
+
+func (k *PublicKey) GobDecode(b []byte) error {
+	if len(b) == 0 {
+		k.Key = nil
+		k.Ed25519 = nil
+		return nil
+	}
+
+	if len(b) == ed25519.PublicKeySize {
+		k.Key = nil
+		k.Ed25519 = append(ed25519.PublicKey{}, b...)
+		return nil
+	}
+
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), b)
+	if x == nil {
+		return fmt.Errorf("invalid public key bytes")
+	}
+
+	k.Ed25519 = nil
+	k.Key = &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     x,
+		Y:     y,
+	}
+
+	return nil
+}
+
 func (k PublicKey) Address() types.Address{
 	h := sha256.Sum256(k.ToSlice())
 
@@ -63,6 +182,49 @@ type Signature struct{
 	R, S *big.Int
 }
 
+// Verify verifies an ECDSA signature, use VerifyWithScheme for signatures of
+// other schemes.
 func (sig Signature) Verify(pubKey PublicKey, data []byte) bool{
-	return ecdsa.Verify(pubKey.Key, data, sig.R, sig.S)
-}
\ No newline at end of file
+	return sig.VerifyWithScheme(SchemeECDSA, pubKey, data)
+}
+
+// VerifyHash verifies the signature of a precomputed 32 byte digest, like a
+// transaction hash. It agrees with Verify given the digest as data, neither
+// hashes the input again.
+func (sig Signature) VerifyHash(pubKey PublicKey, hash types.Hash) bool {
+	if pubKey.Key == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
+
+	return ecdsa.Verify(pubKey.Key, hash[:], sig.R, sig.S)
+}
+// String returns the hex encoded compact form of the signature, R and S
+// padded to 32 bytes each.
+func (sig Signature) String() string {
+	if sig.R == nil || sig.S == nil {
+		return ""
+	}
+
+	b := make([]byte, 64)
+	sig.R.FillBytes(b[:32])
+	sig.S.FillBytes(b[32:])
+
+	return hex.EncodeToString(b)
+}
+
+// SignatureFromString parses a signature in the form returned by String.
+func SignatureFromString(s string) (*Signature, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) != 64 {
+		return nil, fmt.Errorf("given bytes with length (%d) should be 64", len(b))
+	}
+
+	return &Signature{
+		R: new(big.Int).SetBytes(b[:32]),
+		S: new(big.Int).SetBytes(b[32:]),
+	}, nil
+}
@@ -1,8 +1,12 @@
 package crypto
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 
+	"github.com/ayushn2/blockchainz/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,4 +34,147 @@ func TestKeyPair_Sign_Verify_Fail(t *testing.T){
 
 	assert.False(t, sig.Verify(attackPubKey, msg), "Attack successfully verified a signature that should not match")
 	assert.False(t, sig.Verify(privKey.PublicKey(), []byte("Tampered message")), "Signature verification should fail for tampered message")
-}
\ No newline at end of file
+}
+
+func TestPublicKeyCached(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	assert.Equal(t, privKey.PublicKey(), privKey.PublicKey())
+	assert.Same(t, privKey.PublicKey().Key, privKey.PublicKey().Key)
+
+	msg := []byte("Hello, Blockchainz!")
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(privKey.PublicKey(), msg))
+}
+
+func BenchmarkPublicKey(b *testing.B) {
+	privKey := GeneratePrivateKey()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		privKey.PublicKey()
+	}
+}
+
+func TestKeyPair_Sign_Deterministic(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	data := []byte("same message")
+
+	a, err := privKey.Sign(data)
+	assert.Nil(t, err)
+	b, err := privKey.Sign(data)
+	assert.Nil(t, err)
+
+	assert.Equal(t, a.R.Bytes(), b.R.Bytes())
+	assert.Equal(t, a.S.Bytes(), b.S.Bytes())
+	assert.True(t, a.Verify(privKey.PublicKey(), data))
+
+	other, err := privKey.Sign([]byte("other message"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, a.R.Bytes(), other.R.Bytes())
+}
+
+func TestKeyPair_Sign_RFC6979Vector(t *testing.T) {
+	// P-256 with SHA-256 over "sample" from RFC 6979 appendix A.2.5.
+	d, _ := hex.DecodeString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")
+	privKey := privateKeyFromScalar(d)
+	hash := sha256.Sum256([]byte("sample"))
+
+	sig, err := privKey.Sign(hash[:])
+	assert.Nil(t, err)
+	assert.Equal(t, "efd48b2aacb6a8fd1140dd9cd45e81d69d2c877b56aaf991c34d0ea84eaf3716", hex.EncodeToString(sig.R.Bytes()))
+	assert.Equal(t, "f7cb1c942d657c41d436c7a1b6e29f65f3e900dbb9aff4064dc4ab2f843acda8", hex.EncodeToString(sig.S.Bytes()))
+	assert.True(t, sig.Verify(privKey.PublicKey(), hash[:]))
+}
+
+func TestPrivateKeyFromSeed(t *testing.T) {
+	a := PrivateKeyFromSeed([]byte("seed"))
+	b := PrivateKeyFromSeed([]byte("seed"))
+	assert.Equal(t, a.PublicKey().Address(), b.PublicKey().Address())
+	assert.NotEqual(t, a.PublicKey().Address(), PrivateKeyFromSeed([]byte("other")).PublicKey().Address())
+
+	hash := sha256.Sum256([]byte("foo"))
+	sig, err := a.Sign(hash[:])
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(b.PublicKey(), hash[:]))
+}
+
+func TestSignature_String(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	a, err := privKey.Sign([]byte("foo"))
+	assert.Nil(t, err)
+	b, err := privKey.Sign([]byte("bar"))
+	assert.Nil(t, err)
+
+	assert.Len(t, a.String(), 128)
+	assert.Equal(t, a.String(), a.String())
+	assert.NotEqual(t, a.String(), b.String())
+	assert.Equal(t, "", Signature{}.String())
+}
+
+func TestSignatureFromString(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	sig, err := privKey.Sign([]byte("foo"))
+	assert.Nil(t, err)
+
+	parsed, err := SignatureFromString(sig.String())
+	assert.Nil(t, err)
+	assert.True(t, parsed.Verify(privKey.PublicKey(), []byte("foo")))
+
+	_, err = SignatureFromString("abcd")
+	assert.NotNil(t, err)
+}
+
+func TestPublicKeyFromBytes(t *testing.T) {
+	pubKey := GeneratePrivateKey().PublicKey()
+
+	parsed, err := PublicKeyFromBytes(pubKey.ToSlice())
+	assert.Nil(t, err)
+	assert.Equal(t, pubKey.Address(), parsed.Address())
+
+	_, err = PublicKeyFromBytes(nil)
+	assert.NotNil(t, err)
+}
+
+func TestSignature_VerifyHash(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	hash := types.Hash(sha256.Sum256([]byte("foo")))
+
+	sig, err := privKey.Sign(hash[:])
+	assert.Nil(t, err)
+	assert.True(t, sig.VerifyHash(privKey.PublicKey(), hash))
+	assert.Equal(t, sig.Verify(privKey.PublicKey(), hash[:]), sig.VerifyHash(privKey.PublicKey(), hash))
+
+	other := types.Hash(sha256.Sum256([]byte("bar")))
+	assert.False(t, sig.VerifyHash(privKey.PublicKey(), other))
+	assert.Equal(t, sig.Verify(privKey.PublicKey(), other[:]), sig.VerifyHash(privKey.PublicKey(), other))
+
+	assert.False(t, sig.VerifyHash(GeneratePrivateKey().PublicKey(), hash))
+	assert.False(t, Signature{}.VerifyHash(privKey.PublicKey(), hash))
+}
+
+func TestKeyPair_SignContext(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("hello world")
+
+	sig, err := privKey.SignContext(context.Background(), msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(privKey.PublicKey(), msg))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signed := 0
+	for i := 0; i < 1_000_000; i++ {
+		if i == 10 {
+			cancel()
+		}
+		if _, err = privKey.SignContext(ctx, msg); err != nil {
+			break
+		}
+		signed++
+	}
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 10, signed)
+}
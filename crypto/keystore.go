@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+const (
+	keystoreVersion = 1
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+	scryptKeyLen    = 32
+)
+
+// keystore is the on disk format of an encrypted private key. The key is
+// derived from the passphrase with scrypt and the private key is sealed with
+// AES-GCM, so a wrong passphrase fails authentication.
+type keystore struct {
+	Version    int    `json:"version"`
+	Address    string `json:"address"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// SaveKeystore encrypts the private key with the passphrase and writes it to
+// the given path, only readable by the owner.
+func SaveKeystore(priv PrivateKey, passphrase string, path string) error {
+	if priv.Scheme() != SchemeECDSA {
+		return fmt.Errorf("keystore does not support (%s) keys", priv.Scheme())
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	aead, err := keystoreCipher(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	plaintext := priv.key.D.FillBytes(make([]byte, 32))
+	ks := keystore{
+		Version:    keystoreVersion,
+		Address:    priv.PublicKey().Address().String(),
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, plaintext, nil)),
+	}
+
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadKeystore reads the keystore at the given path and decrypts the private
+// key with the passphrase. A wrong passphrase returns ErrWrongPassphrase.
+func LoadKeystore(passphrase string, path string) (PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	var ks keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return PrivateKey{}, fmt.Errorf("invalid keystore (%s): %w", path, err)
+	}
+
+	if ks.Version != keystoreVersion || ks.KDF != "scrypt" {
+		return PrivateKey{}, fmt.Errorf("unsupported keystore (%s) version (%d) kdf (%s)", path, ks.Version, ks.KDF)
+	}
+
+	salt, err := hex.DecodeString(ks.Salt)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("invalid keystore (%s) salt: %w", path, err)
+	}
+	nonce, err := hex.DecodeString(ks.Nonce)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("invalid keystore (%s) nonce: %w", path, err)
+	}
+	ciphertext, err := hex.DecodeString(ks.Ciphertext)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("invalid keystore (%s) ciphertext: %w", path, err)
+	}
+
+	aead, err := keystoreCipher(passphrase, salt, ks.N, ks.R, ks.P)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return PrivateKey{}, fmt.Errorf("invalid keystore (%s) nonce length (%d)", path, len(nonce))
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return PrivateKey{}, ErrWrongPassphrase
+	}
+
+	return privateKeyFromScalar(plaintext), nil
+}
+
+func keystoreCipher(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,32 @@
+package crypto
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeystore_RoundTrip(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	path := filepath.Join(t.TempDir(), "key.json")
+
+	assert.Nil(t, SaveKeystore(privKey, "secret", path))
+
+	loaded, err := LoadKeystore("secret", path)
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey().Address(), loaded.PublicKey().Address())
+
+	msg := []byte("signed with the loaded key")
+	sig, err := SignMessage(loaded, msg)
+	assert.Nil(t, err)
+	assert.True(t, VerifyMessage(privKey.PublicKey(), msg, sig))
+}
+
+func TestKeystore_WrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.json")
+	assert.Nil(t, SaveKeystore(GeneratePrivateKey(), "secret", path))
+
+	_, err := LoadKeystore("not the secret", path)
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+}
@@ -0,0 +1,23 @@
+package crypto
+
+import "crypto/sha256"
+
+// SignMessage signs an arbitrary message, e.g. an off-chain attestation. The
+// message is hashed first since ECDSA only signs the first 32 bytes of the
+// given data.
+func SignMessage(priv PrivateKey, msg []byte) (*Signature, error) {
+	hash := sha256.Sum256(msg)
+
+	return priv.Sign(hash[:])
+}
+
+// VerifyMessage verifies a signature created with SignMessage.
+func VerifyMessage(pub PublicKey, msg []byte, sig *Signature) bool {
+	if sig == nil || pub.IsZero() {
+		return false
+	}
+
+	hash := sha256.Sum256(msg)
+
+	return sig.VerifyWithScheme(pub.Scheme(), pub, hash[:])
+}
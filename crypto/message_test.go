@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignMessage_Verify_Success(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("I attest that block 42 is final")
+
+	sig, err := SignMessage(privKey, msg)
+	assert.Nil(t, err)
+	assert.True(t, VerifyMessage(privKey.PublicKey(), msg, sig))
+}
+
+func TestSignMessage_Verify_Fail(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("I attest that block 42 is final")
+
+	sig, err := SignMessage(privKey, msg)
+	assert.Nil(t, err)
+
+	attackPrivKey := GeneratePrivateKey()
+	assert.False(t, VerifyMessage(attackPrivKey.PublicKey(), msg, sig))
+	assert.False(t, VerifyMessage(privKey.PublicKey(), []byte("I attest that block 43 is final"), sig))
+	assert.False(t, VerifyMessage(privKey.PublicKey(), msg, nil))
+}
+
+func TestSignMessage_LongMessage(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := bytes.Repeat([]byte{0x01}, 64)
+
+	sig, err := SignMessage(privKey, msg)
+	assert.Nil(t, err)
+
+	// Only the tail differs, which would go unnoticed if the message was
+	// signed without hashing it first.
+	tampered := append(bytes.Repeat([]byte{0x01}, 63), 0x02)
+	assert.False(t, VerifyMessage(privKey.PublicKey(), tampered, sig))
+}
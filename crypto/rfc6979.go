@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// signDeterministic signs the hash with a nonce derived from the private key
+// and the hash as described in RFC 6979, so signing never depends on the
+// quality of a random number generator and the same input always gives the
+// same signature.
+func signDeterministic(priv *ecdsa.PrivateKey, hash []byte) (r, s *big.Int, err error) {
+	n := priv.Curve.Params().N
+	e := bitsToInt(hash, n)
+
+	nonces := newNonceGenerator(priv.D, hash, n)
+	for i := 0; i < 100; i++ {
+		k := nonces.next()
+
+		x, _ := priv.Curve.ScalarBaseMult(k.Bytes())
+		r = new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// s = k^-1 * (e + r*d) mod n
+		s = new(big.Int).Mul(r, priv.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		return r, s, nil
+	}
+
+	return nil, nil, errors.New("failed to find a valid signature nonce")
+}
+
+// nonceGenerator produces the candidates for k following RFC 6979 section
+// 3.2 with HMAC-SHA256.
+type nonceGenerator struct {
+	n    *big.Int
+	k, v []byte
+	// started is set once the first candidate was returned, the state has
+	// to be updated before every following candidate.
+	started bool
+}
+
+func newNonceGenerator(d *big.Int, hash []byte, n *big.Int) *nonceGenerator {
+	size := (n.BitLen() + 7) / 8
+	x := intToOctets(d, size)
+	h := intToOctets(new(big.Int).Mod(bitsToInt(hash, n), n), size)
+
+	g := &nonceGenerator{
+		n: n,
+		k: make([]byte, sha256.Size),
+		v: make([]byte, sha256.Size),
+	}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+
+	g.k = g.mac(g.v, []byte{0x00}, x, h)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, x, h)
+	g.v = g.mac(g.v)
+
+	return g
+}
+
+func (g *nonceGenerator) mac(data ...[]byte) []byte {
+	m := hmac.New(sha256.New, g.k)
+	for _, d := range data {
+		m.Write(d)
+	}
+	return m.Sum(nil)
+}
+
+func (g *nonceGenerator) next() *big.Int {
+	size := (g.n.BitLen() + 7) / 8
+
+	for {
+		if g.started {
+			g.k = g.mac(g.v, []byte{0x00})
+			g.v = g.mac(g.v)
+		}
+		g.started = true
+
+		t := []byte{}
+		for len(t) < size {
+			g.v = g.mac(g.v)
+			t = append(t, g.v...)
+		}
+
+		k := bitsToInt(t, g.n)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
+
+// bitsToInt interprets the leftmost bits of b as an integer with at most as
+// many bits as n.
+func bitsToInt(b []byte, n *big.Int) *big.Int {
+	x := new(big.Int).SetBytes(b)
+	if excess := len(b)*8 - n.BitLen(); excess > 0 {
+		x.Rsh(x, uint(excess))
+	}
+	return x
+}
+
+func intToOctets(x *big.Int, size int) []byte {
+	return x.FillBytes(make([]byte, size))
+}
@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// SigScheme is the signature scheme of a key and the signatures it creates.
+// Signed transactions and blocks carry it, so a node knows how to verify them
+// on a network with keys of several schemes.
+type SigScheme byte
+
+const (
+	// SchemeECDSA is ECDSA on P256 with deterministic nonces, the scheme of
+	// everything signed before schemes were tagged.
+	SchemeECDSA SigScheme = iota
+	SchemeEd25519
+)
+
+func (s SigScheme) String() string {
+	switch s {
+	case SchemeECDSA:
+		return "ecdsa"
+	case SchemeEd25519:
+		return "ed25519"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(s))
+	}
+}
+
+// GenerateEd25519PrivateKey generates a private key for the Ed25519 scheme.
+func GenerateEd25519PrivateKey() PrivateKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return PrivateKey{
+		ed: priv,
+		pub: PublicKey{
+			Ed25519: pub,
+		},
+	}
+}
+
+// Scheme returns the signature scheme of the key.
+func (k PrivateKey) Scheme() SigScheme {
+	if k.ed != nil {
+		return SchemeEd25519
+	}
+
+	return SchemeECDSA
+}
+
+// Scheme returns the signature scheme of the key.
+func (k PublicKey) Scheme() SigScheme {
+	if k.Ed25519 != nil {
+		return SchemeEd25519
+	}
+
+	return SchemeECDSA
+}
+
+// signEd25519 signs the data, the 64 byte signature is split into R and S
+// so it fits the Signature of the other schemes.
+func signEd25519(priv ed25519.PrivateKey, data []byte) *Signature {
+	sig := ed25519.Sign(priv, data)
+
+	return &Signature{
+		R: new(big.Int).SetBytes(sig[:32]),
+		S: new(big.Int).SetBytes(sig[32:]),
+	}
+}
+
+// VerifyWithScheme verifies the signature of the data under the given scheme,
+// a key of another scheme fails.
+func (sig Signature) VerifyWithScheme(scheme SigScheme, pubKey PublicKey, data []byte) bool {
+	if sig.R == nil || sig.S == nil || pubKey.Scheme() != scheme {
+		return false
+	}
+
+	switch scheme {
+	case SchemeECDSA:
+		return pubKey.Key != nil && ecdsa.Verify(pubKey.Key, data, sig.R, sig.S)
+	case SchemeEd25519:
+		if len(pubKey.Ed25519) != ed25519.PublicKeySize || sig.R.BitLen() > 256 || sig.S.BitLen() > 256 {
+			return false
+		}
+
+		b := make([]byte, ed25519.SignatureSize)
+		sig.R.FillBytes(b[:32])
+		sig.S.FillBytes(b[32:])
+
+		return ed25519.Verify(pubKey.Ed25519, data, b)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,46 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEd25519SignVerify(t *testing.T) {
+	privKey := GenerateEd25519PrivateKey()
+	pubKey := privKey.PublicKey()
+	assert.Equal(t, SchemeEd25519, privKey.Scheme())
+	assert.Equal(t, SchemeEd25519, pubKey.Scheme())
+
+	hash := sha256.Sum256([]byte("foo"))
+	sig, err := privKey.Sign(hash[:])
+	assert.Nil(t, err)
+
+	assert.True(t, sig.VerifyWithScheme(SchemeEd25519, pubKey, hash[:]))
+	assert.False(t, sig.VerifyWithScheme(SchemeECDSA, pubKey, hash[:]))
+	assert.False(t, sig.Verify(pubKey, hash[:]))
+
+	other := sha256.Sum256([]byte("bar"))
+	assert.False(t, sig.VerifyWithScheme(SchemeEd25519, pubKey, other[:]))
+
+	// The compact signature form pads R and S, short values survive it.
+	parsed, err := SignatureFromString(sig.String())
+	assert.Nil(t, err)
+	assert.True(t, parsed.VerifyWithScheme(SchemeEd25519, pubKey, hash[:]))
+}
+
+func TestEd25519PublicKeyBytes(t *testing.T) {
+	pubKey := GenerateEd25519PrivateKey().PublicKey()
+	assert.Len(t, pubKey.ToSlice(), 32)
+
+	decoded, err := PublicKeyFromBytes(pubKey.ToSlice())
+	assert.Nil(t, err)
+	assert.Equal(t, SchemeEd25519, decoded.Scheme())
+	assert.Equal(t, pubKey.Address(), decoded.Address())
+
+	ecdsaKey := GeneratePrivateKey().PublicKey()
+	assert.Equal(t, SchemeECDSA, ecdsaKey.Scheme())
+	assert.False(t, ecdsaKey.IsZero())
+	assert.True(t, PublicKey{}.IsZero())
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	t.stopped = true
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	t := c.NewTicker(d).(*fakeTicker)
+	return t.c
+}
+
+func (c *fakeClock) tickerCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.tickers)
+}
+
+// Advance moves the clock forward and fires the tickers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.stopped && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+func TestServerFakeClockDrivesBlocks(t *testing.T) {
+	clock := newFakeClock()
+	privKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(ServerOpts{
+		ID:         "NODE",
+		Logger:     log.NewNopLogger(),
+		BlockTime:  5 * time.Second,
+		PrivateKey: &privKey,
+		Clock:      clock,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	// Wait for the validator loop to create its ticker.
+	assert.Eventually(t, func() bool {
+		return clock.tickerCount() == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, uint32(0), s.chain.Height())
+
+	clock.Advance(5 * time.Second)
+	assert.Eventually(t, func() bool {
+		return s.chain.Height() == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, clock.Now().UnixNano(), s.chain.LastHeader().Timestamp)
+}
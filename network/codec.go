@@ -0,0 +1,75 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ayushn2/blockchainz/core"
+)
+
+// Codec is the encoding of the transactions sent to a peer. Peers announce
+// the codecs they support in their status message and agree on one, gob is
+// used when they have none in common.
+type Codec byte
+
+const (
+	CodecGob  Codec = 0x0
+	CodecJSON Codec = 0x1
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecGob:
+		return "gob"
+	case CodecJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// negotiateCodec returns the first of our codecs, in order of preference,
+// the peer supports as well.
+func negotiateCodec(ours, theirs []Codec) Codec {
+	for _, c := range ours {
+		for _, other := range theirs {
+			if c == other {
+				return c
+			}
+		}
+	}
+
+	return CodecGob
+}
+
+func encodeTx(c Codec, tx *core.Transaction) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecGob:
+		err = tx.Encode(core.NewGobTxEncoder(buf))
+	case CodecJSON:
+		err = tx.Encode(core.NewJSONTxEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeTx(c Codec, data []byte) (*core.Transaction, error) {
+	tx := new(core.Transaction)
+
+	var err error
+	switch c {
+	case CodecGob:
+		err = tx.Decode(core.NewGobTxDecoder(bytes.NewReader(data)))
+	case CodecJSON:
+		err = tx.Decode(core.NewJSONTxDecoder(bytes.NewReader(data)))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return tx, err
+}
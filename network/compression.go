@@ -0,0 +1,51 @@
+package network
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxDecompressedSize limits the size of a decompressed frame, so a small
+// compressed message can't exhaust the memory of the node.
+const maxDecompressedSize = 32 << 20
+
+var ErrCompressedTooLarge = errors.New("compressed message too large")
+
+// compressFrame gzips the frame of a message and wraps it into a
+// MessageTypeCompressed message.
+func compressFrame(frame []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(frame); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return NewMessage(MessageTypeCompressed, buf.Bytes()).Bytes(), nil
+}
+
+// decompressFrame returns the frame of the message wrapped in a
+// MessageTypeCompressed message.
+func decompressFrame(msg *Message) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(msg.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress message: %w", err)
+	}
+	defer r.Close()
+
+	frame, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress message: %w", err)
+	}
+
+	if len(frame) > maxDecompressedSize {
+		return nil, fmt.Errorf("%w: more than (%d) bytes", ErrCompressedTooLarge, maxDecompressedSize)
+	}
+
+	return frame, nil
+}
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func largeBlockFrame(t *testing.T) []byte {
+	privKey := crypto.GeneratePrivateKey()
+	txx := make([]*core.Transaction, 200)
+	for i := range txx {
+		txx[i] = core.NewTransaction(bytes.Repeat([]byte("blockchainz"), 20))
+		txx[i].Value = uint64(i)
+		assert.Nil(t, txx[i].Sign(privKey))
+	}
+
+	b, err := core.NewBlockFromPrevHeader(&core.Header{}, txx)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(core.NewGobBlockEncoder(buf)))
+
+	return NewMessage(MessageTypeBlock, buf.Bytes()).Bytes()
+}
+
+func TestCompressedMessageRoundTrip(t *testing.T) {
+	frame := largeBlockFrame(t)
+
+	compressed, err := compressFrame(frame)
+	assert.Nil(t, err)
+	assert.Less(t, len(compressed), len(frame))
+
+	wrapper, err := decodeFrame(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeCompressed, wrapper.Header)
+
+	msg, err := DecodeMessage(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeBlock, msg.Header)
+	assert.Equal(t, frame, msg.Bytes())
+
+	decoded, err := DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(compressed),
+	})
+	assert.Nil(t, err)
+	block, ok := decoded.Data.(*core.Block)
+	if assert.True(t, ok) {
+		assert.Len(t, block.Transactions, 200)
+		assert.Nil(t, block.Verify())
+	}
+}
+
+func TestDecodeNestedCompressedMessage(t *testing.T) {
+	inner, err := compressFrame(NewMessage(MessageTypeGetStatus, nil).Bytes())
+	assert.Nil(t, err)
+	outer, err := compressFrame(inner)
+	assert.Nil(t, err)
+
+	_, err = DecodeMessage(outer)
+	assert.NotNil(t, err)
+}
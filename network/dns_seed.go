@@ -0,0 +1,51 @@
+package network
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// dnsSeedTimeout is the time the lookup of a DNS seed may take.
+const dnsSeedTimeout = 10 * time.Second
+
+// Resolver looks up the addresses of a host name, *net.Resolver implements
+// it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// resolveDNSSeeds returns the peer addresses the DNS seeds resolve to, with
+// the port of their seed. Seeds that can't be resolved are logged and
+// skipped.
+func (s *Server) resolveDNSSeeds() []string {
+	addrs := []string{}
+	for _, seed := range s.DNSSeeds {
+		host, port, err := net.SplitHostPort(seed)
+		if err != nil {
+			s.Logger.Log("msg", "invalid DNS seed", "seed", seed, "err", err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dnsSeedTimeout)
+		hosts, err := s.Resolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			s.Logger.Log("msg", "could not resolve DNS seed", "seed", seed, "err", err)
+			continue
+		}
+
+		for _, h := range hosts {
+			addrs = append(addrs, net.JoinHostPort(h, port))
+		}
+	}
+
+	return addrs
+}
+
+// dialDNSSeeds connects to every address the DNS seeds resolve to.
+func (s *Server) dialDNSSeeds() {
+	for _, addr := range s.resolveDNSSeeds() {
+		go s.dialWithRetry(addr, s.SeedRetry)
+	}
+}
@@ -57,6 +57,44 @@ func (t *LocalTransport) SendMessage(to net.Addr, payload []byte) error {
 	return nil
 }
 
+// Dial connects the transport in both directions with the transport at the
+// given address, which has to be a peer of one of its peers.
+func (t *LocalTransport) Dial(addr string) error {
+	for _, peer := range t.peerTransports() {
+		if peer.addr.String() == addr {
+			return t.connectBoth(peer)
+		}
+
+		for _, other := range peer.peerTransports() {
+			if other.addr.String() == addr {
+				return t.connectBoth(other)
+			}
+		}
+	}
+
+	return fmt.Errorf("%s: could not dial unknown peer %s", t.addr, addr)
+}
+
+func (t *LocalTransport) connectBoth(peer *LocalTransport) error {
+	if err := t.Connect(peer); err != nil {
+		return err
+	}
+
+	return peer.Connect(t)
+}
+
+func (t *LocalTransport) peerTransports() []*LocalTransport {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	peers := make([]*LocalTransport, 0, len(t.peers))
+	for _, peer := range t.peers {
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
 func (t *LocalTransport) Broadcast(payload []byte) error {
 	for _, peer := range t.peers {
 		if err := t.SendMessage(peer.Addr(), payload); err != nil {
@@ -66,6 +104,18 @@ func (t *LocalTransport) Broadcast(payload []byte) error {
 	return nil
 }
 
+func (t *LocalTransport) Peers() []net.Addr {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	peers := make([]net.Addr, 0, len(t.peers))
+	for addr := range t.peers {
+		peers = append(peers, addr)
+	}
+
+	return peers
+}
+
 func (t *LocalTransport) Addr() net.Addr {
 	return t.addr
 }
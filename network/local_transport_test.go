@@ -1 +1,22 @@
-package network
\ No newline at end of file
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalTransportDial(t *testing.T) {
+	a := NewLocalTransport(NetAddr("A"))
+	b := NewLocalTransport(NetAddr("B"))
+	c := NewLocalTransport(NetAddr("C"))
+
+	assert.Nil(t, a.Connect(b))
+	assert.Nil(t, b.Connect(c))
+
+	assert.Nil(t, a.Dial("C"))
+	assert.Contains(t, a.Peers(), c.Addr())
+	assert.Contains(t, c.Peers(), a.Addr())
+
+	assert.NotNil(t, a.Dial("D"))
+}
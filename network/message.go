@@ -1,15 +1,132 @@
 package network
 
-import "github.com/ayushn2/blockchainz/core"
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/types"
+)
 
 type GetBlocksMessage struct {
 	From uint32
 	// If To is 0 the maximum blocks will be returned.
 	To uint32
+	// Locator is the block locator of the requesting node, when set the
+	// blocks above the common ancestor are returned instead of From on.
+	Locator []types.Hash
+	// RequestID is echoed in the BlocksMessage answering the request.
+	RequestID uint64
 }
 
 type BlocksMessage struct {
-	Blocks []*core.Block
+	// RequestID is the id of the GetBlocksMessage this answers.
+	RequestID uint64
+	Blocks    []*core.Block
+	// Unavailable is set when the peer pruned some of the requested blocks,
+	// Blocks is empty then.
+	Unavailable bool
+}
+
+// MaxTxBatchSize is the maximum number of transactions in a TxBatchMessage.
+const MaxTxBatchSize = 1000
+
+// MaxTxBatchBytes is the maximum size of an encoded TxBatchMessage. It is
+// checked before the batch is decoded so an oversized batch is dropped
+// without allocating its transactions.
+const MaxTxBatchBytes = 32 << 20
+
+// TxBatchMessage carries several encoded transactions in a single message.
+type TxBatchMessage struct {
+	// Codec is the encoding of the transactions.
+	Codec        Codec
+	Transactions [][]byte
+}
+
+// NewTxBatchMessage encodes the given transactions into a batch message.
+func NewTxBatchMessage(txx []*core.Transaction) (*Message, error) {
+	return NewTxBatchMessageWithCodec(CodecGob, txx)
+}
+
+// NewTxBatchMessageWithCodec encodes the given transactions into a batch
+// message using the codec agreed on with the peer.
+func NewTxBatchMessageWithCodec(codec Codec, txx []*core.Transaction) (*Message, error) {
+	if len(txx) > MaxTxBatchSize {
+		return nil, fmt.Errorf("transaction batch with (%d) transactions => maximum (%d)", len(txx), MaxTxBatchSize)
+	}
+
+	batch := TxBatchMessage{
+		Codec:        codec,
+		Transactions: make([][]byte, len(txx)),
+	}
+	for i, tx := range txx {
+		data, err := encodeTx(codec, tx)
+		if err != nil {
+			return nil, err
+		}
+		batch.Transactions[i] = data
+	}
+
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(batch); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() > MaxTxBatchBytes {
+		return nil, fmt.Errorf("transaction batch of (%d) bytes => maximum (%d)", buf.Len(), MaxTxBatchBytes)
+	}
+
+	return NewMessage(MessageTypeTxBatch, buf.Bytes()), nil
+}
+
+// MaxInvHashes is the maximum number of hashes in an InvMessage or a
+// GetDataMessage.
+const MaxInvHashes = 1000
+
+// InvMessage announces the hashes of transactions a peer has, so others
+// only request the transactions they are missing.
+type InvMessage struct {
+	Hashes []types.Hash
+}
+
+// GetDataMessage requests the transactions with the given hashes.
+type GetDataMessage struct {
+	Hashes []types.Hash
+}
+
+// MaxPeerAddrs is the maximum number of addresses in a PeersMessage.
+const MaxPeerAddrs = 1000
+
+// GetPeersMessage asks a peer for the addresses of the peers it knows.
+type GetPeersMessage struct {
+	// RequestID is echoed in the PeersMessage answering the request.
+	RequestID uint64
+}
+
+// PeersMessage holds the addresses of peers other nodes can connect to.
+type PeersMessage struct {
+	// RequestID is the id of the GetPeersMessage this answers.
+	RequestID uint64
+	Addrs     []string
+}
+
+// GetTxMessage asks a peer for a single transaction, pending or confirmed.
+type GetTxMessage struct {
+	// RequestID is echoed in the TxMessage answering the request.
+	RequestID uint64
+	Hash      types.Hash
+}
+
+// TxMessage answers a GetTxMessage. Tx is empty when the peer doesn't know
+// the transaction.
+type TxMessage struct {
+	// RequestID is the id of the GetTxMessage this answers.
+	RequestID uint64
+	Hash      types.Hash
+	// Codec is the encoding of Tx.
+	Codec Codec
+	Tx    []byte
 }
 
 type GetStatusMessage struct{}
@@ -19,4 +136,24 @@ type StatusMessage struct {
 	ID            string
 	Version       uint32
 	CurrentHeight uint32
+	// GenesisHash lets peers detect nodes started with a different genesis.
+	GenesisHash types.Hash
+	// Codecs are the transaction encodings the server supports, in order of
+	// preference.
+	Codecs []Codec
+	// Compression is set when the server accepts compressed messages.
+	Compression bool
+}
+
+// PingMessage asks a peer for a PongMessage, the time until it arrives is
+// the round trip time to the peer.
+type PingMessage struct {
+	// RequestID is echoed in the PongMessage.
+	RequestID uint64
+}
+
+// PongMessage answers a PingMessage.
+type PongMessage struct {
+	// RequestID is the id of the PingMessage this answers.
+	RequestID uint64
 }
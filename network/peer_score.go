@@ -0,0 +1,238 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	scoreValidMessage   = 1
+	scoreInvalidMessage = -20
+	scoreSendFailed     = -5
+	maxPeerScore        = 100
+	// banScore is the score at which a peer gets banned, messages from a
+	// banned peer are dropped and nothing is sent to it anymore.
+	banScore = -100
+	// latencyWeight is the inverse of the weight a new round trip time gets
+	// in the moving average, so a single slow pong doesn't dominate it.
+	latencyWeight = 8
+	// peerStatsTTL is how long the stats of a disconnected peer are kept,
+	// a peer coming back within it keeps its score.
+	peerStatsTTL = 10 * time.Minute
+)
+
+// invalidDataError marks an error proving that a peer sent invalid data, like
+// a transaction with a bad signature. Only these errors cost the peer score,
+// a message rejected by local policy, like a fee below our minimum, doesn't.
+type invalidDataError struct {
+	err error
+}
+
+func (e invalidDataError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidDataError) Unwrap() error {
+	return e.err
+}
+
+func invalidData(err error) error {
+	return invalidDataError{err: err}
+}
+
+func isInvalidData(err error) bool {
+	var invalid invalidDataError
+	return errors.As(err, &invalid)
+}
+
+type PeerStats struct {
+	Addr            string
+	Score           int
+	SendsOK         int
+	SendsFailed     int
+	ValidMessages   int
+	InvalidMessages int
+	Banned          bool
+	// Latency is the moving average of the round trip times of the pings
+	// answered by the peer, zero until the first pong arrives.
+	Latency time.Duration
+}
+
+type peerScores struct {
+	lock  sync.RWMutex
+	peers map[string]*PeerStats
+	// banned holds the hosts of the banned peers, so a banned peer can't
+	// come back from another port.
+	banned map[string]struct{}
+	// disconnected holds the time the peers without a connection left,
+	// their stats are dropped after peerStatsTTL.
+	disconnected map[string]time.Time
+}
+
+func newPeerScores() *peerScores {
+	return &peerScores{
+		peers:        make(map[string]*PeerStats),
+		banned:       make(map[string]struct{}),
+		disconnected: make(map[string]time.Time),
+	}
+}
+
+// peerHost returns the host of the address without the port, addresses
+// that are not host and port pairs are returned as is.
+func peerHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+func (ps *peerScores) get(addr net.Addr) *PeerStats {
+	stats, ok := ps.peers[addr.String()]
+	if !ok {
+		_, banned := ps.banned[peerHost(addr)]
+		stats = &PeerStats{Addr: addr.String(), Banned: banned}
+		ps.peers[addr.String()] = stats
+	}
+
+	return stats
+}
+
+func (ps *peerScores) adjust(addr net.Addr, stats *PeerStats, delta int) {
+	stats.Score += delta
+	if stats.Score > maxPeerScore {
+		stats.Score = maxPeerScore
+	}
+	if stats.Score <= banScore {
+		stats.Banned = true
+		ps.banned[peerHost(addr)] = struct{}{}
+	}
+}
+
+// Connected keeps the stats of the peer while it is connected.
+func (ps *peerScores) Connected(addr net.Addr) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	delete(ps.disconnected, addr.String())
+}
+
+// Disconnected records that the peer went away and drops the stats of the
+// peers that left more than peerStatsTTL before now. Bans are kept.
+func (ps *peerScores) Disconnected(addr net.Addr, now time.Time) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.disconnected[addr.String()] = now
+
+	for key, since := range ps.disconnected {
+		if now.Sub(since) > peerStatsTTL {
+			delete(ps.peers, key)
+			delete(ps.disconnected, key)
+		}
+	}
+}
+
+func (ps *peerScores) SendResult(addr net.Addr, err error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	stats := ps.get(addr)
+	if err != nil {
+		stats.SendsFailed++
+		ps.adjust(addr, stats, scoreSendFailed)
+		return
+	}
+
+	stats.SendsOK++
+}
+
+// MessageResult records whether the peer sent us a valid message and
+// reports if the peer got banned by it.
+func (ps *peerScores) MessageResult(addr net.Addr, valid bool) bool {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	stats := ps.get(addr)
+	if valid {
+		stats.ValidMessages++
+		ps.adjust(addr, stats, scoreValidMessage)
+	} else {
+		stats.InvalidMessages++
+		ps.adjust(addr, stats, scoreInvalidMessage)
+	}
+
+	return stats.Banned
+}
+
+// RecordLatency adds a round trip time to the moving average of the peer.
+func (ps *peerScores) RecordLatency(addr net.Addr, rtt time.Duration) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	stats := ps.get(addr)
+	if stats.Latency == 0 {
+		stats.Latency = rtt
+		return
+	}
+
+	stats.Latency += (rtt - stats.Latency) / latencyWeight
+}
+
+// IsBanned returns true if the peer or another peer on the same host got
+// banned.
+func (ps *peerScores) IsBanned(addr net.Addr) bool {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	_, ok := ps.banned[peerHost(addr)]
+	return ok
+}
+
+// Rank returns the given peers that are not banned, the peers with the
+// highest score first.
+func (ps *peerScores) Rank(addrs []net.Addr) []net.Addr {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	score := func(addr net.Addr) int {
+		if stats, ok := ps.peers[addr.String()]; ok {
+			return stats.Score
+		}
+		return 0
+	}
+
+	ranked := make([]net.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := ps.banned[peerHost(addr)]; ok {
+			continue
+		}
+		ranked = append(ranked, addr)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return score(ranked[i]) > score(ranked[j])
+	})
+
+	return ranked
+}
+
+func (ps *peerScores) Stats() []PeerStats {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	stats := make([]PeerStats, 0, len(ps.peers))
+	for _, s := range ps.peers {
+		stats = append(stats, *s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Addr < stats[j].Addr
+	})
+
+	return stats
+}
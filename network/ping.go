@@ -0,0 +1,72 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+)
+
+// Ping sends a ping to the given peer. The round trip time until its pong
+// arrives goes into the latency of the peer in the server stats.
+func (s *Server) Ping(to net.Addr) error {
+	ping := &PingMessage{
+		RequestID: s.requests.Open(to, MessageTypePong, s.Clock.Now()),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(ping); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypePing, buf.Bytes())
+
+	return s.sendMessage(to, msg.Bytes())
+}
+
+// pingLoop pings every peer each PingInterval.
+func (s *Server) pingLoop() {
+	ticker := s.Clock.NewTicker(s.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			for _, addr := range s.peerScores.Rank(s.peers()) {
+				if err := s.Ping(addr); err != nil {
+					s.Logger.Log("msg", "ping failed", "addr", addr, "err", err)
+				}
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+func (s *Server) processPingMessage(from net.Addr, data *PingMessage) error {
+	pong := &PongMessage{
+		RequestID: data.RequestID,
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(pong); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypePong, buf.Bytes())
+
+	return s.sendMessage(from, msg.Bytes())
+}
+
+// processPongMessage records the round trip time of the ping it answers.
+// A pong arriving after RequestTimeout is discarded.
+func (s *Server) processPongMessage(from net.Addr, data *PongMessage) error {
+	rtt, ok := s.requests.CloseElapsed(data.RequestID, from, MessageTypePong, s.Clock.Now())
+	if !ok {
+		s.Logger.Log("msg", "discarding unrequested pong", "from", from, "requestID", data.RequestID)
+		return nil
+	}
+
+	s.peerScores.RecordLatency(from, rtt)
+
+	return nil
+}
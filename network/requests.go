@@ -0,0 +1,97 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// pendingRequest is a request sent to a peer that waits for its response.
+type pendingRequest struct {
+	peer     string
+	response MessageType
+	opened   time.Time
+	deadline time.Time
+}
+
+// requestTracker hands out the ids of requests sent to peers and matches the
+// responses to them. Requests without a response are dropped after the
+// timeout, a response arriving later is unmatched.
+type requestTracker struct {
+	lock    sync.Mutex
+	timeout time.Duration
+	lastID  uint64
+	pending map[uint64]pendingRequest
+}
+
+func newRequestTracker(timeout time.Duration) *requestTracker {
+	return &requestTracker{
+		timeout: timeout,
+		pending: make(map[uint64]pendingRequest),
+	}
+}
+
+// Open records a request to the peer that is answered with a message of the
+// given type and returns its id. Ids start at 1, zero is never handed out.
+func (t *requestTracker) Open(peer net.Addr, response MessageType, now time.Time) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire(now)
+
+	t.lastID++
+	t.pending[t.lastID] = pendingRequest{
+		peer:     peer.String(),
+		response: response,
+		opened:   now,
+		deadline: now.Add(t.timeout),
+	}
+
+	return t.lastID
+}
+
+// Close matches the response to its request and reports whether it was
+// expected. The request is done afterwards, a second response with the same
+// id is unmatched.
+func (t *requestTracker) Close(id uint64, peer net.Addr, response MessageType, now time.Time) bool {
+	_, ok := t.CloseElapsed(id, peer, response, now)
+	return ok
+}
+
+// CloseElapsed is Close that also returns the time since the request was
+// opened, the round trip time of the request.
+func (t *requestTracker) CloseElapsed(id uint64, peer net.Addr, response MessageType, now time.Time) (time.Duration, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire(now)
+
+	req, ok := t.pending[id]
+	if !ok || req.peer != peer.String() || req.response != response {
+		return 0, false
+	}
+
+	delete(t.pending, id)
+
+	return now.Sub(req.opened), true
+}
+
+// Len returns the number of requests waiting for a response.
+func (t *requestTracker) Len(now time.Time) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire(now)
+
+	return len(t.pending)
+}
+
+// expire drops the requests past their deadline, the caller has to hold the
+// lock.
+func (t *requestTracker) expire(now time.Time) {
+	for id, req := range t.pending {
+		if now.After(req.deadline) {
+			delete(t.pending, id)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTrackerMatchesResponses(t *testing.T) {
+	tracker := newRequestTracker(time.Minute)
+	now := time.Unix(1700000000, 0)
+	peer := NetAddr("PEER")
+
+	id := tracker.Open(peer, MessageTypeBlocks, now)
+	assert.Equal(t, 1, tracker.Len(now))
+
+	// Unknown ids, other peers and other response types don't match.
+	assert.False(t, tracker.Close(id+1, peer, MessageTypeBlocks, now))
+	assert.False(t, tracker.Close(id, NetAddr("OTHER"), MessageTypeBlocks, now))
+	assert.False(t, tracker.Close(id, peer, MessageTypePeers, now))
+
+	assert.True(t, tracker.Close(id, peer, MessageTypeBlocks, now))
+	assert.False(t, tracker.Close(id, peer, MessageTypeBlocks, now))
+	assert.Equal(t, 0, tracker.Len(now))
+}
+
+func TestRequestTrackerTimeout(t *testing.T) {
+	tracker := newRequestTracker(time.Minute)
+	now := time.Unix(1700000000, 0)
+	peer := NetAddr("PEER")
+
+	id := tracker.Open(peer, MessageTypePeers, now)
+	tracker.Open(peer, MessageTypePeers, now.Add(time.Minute))
+	assert.Equal(t, 2, tracker.Len(now.Add(time.Minute)))
+
+	// The first request timed out, its late response is unmatched.
+	later := now.Add(time.Minute + time.Second)
+	assert.Equal(t, 1, tracker.Len(later))
+	assert.False(t, tracker.Close(id, peer, MessageTypePeers, later))
+}
+
+func TestRequestTrackerCloseElapsed(t *testing.T) {
+	tracker := newRequestTracker(time.Minute)
+	now := time.Unix(1700000000, 0)
+	peer := NetAddr("PEER")
+
+	id := tracker.Open(peer, MessageTypePong, now)
+	rtt, ok := tracker.CloseElapsed(id, peer, MessageTypePong, now.Add(150*time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 150*time.Millisecond, rtt)
+
+	_, ok = tracker.CloseElapsed(id, peer, MessageTypePong, now)
+	assert.False(t, ok)
+}
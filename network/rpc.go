@@ -3,8 +3,11 @@ package network
 import (
 	"bytes"
 	"crypto/elliptic"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net"
 
@@ -21,29 +24,153 @@ const (
 	MessageTypeStatus    MessageType = 0x4
 	MessageTypeGetStatus MessageType = 0x5
 	MessageTypeBlocks    MessageType = 0x6
+	MessageTypeTxBatch   MessageType = 0x7
+	MessageTypeInv       MessageType = 0x8
+	MessageTypeGetData   MessageType = 0x9
+	MessageTypeGetPeers  MessageType = 0xa
+	MessageTypePeers     MessageType = 0xb
+	// MessageTypeCompressed wraps the gzip compressed frame of another
+	// message, DecodeMessage returns the wrapped message.
+	MessageTypeCompressed MessageType = 0xc
+	MessageTypeGetTx      MessageType = 0xd
+	MessageTypeTxResponse MessageType = 0xe
+	MessageTypePing       MessageType = 0xf
+	MessageTypePong       MessageType = 0x10
 )
 
+// requiresOrdering returns true for the decoded messages a peer's messages
+// have to be processed in order for. The status handshake comes before the
+// sync it starts, and the blocks of a peer build on each other.
+// Transactions, inventories and peer addresses are handled independent of
+// each other. Messages of a custom decoder keep their order.
+func requiresOrdering(data any) bool {
+	switch data.(type) {
+	case *core.Transaction, []*core.Transaction, *InvMessage, *GetDataMessage,
+		*GetPeersMessage, *PeersMessage, *GetTxMessage, *TxMessage, *PingMessage, *PongMessage:
+		return false
+	default:
+		return true
+	}
+}
+
 type RPC struct {
 	From    net.Addr //string
 	Payload io.Reader
 }
 
+// MessageVersion is the version of the wire format spoken by this node,
+// messages with another version are rejected.
+const MessageVersion byte = 1
+
+var ErrUnsupportedVersion = errors.New("unsupported message version")
+
 type Message struct {
-	Header MessageType
-	Data   []byte
+	Version byte
+	Header  MessageType
+	Data    []byte
 }
 
 func NewMessage(t MessageType, data []byte) *Message {
 	return &Message{
-		Header: t,
-		Data:   data,
+		Version: MessageVersion,
+		Header:  t,
+		Data:    data,
+	}
+}
+
+// checksumSize is the length of the CRC32 checksum in front of every encoded
+// message.
+const checksumSize = 4
+
+var (
+	ErrChecksumMismatch = errors.New("message checksum mismatch")
+	ErrMagicMismatch    = errors.New("network magic mismatch")
+)
+
+// magicSize is the length of the network magic in front of a frame.
+const magicSize = 4
+
+// withMagic prefixes the frame with the network magic.
+func withMagic(magic uint32, frame []byte) []byte {
+	b := make([]byte, magicSize, magicSize+len(frame))
+	binary.BigEndian.PutUint32(b, magic)
+
+	return append(b, frame...)
+}
+
+// stripMagic verifies the network magic in front of the frame and returns
+// the frame without it.
+func stripMagic(magic uint32, b []byte) ([]byte, error) {
+	if len(b) < magicSize {
+		return nil, fmt.Errorf("%w: frame of (%d) bytes is too short", ErrMagicMismatch, len(b))
+	}
+
+	if got := binary.BigEndian.Uint32(b); got != magic {
+		return nil, fmt.Errorf("%w: (%08x) => expected (%08x)", ErrMagicMismatch, got, magic)
 	}
+
+	return b[magicSize:], nil
 }
 
+// Bytes encodes the message into a frame, the gob encoded message prefixed
+// with its CRC32 checksum so corrupted frames are detected before decoding.
 func (msg *Message) Bytes() []byte {
 	buf := &bytes.Buffer{}
 	gob.NewEncoder(buf).Encode(msg)
-	return buf.Bytes()
+
+	frame := make([]byte, checksumSize, checksumSize+buf.Len())
+	binary.BigEndian.PutUint32(frame, crc32.ChecksumIEEE(buf.Bytes()))
+
+	return append(frame, buf.Bytes()...)
+}
+
+// DecodeMessage verifies the checksum of the frame and decodes the message.
+// A compressed message is decompressed and the message it wraps returned.
+func DecodeMessage(frame []byte) (*Message, error) {
+	msg, err := decodeFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Header != MessageTypeCompressed {
+		return msg, nil
+	}
+
+	if frame, err = decompressFrame(msg); err != nil {
+		return nil, err
+	}
+
+	if msg, err = decodeFrame(frame); err != nil {
+		return nil, err
+	}
+
+	if msg.Header == MessageTypeCompressed {
+		return nil, fmt.Errorf("compressed message wraps another compressed message")
+	}
+
+	return msg, nil
+}
+
+func decodeFrame(frame []byte) (*Message, error) {
+	if len(frame) < checksumSize {
+		return nil, fmt.Errorf("%w: frame of (%d) bytes is too short", ErrChecksumMismatch, len(frame))
+	}
+
+	data := frame[checksumSize:]
+	if binary.BigEndian.Uint32(frame) != crc32.ChecksumIEEE(data) {
+		return nil, ErrChecksumMismatch
+	}
+
+	msg := new(Message)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(msg); err != nil {
+		return nil, err
+	}
+
+	if msg.Version != MessageVersion {
+		return nil, fmt.Errorf("%w: message version (%d) => supported (%d)", ErrUnsupportedVersion, msg.Version, MessageVersion)
+	}
+
+	return msg, nil
 }
 
 type DecodedMessage struct {
@@ -53,10 +180,23 @@ type DecodedMessage struct {
 
 type RPCDecodeFunc func(RPC) (*DecodedMessage, error)
 
-func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
-	msg := Message{}
-	if err := gob.NewDecoder(rpc.Payload).Decode(&msg); err != nil {
-		return nil, fmt.Errorf("failed to decode message from %s: %s", rpc.From, err)
+func DefaultRPCDecodeFunc(rpc RPC) (_ *DecodedMessage, err error) {
+	// The payload comes straight from the network and gob can panic on
+	// malformed input, turn it into an error so a peer can't crash the node.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to decode message from %s: panic: %v", rpc.From, r)
+		}
+	}()
+
+	frame, err := io.ReadAll(rpc.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message from %s: %w", rpc.From, err)
+	}
+
+	msg, err := DecodeMessage(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message from %s: %w", rpc.From, err)
 	}
 
 	// fmt.Printf("receiving message: %+v\n", msg)
@@ -70,7 +210,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 	case MessageTypeTx:
 		tx := new(core.Transaction)
 		if err := tx.Decode(core.NewGobTxDecoder(bytes.NewReader(msg.Data))); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to decode transaction from %s: %w", rpc.From, err)
 		}
 
 		return &DecodedMessage{
@@ -78,10 +218,77 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: tx,
 		}, nil
 
+	case MessageTypeTxBatch:
+		txx, err := decodeTxBatch(msg.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode transaction batch from %s: %w", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: txx,
+		}, nil
+
+	case MessageTypeInv:
+		inv := new(InvMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(inv); err != nil {
+			return nil, fmt.Errorf("failed to decode inv message from %s: %w", rpc.From, err)
+		}
+
+		if len(inv.Hashes) > MaxInvHashes {
+			return nil, fmt.Errorf("inv message with (%d) hashes => maximum (%d)", len(inv.Hashes), MaxInvHashes)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: inv,
+		}, nil
+
+	case MessageTypeGetData:
+		getData := new(GetDataMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getData); err != nil {
+			return nil, fmt.Errorf("failed to decode getdata message from %s: %w", rpc.From, err)
+		}
+
+		if len(getData.Hashes) > MaxInvHashes {
+			return nil, fmt.Errorf("getdata message with (%d) hashes => maximum (%d)", len(getData.Hashes), MaxInvHashes)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getData,
+		}, nil
+
+	case MessageTypeGetPeers:
+		getPeers := new(GetPeersMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getPeers); err != nil {
+			return nil, fmt.Errorf("failed to decode getpeers message from %s: %w", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getPeers,
+		}, nil
+
+	case MessageTypePeers:
+		peers := new(PeersMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(peers); err != nil {
+			return nil, fmt.Errorf("failed to decode peers message from %s: %w", rpc.From, err)
+		}
+
+		if len(peers.Addrs) > MaxPeerAddrs {
+			return nil, fmt.Errorf("peers message with (%d) addresses => maximum (%d)", len(peers.Addrs), MaxPeerAddrs)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: peers,
+		}, nil
+
 	case MessageTypeBlock:
 		block := new(core.Block)
 		if err := block.Decode(core.NewGobBlockDecoder(bytes.NewReader(msg.Data))); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to decode block from %s: %w", rpc.From, err)
 		}
 
 		return &DecodedMessage{
@@ -98,7 +305,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 	case MessageTypeStatus:
 		statusMessage := new(StatusMessage)
 		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(statusMessage); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to decode status message from %s: %w", rpc.From, err)
 		}
 
 		return &DecodedMessage{
@@ -109,7 +316,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 	case MessageTypeGetBlocks:
 		getBlocks := new(GetBlocksMessage)
 		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlocks); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to decode getblocks message from %s: %w", rpc.From, err)
 		}
 
 		return &DecodedMessage{
@@ -120,7 +327,13 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 	case MessageTypeBlocks:
 		blocks := new(BlocksMessage)
 		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(blocks); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to decode blocks message from %s: %w", rpc.From, err)
+		}
+
+		for i, b := range blocks.Blocks {
+			if b == nil || b.Header == nil {
+				return nil, fmt.Errorf("failed to decode block (%d) of blocks from %s: %w", i, rpc.From, core.ErrNilHeader)
+			}
 		}
 
 		return &DecodedMessage{
@@ -128,11 +341,81 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: blocks,
 		}, nil
 
+	case MessageTypeGetTx:
+		getTx := new(GetTxMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getTx); err != nil {
+			return nil, fmt.Errorf("failed to decode gettx message from %s: %w", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getTx,
+		}, nil
+
+	case MessageTypeTxResponse:
+		txMessage := new(TxMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(txMessage); err != nil {
+			return nil, fmt.Errorf("failed to decode tx response from %s: %w", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: txMessage,
+		}, nil
+
+	case MessageTypePing:
+		ping := new(PingMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(ping); err != nil {
+			return nil, fmt.Errorf("failed to decode ping message from %s: %w", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: ping,
+		}, nil
+
+	case MessageTypePong:
+		pong := new(PongMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(pong); err != nil {
+			return nil, fmt.Errorf("failed to decode pong message from %s: %w", rpc.From, err)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: pong,
+		}, nil
+
 	default:
-		return nil, fmt.Errorf("invalid message header %x", msg.Header)
+		return nil, fmt.Errorf("invalid message header %x from %s", msg.Header, rpc.From)
 	}
 }
 
+func decodeTxBatch(data []byte) ([]*core.Transaction, error) {
+	if len(data) > MaxTxBatchBytes {
+		return nil, fmt.Errorf("transaction batch of (%d) bytes => maximum (%d)", len(data), MaxTxBatchBytes)
+	}
+
+	batch := new(TxBatchMessage)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(batch); err != nil {
+		return nil, err
+	}
+
+	if len(batch.Transactions) > MaxTxBatchSize {
+		return nil, fmt.Errorf("transaction batch with (%d) transactions => maximum (%d)", len(batch.Transactions), MaxTxBatchSize)
+	}
+
+	txx := make([]*core.Transaction, len(batch.Transactions))
+	for i, data := range batch.Transactions {
+		tx, err := decodeTx(batch.Codec, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode transaction (%d) of batch: %w", i, err)
+		}
+		txx[i] = tx
+	}
+
+	return txx, nil
+}
+
 type RPCProcessor interface {
 	ProcessMessage(*DecodedMessage) error
 }
@@ -0,0 +1,206 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/ayushn2/blockchainz/util"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzDefaultRPCDecodeFunc(f *testing.F) {
+	tx := util.NewRandomTransaction(32)
+	assert.Nil(f, tx.Sign(crypto.GeneratePrivateKey()))
+	buf := &bytes.Buffer{}
+	assert.Nil(f, tx.Encode(core.NewGobTxEncoder(buf)))
+
+	f.Add(NewMessage(MessageTypeTx, buf.Bytes()).Bytes())
+	f.Add(NewMessage(MessageTypeBlock, buf.Bytes()).Bytes())
+	f.Add(NewMessage(MessageTypeGetStatus, nil).Bytes())
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		msg, err := DefaultRPCDecodeFunc(RPC{
+			From:    &net.TCPAddr{},
+			Payload: bytes.NewReader(payload),
+		})
+		if err != nil {
+			assert.Nil(t, msg)
+		}
+	})
+}
+
+func TestDecodeTxBatch(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	txx := make([]*core.Transaction, 5)
+	for i := range txx {
+		txx[i] = util.NewRandomTransaction(32)
+		assert.Nil(t, txx[i].Sign(privKey))
+	}
+	// A duplicate in the batch only enters the mempool once.
+	txx = append(txx, txx[0])
+
+	msg, err := NewTxBatchMessage(txx)
+	assert.Nil(t, err)
+
+	decoded, err := DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(msg.Bytes()),
+	})
+	assert.Nil(t, err)
+
+	batch, ok := decoded.Data.([]*core.Transaction)
+	assert.True(t, ok)
+	assert.Len(t, batch, len(txx))
+
+	s, err := NewServer(ServerOpts{ID: "NODE", Logger: log.NewNopLogger()})
+	assert.Nil(t, err)
+	assert.Nil(t, s.ProcessMessage(decoded))
+	assert.Equal(t, 5, s.mempool.PendingCount())
+	for _, tx := range txx {
+		assert.True(t, s.mempool.Contains(tx.Hash(core.TxHasher{})))
+	}
+}
+
+func TestDecodeTxBatchTooLarge(t *testing.T) {
+	data := make([][]byte, MaxTxBatchSize+1)
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(TxBatchMessage{Transactions: data}))
+
+	_, err := DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(NewMessage(MessageTypeTxBatch, buf.Bytes()).Bytes()),
+	})
+	assert.NotNil(t, err)
+}
+
+func TestDecodeTxBatchTooManyBytes(t *testing.T) {
+	data := make([]byte, MaxTxBatchBytes+1)
+
+	_, err := DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(NewMessage(MessageTypeTxBatch, data).Bytes()),
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "bytes => maximum")
+
+	tx := core.NewTransaction(make([]byte, MaxTxBatchBytes/2))
+	_, err = NewTxBatchMessage([]*core.Transaction{tx, tx, tx})
+	assert.NotNil(t, err)
+}
+
+func TestDecodeCorruptedFrame(t *testing.T) {
+	frame := NewMessage(MessageTypeGetStatus, []byte("status")).Bytes()
+
+	msg, err := DecodeMessage(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeGetStatus, msg.Header)
+
+	frame[len(frame)-1] ^= 0x01
+
+	_, err = DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(frame),
+	})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	_, err = DecodeMessage(frame[:2])
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestDecodeFutureMessageVersion(t *testing.T) {
+	msg := NewMessage(MessageTypeGetStatus, nil)
+	msg.Version = MessageVersion + 1
+
+	_, err := DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(msg.Bytes()),
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+type failingReader struct{ err error }
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestDecodeWrapsUnderlyingError(t *testing.T) {
+	errRead := errors.New("connection reset")
+	_, err := DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: failingReader{err: errRead},
+	})
+	assert.ErrorIs(t, err, errRead)
+
+	tx := util.NewRandomTransaction(32)
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(core.NewGobTxEncoder(buf)))
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	for _, msg := range []*Message{
+		NewMessage(MessageTypeTx, truncated),
+		NewMessage(MessageTypeBlock, truncated),
+	} {
+		_, err = DefaultRPCDecodeFunc(RPC{
+			From:    &net.TCPAddr{},
+			Payload: bytes.NewReader(msg.Bytes()),
+		})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+
+	batch := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(batch).Encode(TxBatchMessage{Transactions: [][]byte{truncated}}))
+	_, err = DefaultRPCDecodeFunc(RPC{
+		From:    &net.TCPAddr{},
+		Payload: bytes.NewReader(NewMessage(MessageTypeTxBatch, batch.Bytes()).Bytes()),
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestDecodeErrorsNameThePeer(t *testing.T) {
+	from := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 3000}
+
+	for msgType, data := range map[MessageType]any{
+		MessageTypeInv:      &InvMessage{Hashes: []types.Hash{{1}}},
+		MessageTypeGetData:  &GetDataMessage{Hashes: []types.Hash{{1}}},
+		MessageTypeGetPeers: &GetPeersMessage{RequestID: 1},
+		MessageTypeStatus:   &StatusMessage{ID: "NODE", CurrentHeight: 1},
+	} {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, gob.NewEncoder(buf).Encode(data))
+		truncated := buf.Bytes()[:buf.Len()-1]
+
+		_, err := DefaultRPCDecodeFunc(RPC{
+			From:    from,
+			Payload: bytes.NewReader(NewMessage(msgType, truncated).Bytes()),
+		})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Contains(t, err.Error(), from.String())
+	}
+}
+
+func TestDecodeBlockWithoutHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, (&core.Block{}).Encode(core.NewGobBlockEncoder(buf)))
+	block := NewMessage(MessageTypeBlock, buf.Bytes())
+
+	buf = &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(&BlocksMessage{Blocks: []*core.Block{{}}}))
+	blocks := NewMessage(MessageTypeBlocks, buf.Bytes())
+
+	for _, msg := range []*Message{block, blocks} {
+		_, err := DefaultRPCDecodeFunc(RPC{
+			From:    &net.TCPAddr{},
+			Payload: bytes.NewReader(msg.Bytes()),
+		})
+		assert.ErrorIs(t, err, core.ErrNilHeader)
+	}
+}
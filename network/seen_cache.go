@@ -0,0 +1,61 @@
+package network
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/ayushn2/blockchainz/types"
+)
+
+// seenCache is a bounded set of hashes. When the cache is full the least
+// recently seen hash is evicted.
+type seenCache struct {
+	lock  sync.Mutex
+	size  int
+	order *list.List
+	items map[types.Hash]*list.Element
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[types.Hash]*list.Element),
+	}
+}
+
+// Add marks the hash as seen and reports whether it was seen before.
+func (c *seenCache) Add(hash types.Hash) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.order.MoveToFront(el)
+		return true
+	}
+
+	c.items[hash] = c.order.PushFront(hash)
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(types.Hash))
+	}
+
+	return false
+}
+
+func (c *seenCache) Contains(hash types.Hash) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.items[hash]
+	return ok
+}
+
+func (c *seenCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
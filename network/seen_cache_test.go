@@ -0,0 +1,25 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/ayushn2/blockchainz/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeenCache(t *testing.T) {
+	c := newSeenCache(2)
+	a, b, d := util.RandomHash(), util.RandomHash(), util.RandomHash()
+
+	assert.False(t, c.Add(a))
+	assert.True(t, c.Add(a))
+	assert.False(t, c.Add(b))
+
+	// a was seen more recently than b, so b gets evicted.
+	assert.True(t, c.Add(a))
+	assert.False(t, c.Add(d))
+	assert.Equal(t, 2, c.Len())
+	assert.True(t, c.Contains(a))
+	assert.False(t, c.Contains(b))
+	assert.True(t, c.Contains(d))
+}
@@ -3,10 +3,18 @@ package network
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
 	"net"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ayushn2/blockchainz/core"
@@ -17,6 +25,65 @@ import (
 
 var defaultBlockTime = 5 * time.Second
 
+// RetryPolicy controls how connecting to a peer is retried, the wait between
+// attempts doubles from InitialBackoff up to MaxBackoff.
+type RetryPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxAttempts limits the number of connection attempts, zero keeps
+	// retrying until the server is stopped.
+	MaxAttempts int
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+var (
+	ErrFeeTooLow           = errors.New("transaction fee below minimum")
+	ErrPeerSlotsFull       = errors.New("no free peer slots")
+	ErrTxKnown             = errors.New("transaction already known")
+	ErrTxTooLarge          = errors.New("transaction data too large")
+	ErrValidationSlotsFull = errors.New("no free block validation slots")
+)
+
+// seenBlocksSize is the number of recent block hashes a server remembers
+// to avoid validating and relaying the same block twice.
+const seenBlocksSize = 1024
+
+// defaultPeerAddrLimit is the number of peer addresses shared with and
+// dialed from a single peer when ServerOpts.PeerAddrLimit is not set.
+const defaultPeerAddrLimit = 32
+
+// defaultGossipQueueSize is the number of transaction announcements waiting
+// to be sent when ServerOpts.GossipQueueSize is not set.
+const defaultGossipQueueSize = 1024
+
+// defaultMaxTxDataSize is the largest transaction data accepted when
+// ServerOpts.MaxTxDataSize is not set.
+const defaultMaxTxDataSize = 1 << 20
+
+// defaultRequestTimeout is the time a peer has to answer a request when
+// ServerOpts.RequestTimeout is not set.
+const defaultRequestTimeout = 30 * time.Second
+
+// defaultCompressionThreshold is the smallest message compressed when
+// ServerOpts.CompressionThreshold is not set.
+const defaultCompressionThreshold = 1024
+
+// defaultMempoolSamples is the number of mempool samples kept when
+// ServerOpts.MempoolSamples is not set, an hour at one sample every 10s.
+const defaultMempoolSamples = 360
+
+// FanoutSqrt sets ServerOpts.BroadcastFanout to the square root of the
+// number of peers.
+const FanoutSqrt = -1
+
+// minBroadcastFanout is the smallest fan-out of a limited broadcast, smaller
+// networks get every broadcast directly.
+const minBroadcastFanout = 3
+
 type ServerOpts struct {
 	SeedNodes     []string
 	ListenAddr    string
@@ -27,27 +94,247 @@ type ServerOpts struct {
 	RPCProcessor  RPCProcessor
 	BlockTime     time.Duration
 	PrivateKey    *crypto.PrivateKey
+	// PrivateKeys lets a single node sign blocks with several validator
+	// identities, the key signing a block is picked round-robin by height.
+	// PrivateKey, if set, is used as the first key.
+	PrivateKeys []crypto.PrivateKey
+	// Observer follows the chain without taking part in it. Received blocks
+	// and transactions are validated and applied, but they are not relayed
+	// and no blocks are produced, even with PrivateKeys set.
+	Observer bool
+	// Genesis holds the initial state of the chain, all nodes on the
+	// network need to be started with the same genesis.
+	Genesis *core.Genesis
+	// BlockReward is paid to validators through a coinbase transaction in
+	// every block, all nodes on the network need to use the same reward.
+	BlockReward uint64
+	// Validators, if set, are the only addresses allowed to sign blocks,
+	// otherwise blocks of any validator are accepted. All nodes on the
+	// network need to use the same validators.
+	Validators []types.Address
+	// Checkpoints are trusted block hashes by height, blocks at these
+	// heights with another hash are rejected, which protects syncing nodes
+	// from long alternative chains.
+	Checkpoints map[uint32]types.Hash
+	// MinFee is the lowest fee a transaction needs to pay to be accepted
+	// into the mempool.
+	MinFee uint64
+	// MaxTxDataSize is the largest data, in bytes, a transaction may carry
+	// to be accepted into the mempool. It defaults to 1 MiB.
+	MaxTxDataSize int
+	// MaxBlockSize limits the total size of the transactions in a block the
+	// validator produces, zero allows any size. Transactions that don't fit
+	// stay in the mempool for the next block.
+	MaxBlockSize int
+	// TxOrdering decides which pending transactions go into a block first
+	// when they don't all fit into MaxBlockSize.
+	TxOrdering TxOrdering
+	// TxTimestampTolerance, if set, only accepts transactions into the
+	// mempool whose timestamp is at most this far from the local time.
+	// Transactions without a timestamp are rejected then.
+	TxTimestampTolerance time.Duration
+	// TxValidator, if set, is called with the data of every incoming
+	// transaction, so applications can reject payloads they don't understand
+	// before they enter the mempool.
+	TxValidator func([]byte) error
+	// Transports are used next to the TCP transport, messages from peers
+	// that are not connected over TCP are sent through them.
+	Transports []Transport
+	// PeerAddrLimit caps the number of addresses sent in reply to a getpeers
+	// message and the number of new peers dialed from a single peers
+	// message.
+	PeerAddrLimit int
+	// GossipQueueSize is the number of new transactions waiting to be
+	// announced to the peers, further transactions are not announced while
+	// the queue is full.
+	GossipQueueSize int
+	// RequestTimeout is the time a peer has to answer a getblocks or
+	// getpeers request, responses arriving later are discarded.
+	RequestTimeout time.Duration
+	// MempoolSampleInterval, if set, records the number of pending
+	// transactions at this interval, the recent samples are part of the
+	// server stats.
+	MempoolSampleInterval time.Duration
+	// MempoolSamples is the number of recent mempool samples kept, older
+	// samples are overwritten.
+	MempoolSamples int
+	// PingInterval, if set, pings every peer at this interval. The moving
+	// average of the round trip times is the latency in the peer stats.
+	PingInterval time.Duration
+	// NetworkMagic, if not zero, is sent in front of every message and
+	// messages starting with another magic are dropped. It keeps nodes of
+	// different networks, or other protocols on the same port, apart.
+	NetworkMagic uint32
+	// Codecs are the encodings used for transactions sent to peers, in
+	// order of preference. The codec for a peer is agreed on when the peers
+	// exchange their status, gob is used when none is shared.
+	Codecs []Codec
+	// Compression gzips the messages to peers that support it as well, the
+	// peers agree on it when they exchange their status. Only messages of
+	// at least CompressionThreshold bytes are compressed.
+	Compression          bool
+	CompressionThreshold int
+	// MaxBlockValidations limits the relayed blocks validated at the same
+	// time, further blocks are rejected until a validation finishes. The
+	// sender is not penalized, the block arrives again from another peer or
+	// with the next sync. Zero allows any number.
+	MaxBlockValidations int
+	// BroadcastFanout limits the number of peers a new block or
+	// transaction announcement is sent to, they are picked at random and
+	// relay it to their peers. FanoutSqrt sends it to the square root of
+	// the number of peers, zero to all peers. A limited broadcast still
+	// goes to at least 3 peers.
+	BroadcastFanout int
+	// MaxPeers limits the number of TCP peers, zero allows any number.
+	MaxPeers int
+	// OutboundSlots are the peer slots reserved for connections we dialed,
+	// so peers connecting to us can't take all of them.
+	OutboundSlots int
+	// TCPOptions are applied to all TCP connections, DefaultTCPOptions are
+	// used when it is nil.
+	TCPOptions *TCPOptions
+	// SkipEmptyBlocks stops the validator from producing blocks while the
+	// mempool is empty, by default a block is produced every BlockTime.
+	SkipEmptyBlocks bool
+	// KeepAliveBlockTime, if set together with SkipEmptyBlocks, still
+	// produces an empty block when no block was produced for this long.
+	KeepAliveBlockTime time.Duration
+	// Clock is the source of time, RealClock is used when it is nil.
+	Clock Clock
+	// RPCWorkers is the number of goroutines processing incoming messages.
+	// Messages of a single peer are always processed in the order they
+	// arrived, messages of different peers are processed in parallel.
+	RPCWorkers int
+	// RelaxedOrdering keeps the order of a peer's messages only for the
+	// message types that require it. The worker of the peer decodes every
+	// message and hands the other ones to a pool of RPCWorkers processing
+	// them in parallel.
+	RelaxedOrdering bool
+	// DNSSeeds are host names with a port, like "seed.example.org:3000",
+	// resolved at startup. Every address a seed resolves to is dialed like
+	// a seed node.
+	DNSSeeds []string
+	// Resolver looks up the DNSSeeds, net.DefaultResolver is used when it
+	// is nil.
+	Resolver Resolver
+	// SeedRetry is the policy for connecting to the seed nodes, a seed that
+	// is down at startup is retried in the background.
+	SeedRetry RetryPolicy
+	// ReconnectRetry is the policy for dialing a peer again after the
+	// connection we dialed dropped. Peers that connected to us are left to
+	// reconnect themselves.
+	ReconnectRetry RetryPolicy
+	// PruneDepth, if set, keeps only the full blocks of the most recent
+	// PruneDepth heights, older blocks are reduced to their header. Requests
+	// for pruned blocks are answered as not available.
+	PruneDepth uint32
+	// MaxReorgDepth is the maximum number of blocks a reorg may roll back,
+	// zero allows reorgs of any depth.
+	MaxReorgDepth uint32
+	// BlockHasher and TxHasher replace the hashers of the chain and the
+	// mempool, they default to core.BlockHasher and core.TxHasher. All nodes
+	// of a network have to use the same hashers.
+	BlockHasher core.Hasher[*core.Header]
+	TxHasher    core.Hasher[*core.Transaction]
+	// WSListenAddr, if set, serves a websocket endpoint at /ws streaming new
+	// blocks and mempool transactions as JSON, and the peer stats at /peers.
+	WSListenAddr string
 }
 
 type Server struct {
+	// knownMessages counts the blocks and transactions received again. It
+	// is the first field to be 64-bit aligned for atomic access.
+	knownMessages uint64
+
 	TCPTransport *TCPTransport
 	peerCh       chan *TCPPeer
 
 	mu      sync.RWMutex
 	peerMap map[net.Addr]*TCPPeer
+	// peerCodecs holds the codec agreed on with a peer, keyed by address.
+	peerCodecs map[string]Codec
+	// peerCompression holds the peers messages are compressed for, keyed
+	// by address.
+	peerCompression map[string]bool
+	// validationSlots limits the concurrent block validations, it is nil
+	// when the number is not limited.
+	validationSlots chan struct{}
 
 	ServerOpts
 	mempool     *TxPool
 	chain       *core.Blockchain
+	seenBlocks  *seenCache
+	peerScores  *peerScores
+	requests    *requestTracker
+	ws          *wsServer
+	wsHTTP      *http.Server
 	isValidator bool
 	rpcCh       chan RPC
+	gossipCh    chan types.Hash
 	quitCh      chan struct{}
+
+	// mempoolSamples is nil when sampling is disabled.
+	mempoolSamples *sampleRing
 }
 
 func NewServer(opts ServerOpts) (*Server, error) {
 	if opts.BlockTime == time.Duration(0) {
 		opts.BlockTime = defaultBlockTime
 	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	if opts.SeedRetry.InitialBackoff == 0 {
+		opts.SeedRetry.InitialBackoff = defaultRetryPolicy.InitialBackoff
+	}
+	if opts.SeedRetry.MaxBackoff == 0 {
+		opts.SeedRetry.MaxBackoff = defaultRetryPolicy.MaxBackoff
+	}
+	if opts.SeedRetry.MaxBackoff < opts.SeedRetry.InitialBackoff {
+		opts.SeedRetry.MaxBackoff = opts.SeedRetry.InitialBackoff
+	}
+	if opts.ReconnectRetry.InitialBackoff == 0 {
+		opts.ReconnectRetry.InitialBackoff = defaultRetryPolicy.InitialBackoff
+	}
+	if opts.ReconnectRetry.MaxBackoff == 0 {
+		opts.ReconnectRetry.MaxBackoff = defaultRetryPolicy.MaxBackoff
+	}
+	if opts.ReconnectRetry.MaxBackoff < opts.ReconnectRetry.InitialBackoff {
+		opts.ReconnectRetry.MaxBackoff = opts.ReconnectRetry.InitialBackoff
+	}
+	if opts.Clock == nil {
+		opts.Clock = RealClock{}
+	}
+	if opts.MaxPeers > 0 && opts.OutboundSlots > opts.MaxPeers {
+		opts.OutboundSlots = opts.MaxPeers
+	}
+	if opts.MaxTxDataSize <= 0 {
+		opts.MaxTxDataSize = defaultMaxTxDataSize
+	}
+	if len(opts.Codecs) == 0 {
+		opts.Codecs = []Codec{CodecGob}
+	}
+	if opts.CompressionThreshold <= 0 {
+		opts.CompressionThreshold = defaultCompressionThreshold
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = defaultRequestTimeout
+	}
+	if opts.MempoolSamples <= 0 {
+		opts.MempoolSamples = defaultMempoolSamples
+	}
+	if opts.GossipQueueSize <= 0 {
+		opts.GossipQueueSize = defaultGossipQueueSize
+	}
+	if opts.PeerAddrLimit <= 0 {
+		opts.PeerAddrLimit = defaultPeerAddrLimit
+	}
+	if opts.PeerAddrLimit > MaxPeerAddrs {
+		opts.PeerAddrLimit = MaxPeerAddrs
+	}
+	if opts.RPCWorkers < 1 {
+		opts.RPCWorkers = 1
+	}
 	if opts.RPCDecodeFunc == nil {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
@@ -56,24 +343,68 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		opts.Logger = log.With(opts.Logger, "addr", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	if opts.PrivateKey != nil {
+		opts.PrivateKeys = append([]crypto.PrivateKey{*opts.PrivateKey}, opts.PrivateKeys...)
+	}
+	if opts.Genesis == nil {
+		opts.Genesis = &core.Genesis{}
+	}
+
+	genesis, err := opts.Genesis.Block()
+	if err != nil {
+		return nil, err
+	}
+
+	var store core.Storage = core.NewMemorystore()
+	if opts.PruneDepth > 0 {
+		store = core.NewPruningStore(opts.PruneDepth)
+	}
+
+	if opts.BlockHasher == nil {
+		opts.BlockHasher = core.BlockHasher{}
+	}
+	if opts.TxHasher == nil {
+		opts.TxHasher = core.TxHasher{}
+	}
+
+	chain, err := core.NewBlockchainWithStorage(opts.Logger, store, genesis,
+		core.WithBlockHasher(opts.BlockHasher),
+		core.WithTxHasher(opts.TxHasher),
+	)
 	if err != nil {
 		return nil, err
 	}
+	// Without a window the chain would keep every full block in memory.
+	chain.SetHeaderWindow(opts.PruneDepth)
+	chain.SetBlockReward(opts.BlockReward)
+	chain.SetMaxReorgDepth(opts.MaxReorgDepth)
+	chain.SetValidators(opts.Validators)
+	chain.SetCheckpoints(opts.Checkpoints)
 
 	peerCh := make(chan *TCPPeer)
-	tr := NewTCPTransport(opts.ListenAddr, peerCh)
+	if opts.TCPOptions == nil {
+		tcpOpts := DefaultTCPOptions()
+		opts.TCPOptions = &tcpOpts
+	}
+	tr := NewTCPTransportWithOptions(opts.ListenAddr, peerCh, *opts.TCPOptions)
 
 	s := &Server{
-		TCPTransport: tr,
-		peerCh:       peerCh,
-		peerMap:      make(map[net.Addr]*TCPPeer),
-		ServerOpts:   opts,
-		chain:        chain,
-		mempool:      NewTxPool(1000),
-		isValidator:  opts.PrivateKey != nil,
-		rpcCh:        make(chan RPC),
-		quitCh:       make(chan struct{}, 1),
+		TCPTransport:    tr,
+		peerCh:          peerCh,
+		peerMap:         make(map[net.Addr]*TCPPeer),
+		peerCodecs:      make(map[string]Codec),
+		peerCompression: make(map[string]bool),
+		validationSlots: newValidationSlots(opts.MaxBlockValidations),
+		ServerOpts:      opts,
+		chain:           chain,
+		mempool:         NewTxPoolWithHasher(1000, opts.TxHasher),
+		seenBlocks:      newSeenCache(seenBlocksSize),
+		peerScores:      newPeerScores(),
+		requests:        newRequestTracker(opts.RequestTimeout),
+		isValidator:     len(opts.PrivateKeys) > 0 && !opts.Observer,
+		rpcCh:           make(chan RPC),
+		gossipCh:        make(chan types.Hash, opts.GossipQueueSize),
+		quitCh:          make(chan struct{}),
 	}
 
 	s.TCPTransport.peerCh = peerCh
@@ -84,6 +415,34 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		s.RPCProcessor = s
 	}
 
+	chain.OnReorg(s.reinjectTransactions)
+
+	if len(s.WSListenAddr) > 0 {
+		s.ws = newWSServer(s.Logger, s.BlockHasher, s.TxHasher)
+		chain.OnNewBlock(s.ws.PublishBlock)
+
+		mux := http.NewServeMux()
+		mux.Handle("/ws", s.ws)
+		mux.HandleFunc("/peers", s.servePeers)
+		s.wsHTTP = &http.Server{
+			Addr:    s.WSListenAddr,
+			Handler: mux,
+		}
+
+		go s.wsTxLoop()
+	}
+
+	go s.gossipLoop()
+
+	if s.MempoolSampleInterval > 0 {
+		s.mempoolSamples = newSampleRing(s.MempoolSamples)
+		go s.mempoolSampleLoop()
+	}
+
+	if s.PingInterval > 0 {
+		go s.pingLoop()
+	}
+
 	if s.isValidator {
 		go s.validatorLoop()
 	}
@@ -93,75 +452,476 @@ func NewServer(opts ServerOpts) (*Server, error) {
 
 func (s *Server) bootstrapNetwork() {
 	for _, addr := range s.SeedNodes {
-		fmt.Println("trying to connect to ", addr)
+		go s.dialWithRetry(addr, s.SeedRetry)
+	}
 
-		go func(addr string) {
-			conn, err := net.Dial("tcp", addr)
-			if err != nil {
-				fmt.Printf("could not connect to %+v\n", conn)
-				return
-			}
+	if len(s.DNSSeeds) > 0 {
+		go s.dialDNSSeeds()
+	}
+}
 
-			s.peerCh <- &TCPPeer{
-				conn: conn,
+// dialWithRetry connects to the peer at the given address, failed attempts
+// are retried with exponential backoff according to the policy.
+func (s *Server) dialWithRetry(addr string, policy RetryPolicy) {
+	backoff := policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		conn, err := s.TCPTransport.Dial(addr)
+		if err == nil {
+			select {
+			case s.peerCh <- &TCPPeer{conn: conn, Outgoing: true, dialAddr: addr}:
+			case <-s.quitCh:
+				conn.Close()
 			}
-		}(addr)
+			return
+		}
+
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			s.Logger.Log("msg", "giving up connecting to peer", "addr", addr, "attempts", attempt, "err", err)
+			return
+		}
+
+		s.Logger.Log("msg", "could not connect to peer", "addr", addr, "retry", backoff, "err", err)
+
+		select {
+		case <-s.Clock.After(backoff):
+		case <-s.quitCh:
+			return
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
 	}
 }
 
 func (s *Server) Start() {
-	s.TCPTransport.Start()
+	s.initTransports()
+
+	// A server running only on the given transports has no TCP address to listen on.
+	if len(s.ListenAddr) > 0 {
+		s.TCPTransport.Start()
+
+		time.Sleep(time.Second * 1)
 
-	time.Sleep(time.Second * 1)
+		s.bootstrapNetwork()
+
+		s.Logger.Log("msg", "accepting TCP connection on", "addr", s.ListenAddr, "id", s.ID)
+	}
+
+	if s.wsHTTP != nil {
+		go func() {
+			if err := s.wsHTTP.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.Logger.Log("msg", "websocket server failed", "err", err)
+			}
+		}()
+	}
 
-	s.bootstrapNetwork()
+	workers := s.startRPCWorkers()
 
-	s.Logger.Log("msg", "accepting TCP connection on", "addr", s.ListenAddr, "id", s.ID)
+	go s.requestPeers()
 
 free:
 	for {
 		select {
 		case peer := <-s.peerCh:
-			s.peerMap[peer.conn.RemoteAddr()] = peer
+			if err := s.addPeer(peer); err != nil {
+				s.Logger.Log("msg", "peer rejected", "addr", peer.conn.RemoteAddr(), "err", err)
+				peer.conn.Close()
+				continue
+			}
 
-			go peer.readLoop(s.rpcCh)
+			go s.readPeer(peer)
 
 			if err := s.sendGetStatusMessage(peer); err != nil {
 				s.Logger.Log("err", err)
 				continue
 			}
 
+			if err := s.sendGetPeersMessage(peer.conn.RemoteAddr()); err != nil {
+				s.Logger.Log("err", err)
+			}
+
 			s.Logger.Log("msg", "peer added to the server", "outgoing", peer.Outgoing, "addr", peer.conn.RemoteAddr())
 
 		case rpc := <-s.rpcCh:
-			msg, err := s.RPCDecodeFunc(rpc)
-			if err != nil {
-				s.Logger.Log("error", err)
-				continue
-			}
+			s.dispatchRPC(workers, rpc)
+
+		case <-s.quitCh:
+			break free
+		}
+	}
+
+	s.Logger.Log("msg", "Server is shutting down")
+}
+
+// readPeer reads the messages of the TCP peer until its connection drops,
+// then the peer is removed.
+func (s *Server) readPeer(peer *TCPPeer) {
+	err := peer.readLoop(s.rpcCh)
+	s.dropPeer(peer, err)
+}
+
+// dropPeer removes the TCP peer after its connection failed. A peer we
+// dialed is dialed again with the ReconnectRetry policy, unless the server
+// is stopping or the peer is banned. The handshake runs again once the new
+// connection is added.
+func (s *Server) dropPeer(peer *TCPPeer, err error) {
+	addr := peer.conn.RemoteAddr()
+	peer.conn.Close()
+
+	s.mu.Lock()
+	if s.peerMap[addr] == peer {
+		delete(s.peerMap, addr)
+		delete(s.peerCodecs, addr.String())
+		delete(s.peerCompression, addr.String())
+	}
+	s.mu.Unlock()
+
+	s.peerScores.Disconnected(addr, s.Clock.Now())
+
+	select {
+	case <-s.quitCh:
+		return
+	default:
+	}
+
+	if !peer.Outgoing || len(peer.dialAddr) == 0 || s.peerScores.IsBanned(addr) {
+		s.Logger.Log("msg", "peer disconnected", "addr", addr, "err", err)
+		return
+	}
+
+	s.Logger.Log("msg", "reconnecting to peer", "addr", peer.dialAddr, "err", err)
+	s.dialWithRetry(peer.dialAddr, s.ReconnectRetry)
+}
+
+// addPeer adds the TCP peer if there is a free slot for it, peers that
+// connected to us can't take the slots reserved for outbound connections.
+// Peers from a banned host are refused.
+func (s *Server) addPeer(peer *TCPPeer) error {
+	addr := peer.conn.RemoteAddr()
+	if s.peerScores.IsBanned(addr) {
+		return fmt.Errorf("peer (%s) is banned", addr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.MaxPeers > 0 {
+		inbound, outbound := s.countPeers()
+		if inbound+outbound >= s.MaxPeers {
+			return fmt.Errorf("%w: (%d) peers => maximum (%d)", ErrPeerSlotsFull, inbound+outbound, s.MaxPeers)
+		}
+
+		if !peer.Outgoing && inbound >= s.MaxPeers-s.OutboundSlots {
+			return fmt.Errorf("%w: (%d) inbound peers => maximum (%d)", ErrPeerSlotsFull, inbound, s.MaxPeers-s.OutboundSlots)
+		}
+	}
+
+	s.peerMap[addr] = peer
+	s.peerScores.Connected(addr)
+
+	return nil
+}
+
+// countPeers returns the number of TCP peers that connected to us and the
+// number of peers we dialed, the caller has to hold the lock.
+func (s *Server) countPeers() (inbound, outbound int) {
+	for _, peer := range s.peerMap {
+		if peer.Outgoing {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+
+	return inbound, outbound
+}
+
+// startRPCWorkers starts the goroutines processing incoming messages when
+// more than one worker is configured, with a single worker the messages are
+// processed by the server loop itself.
+func (s *Server) startRPCWorkers() []chan RPC {
+	if s.RPCWorkers == 1 {
+		return nil
+	}
+
+	// relaxedCh takes the decoded messages that don't require ordering,
+	// it is only used with RelaxedOrdering.
+	var relaxedCh chan decodedRPC
+	if s.RelaxedOrdering {
+		relaxedCh = make(chan decodedRPC, 64)
+		for i := 0; i < s.RPCWorkers; i++ {
+			go func() {
+				for {
+					select {
+					case rpc := <-relaxedCh:
+						s.processRPC(rpc.from, rpc.msg)
+					case <-s.quitCh:
+						return
+					}
+				}
+			}()
+		}
+	}
 
-			if err := s.RPCProcessor.ProcessMessage(msg); err != nil {
-				if err != core.ErrBlockKnown {
-					s.Logger.Log("error", err)
+	workers := make([]chan RPC, s.RPCWorkers)
+	for i := range workers {
+		workers[i] = make(chan RPC, 64)
+
+		go func(rpcCh chan RPC) {
+			for {
+				select {
+				case rpc := <-rpcCh:
+					msg, ok := s.decodeRPC(rpc)
+					if !ok {
+						continue
+					}
+
+					if relaxedCh == nil || requiresOrdering(msg.Data) {
+						s.processRPC(rpc.From, msg)
+						continue
+					}
+
+					select {
+					case relaxedCh <- decodedRPC{from: rpc.From, msg: msg}:
+					case <-s.quitCh:
+						return
+					}
+				case <-s.quitCh:
+					return
 				}
 			}
+		}(workers[i])
+	}
+
+	return workers
+}
+
+// dispatchRPC hands the message to the worker of the peer it came from, so
+// the messages of a peer keep their order. The worker decodes the message,
+// with RelaxedOrdering it decides whether the message keeps its order.
+func (s *Server) dispatchRPC(workers []chan RPC, rpc RPC) {
+	if len(workers) == 0 {
+		s.handleRPC(rpc)
+		return
+	}
+
+	h := fnv.New32a()
+	if rpc.From != nil {
+		h.Write([]byte(rpc.From.String()))
+	}
+	worker := h.Sum32() % uint32(len(workers))
+
+	select {
+	case workers[worker] <- rpc:
+	case <-s.quitCh:
+	}
+}
+
+func (s *Server) handleRPC(rpc RPC) {
+	if msg, ok := s.decodeRPC(rpc); ok {
+		s.processRPC(rpc.From, msg)
+	}
+}
+
+// decodedRPC is a decoded message with the peer it came from.
+type decodedRPC struct {
+	from net.Addr
+	msg  *DecodedMessage
+}
+
+// decodeRPC decodes the message, a message that can't be decoded counts
+// against the peer and is dropped.
+func (s *Server) decodeRPC(rpc RPC) (*DecodedMessage, bool) {
+	if s.peerScores.IsBanned(rpc.From) {
+		return nil, false
+	}
+
+	if s.NetworkMagic != 0 {
+		frame, err := io.ReadAll(rpc.Payload)
+		if err != nil {
+			s.Logger.Log("error", err)
+			return nil, false
+		}
+
+		if frame, err = stripMagic(s.NetworkMagic, frame); err != nil {
+			s.Logger.Log("msg", "dropping message", "from", rpc.From, "err", err)
+			s.messageResult(rpc.From, false)
+			return nil, false
+		}
+
+		rpc.Payload = bytes.NewReader(frame)
+	}
+
+	msg, err := s.RPCDecodeFunc(rpc)
+	if err != nil {
+		s.Logger.Log("error", err)
+		s.messageResult(rpc.From, false)
+		return nil, false
+	}
+
+	// A custom decoder may return nothing for messages it doesn't handle.
+	if msg == nil {
+		s.Logger.Log("msg", "decoder returned no message", "from", rpc.From)
+		return nil, false
+	}
+
+	return msg, true
+}
+
+// processRPC processes a decoded message and scores the peer it came from.
+func (s *Server) processRPC(from net.Addr, msg *DecodedMessage) {
+	if err := s.RPCProcessor.ProcessMessage(msg); err != nil {
+		// Peers relay what they receive, duplicates are expected.
+		if errors.Is(err, core.ErrBlockKnown) || errors.Is(err, ErrTxKnown) {
+			atomic.AddUint64(&s.knownMessages, 1)
+			return
+		}
+
+		// The peer did nothing wrong when we are too busy for its block.
+		if errors.Is(err, ErrValidationSlotsFull) {
+			s.Logger.Log("msg", "dropping block", "from", from, "err", err)
+			return
+		}
+
+		s.Logger.Log("error", err)
+		// Messages rejected by our own policy cost the peer nothing.
+		if isInvalidData(err) {
+			s.messageResult(from, false)
+		}
+		return
+	}
+
+	s.messageResult(from, true)
+}
+
+// messageResult updates the score of the peer, a peer that gets banned is
+// disconnected.
+func (s *Server) messageResult(from net.Addr, valid bool) {
+	if !s.peerScores.MessageResult(from, valid) {
+		return
+	}
+
+	s.Logger.Log("msg", "banned peer", "addr", from)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The ban covers the host, its other connections are dropped as well.
+	host := peerHost(from)
+	for addr, peer := range s.peerMap {
+		if peerHost(addr) != host {
+			continue
+		}
 
+		peer.conn.Close()
+		delete(s.peerMap, addr)
+		delete(s.peerCodecs, addr.String())
+		delete(s.peerCompression, addr.String())
+	}
+	delete(s.peerCodecs, from.String())
+	delete(s.peerCompression, from.String())
+}
+
+// Stop stops the server loop and the validator loop.
+func (s *Server) Stop() {
+	close(s.quitCh)
+
+	if s.ws != nil {
+		s.wsHTTP.Close()
+		s.ws.Close()
+	}
+}
+
+// wsTxLoop pushes the transactions entering the mempool to the websocket
+// clients.
+func (s *Server) wsTxLoop() {
+	txs, cancel := s.mempool.Subscribe(wsTxBuffer)
+	defer cancel()
+
+	for {
+		select {
+		case tx := <-txs:
+			s.ws.PublishTx(tx)
 		case <-s.quitCh:
-			break free
+			return
 		}
 	}
+}
 
-	s.Logger.Log("msg", "Server is shutting down")
+// Chain returns the blockchain of the server.
+func (s *Server) Chain() *core.Blockchain {
+	return s.chain
+}
+
+// Mempool returns the pool of transactions waiting to be included in a block.
+func (s *Server) Mempool() *TxPool {
+	return s.mempool
+}
+
+// SubmitTransaction adds a transaction created locally, by an embedded
+// wallet or an API, to the mempool. It is checked and announced to the
+// peers the same way as a transaction received from the network.
+func (s *Server) SubmitTransaction(tx *core.Transaction) error {
+	return s.processTransaction(tx)
+}
+
+func (s *Server) initTransports() {
+	for _, tr := range s.Transports {
+		go func(tr Transport) {
+			for {
+				select {
+				case rpc := <-tr.Consume():
+					select {
+					case s.rpcCh <- rpc:
+					case <-s.quitCh:
+						return
+					}
+				case <-s.quitCh:
+					return
+				}
+			}
+		}(tr)
+	}
 }
 
 func (s *Server) validatorLoop() {
-	ticker := time.NewTicker(s.BlockTime)
+	ticker := s.Clock.NewTicker(s.BlockTime)
+	defer ticker.Stop()
 
 	s.Logger.Log("msg", "Starting validator loop", "blockTime", s.BlockTime)
 
 	for {
-		<-ticker.C
-		s.createNewBlock()
+		select {
+		case <-ticker.C():
+			if !s.shouldProduceBlock() {
+				continue
+			}
+			s.createNewBlock()
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// mempoolSampleLoop records the number of pending transactions every
+// MempoolSampleInterval.
+func (s *Server) mempoolSampleLoop() {
+	ticker := s.Clock.NewTicker(s.MempoolSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			s.mempoolSamples.Add(MempoolSample{
+				Time:    now,
+				Pending: s.mempool.PendingCount(),
+			})
+		case <-s.quitCh:
+			return
+		}
 	}
 }
 
@@ -169,8 +929,18 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	switch t := msg.Data.(type) {
 	case *core.Transaction:
 		return s.processTransaction(t)
+	case []*core.Transaction:
+		return s.processTxBatch(t)
 	case *core.Block:
-		return s.processBlock(t)
+		return s.processBlock(msg.From, t)
+	case *InvMessage:
+		return s.processInvMessage(msg.From, t)
+	case *GetDataMessage:
+		return s.processGetDataMessage(msg.From, t)
+	case *GetPeersMessage:
+		return s.processGetPeersMessage(msg.From, t)
+	case *PeersMessage:
+		return s.processPeersMessage(msg.From, t)
 	case *GetStatusMessage:
 		return s.processGetStatusMessage(msg.From, t)
 	case *StatusMessage:
@@ -179,6 +949,14 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 		return s.processGetBlocksMessage(msg.From, t)
 	case *BlocksMessage:
 		return s.processBlocksMessage(msg.From, t)
+	case *GetTxMessage:
+		return s.processGetTxMessage(msg.From, t)
+	case *TxMessage:
+		return s.processTxMessage(msg.From, t)
+	case *PingMessage:
+		return s.processPingMessage(msg.From, t)
+	case *PongMessage:
+		return s.processPongMessage(msg.From, t)
 	}
 
 	return nil
@@ -188,42 +966,44 @@ func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage)
 	s.Logger.Log("msg", "received getBlocks message", "from", from)
 
 	var (
-		blocks    = []*core.Block{}
-		ourHeight = s.chain.Height()
+		blocks = []*core.Block{}
+		start  = data.From
+		end    = s.chain.Height()
 	)
 
-	if data.To == 0 {
-		for i := 0; i < int(ourHeight); i++ {
-			block, err := s.chain.GetBlock(uint32(i))
-			if err != nil {
-				return err
-			}
-
-			blocks = append(blocks, block)
-		}
+	if len(data.Locator) > 0 {
+		start = s.chain.CommonAncestor(data.Locator) + 1
 	}
+	if data.To != 0 && data.To < end {
+		end = data.To
+	}
+
+	blocksMsg := &BlocksMessage{RequestID: data.RequestID}
 
-	fmt.Printf("%+v\n", blocks[0].Header)
+	for i := start; i <= end; i++ {
+		block, err := s.chain.GetBlock(i)
+		if errors.Is(err, core.ErrBlockPruned) {
+			blocks = nil
+			blocksMsg.Unavailable = true
+			break
+		}
+		if err != nil {
+			return err
+		}
 
-	blocksMsg := &BlocksMessage{
-		Blocks: blocks,
+		blocks = append(blocks, block)
 	}
 
+	blocksMsg.Blocks = blocks
+
 	buf := new(bytes.Buffer)
 	if err := gob.NewEncoder(buf).Encode(blocksMsg); err != nil {
 		return err
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	msg := NewMessage(MessageTypeBlocks, buf.Bytes())
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
-	}
 
-	return peer.Send(msg.Bytes())
+	return s.sendMessage(from, msg.Bytes())
 }
 
 func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
@@ -236,71 +1016,392 @@ func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
 	}
 
 	msg := NewMessage(MessageTypeGetStatus, buf.Bytes())
-	return peer.Send(msg.Bytes())
+	return peer.Send(s.frame(msg.Bytes()))
 }
 
-func (s *Server) broadcast(payload []byte) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for netAddr, peer := range s.peerMap {
-		if err := peer.Send(payload); err != nil {
-			fmt.Printf("peer send error => addr %s [err: %s]\n", netAddr, err)
-		}
-	}
+// sendMessage sends the payload to the peer with the given address, either
+// over its TCP connection or through one of the transports.
+func (s *Server) sendMessage(to net.Addr, payload []byte) error {
+	payload = s.frame(s.compress(to, payload))
 
-	return nil
-}
+	s.mu.RLock()
+	peer, ok := s.peerMap[to]
+	s.mu.RUnlock()
 
-func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error {
-	s.Logger.Log("msg", "received BLOCKS!!!!!!!!", "from", from)
+	if ok {
+		return peer.Send(payload)
+	}
 
-	for _, block := range data.Blocks {
-		fmt.Printf("BlOCK with %+v\n", block.Header)
-		if err := s.chain.AddBlock(block); err != nil {
-			return err
+	for _, tr := range s.Transports {
+		if err := tr.SendMessage(to, payload); err == nil {
+			return nil
 		}
 	}
 
-	return nil
+	return fmt.Errorf("peer %s not known", to)
 }
 
-func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
-	s.Logger.Log("msg", "received STATUS message", "from", from)
+// peerCodec returns the codec agreed on with the peer, gob until the peers
+// exchanged their status.
+func (s *Server) peerCodec(addr net.Addr) Codec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if data.CurrentHeight <= s.chain.Height() {
-		s.Logger.Log("msg", "cannot sync blockHeight to low", "ourHeight", s.chain.Height(), "theirHeight", data.CurrentHeight, "addr", from)
-		return nil
+	if codec, ok := s.peerCodecs[addr.String()]; ok {
+		return codec
 	}
 
-	// In this case we are 100% sure that the node has blocks heigher than us.
-	getBlocksMessage := &GetBlocksMessage{
-		From: s.chain.Height(),
-		To:   0,
-	}
+	return CodecGob
+}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
-		return err
+// compress returns the payload compressed if the peer agreed on compression
+// and the payload is large enough. The payload is sent as is when
+// compressing doesn't make it smaller.
+func (s *Server) compress(to net.Addr, payload []byte) []byte {
+	if len(payload) < s.CompressionThreshold {
+		return payload
 	}
 
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	ok := s.peerCompression[to.String()]
+	s.mu.RUnlock()
 
-	msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
-	peer, ok := s.peerMap[from]
 	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
+		return payload
 	}
 
-	return peer.Send(msg.Bytes())
+	compressed, err := compressFrame(payload)
+	if err != nil {
+		s.Logger.Log("msg", "failed to compress message", "to", to, "err", err)
+		return payload
+	}
+
+	if len(compressed) >= len(payload) {
+		return payload
+	}
+
+	return compressed
+}
+
+// frame prefixes the payload with the network magic, if one is set.
+func (s *Server) frame(payload []byte) []byte {
+	if s.NetworkMagic == 0 {
+		return payload
+	}
+
+	return withMagic(s.NetworkMagic, payload)
+}
+
+// peers returns the addresses of all peers, connected over TCP or through
+// one of the transports.
+func (s *Server) peers() []net.Addr {
+	s.mu.RLock()
+	addrs := make([]net.Addr, 0, len(s.peerMap))
+	for addr := range s.peerMap {
+		addrs = append(addrs, addr)
+	}
+	s.mu.RUnlock()
+
+	for _, tr := range s.Transports {
+		addrs = append(addrs, tr.Peers()...)
+	}
+
+	return addrs
+}
+
+// dialablePeers returns the addresses of the peers others can connect to,
+// TCP peers that connected to us are left out as their address is not the
+// one they listen on.
+func (s *Server) dialablePeers() []net.Addr {
+	s.mu.RLock()
+	addrs := []net.Addr{}
+	for addr, peer := range s.peerMap {
+		if peer.Outgoing {
+			addrs = append(addrs, addr)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, tr := range s.Transports {
+		addrs = append(addrs, tr.Peers()...)
+	}
+
+	return addrs
+}
+
+// BroadcastErrors collects the peers a broadcast could not reach, the
+// message was still sent to every other peer.
+type BroadcastErrors []error
+
+func (errs BroadcastErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("broadcast failed for (%d) peers: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the errors matches the target.
+func (errs BroadcastErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// broadcast sends the payload to all peers that are not banned, or to a
+// random subset of them with BroadcastFanout, the peers with the highest
+// score first. A failing peer doesn't stop the broadcast, the failures are
+// returned together as BroadcastErrors.
+func (s *Server) broadcast(payload []byte) error {
+	peers := s.peers()
+	if n := s.fanout(len(peers)); n < len(peers) {
+		rand.Shuffle(len(peers), func(i, j int) {
+			peers[i], peers[j] = peers[j], peers[i]
+		})
+		peers = peers[:n]
+	}
+
+	var errs BroadcastErrors
+	for _, addr := range s.peerScores.Rank(peers) {
+		err := s.sendMessage(addr, payload)
+		s.peerScores.SendResult(addr, err)
+		if err != nil {
+			s.Logger.Log("msg", "peer send error", "addr", addr, "err", err)
+			errs = append(errs, fmt.Errorf("peer (%s) => %w", addr, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// fanout returns the number of the given peers a broadcast is sent to.
+func (s *Server) fanout(peers int) int {
+	n := s.BroadcastFanout
+	switch {
+	case n == 0:
+		return peers
+	case n == FanoutSqrt:
+		n = int(math.Ceil(math.Sqrt(float64(peers))))
+	}
+
+	if n < minBroadcastFanout {
+		n = minBroadcastFanout
+	}
+	if n > peers {
+		n = peers
+	}
+
+	return n
+}
+
+func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error {
+	s.Logger.Log("msg", "received BLOCKS!!!!!!!!", "from", from)
+
+	if !s.requests.Close(data.RequestID, from, MessageTypeBlocks, s.Clock.Now()) {
+		s.Logger.Log("msg", "discarding unrequested blocks", "from", from, "requestID", data.RequestID)
+		return nil
+	}
+
+	if data.Unavailable {
+		s.Logger.Log("msg", "peer pruned the requested blocks", "from", from)
+		return nil
+	}
+
+	for _, block := range data.Blocks {
+		fmt.Printf("BlOCK with %+v\n", block.Header)
+		// A locator can miss the fork point, blocks below it are known.
+		if err := s.chain.AddBlockFrom(block, from.String()); err != nil && !errors.Is(err, core.ErrBlockKnown) {
+			return blockError(err)
+		}
+	}
+
+	return nil
+}
+
+// requestPeers asks all peers for the addresses of their peers, the peers
+// with the highest score first.
+func (s *Server) requestPeers() {
+	for _, addr := range s.peerScores.Rank(s.peers()) {
+		err := s.sendGetPeersMessage(addr)
+		s.peerScores.SendResult(addr, err)
+		if err != nil {
+			s.Logger.Log("err", err)
+		}
+	}
+}
+
+func (s *Server) sendGetPeersMessage(to net.Addr) error {
+	getPeers := &GetPeersMessage{
+		RequestID: s.requests.Open(to, MessageTypePeers, s.Clock.Now()),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(getPeers); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeGetPeers, buf.Bytes())
+
+	return s.sendMessage(to, msg.Bytes())
+}
+
+// processGetPeersMessage replies with the addresses of the best scored
+// peers, leaving out the peer asking.
+func (s *Server) processGetPeersMessage(from net.Addr, data *GetPeersMessage) error {
+	addrs := []string{}
+	for _, addr := range s.peerScores.Rank(s.dialablePeers()) {
+		if len(addrs) >= s.PeerAddrLimit {
+			break
+		}
+		if addr.String() == from.String() {
+			continue
+		}
+
+		addrs = append(addrs, addr.String())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&PeersMessage{RequestID: data.RequestID, Addrs: addrs}); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypePeers, buf.Bytes())
+
+	return s.sendMessage(from, msg.Bytes())
+}
+
+// processPeersMessage connects to the addresses we are not connected to yet,
+// at most PeerAddrLimit of them. Addresses we didn't ask for are discarded.
+func (s *Server) processPeersMessage(from net.Addr, data *PeersMessage) error {
+	if !s.requests.Close(data.RequestID, from, MessageTypePeers, s.Clock.Now()) {
+		s.Logger.Log("msg", "discarding unrequested peers", "from", from, "requestID", data.RequestID)
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, addr := range s.peers() {
+		known[addr.String()] = true
+	}
+	for _, tr := range s.Transports {
+		known[tr.Addr().String()] = true
+	}
+	if len(s.ListenAddr) > 0 {
+		known[s.ListenAddr] = true
+	}
+
+	dialed := 0
+	for _, addr := range data.Addrs {
+		if dialed >= s.PeerAddrLimit {
+			break
+		}
+		if known[addr] {
+			continue
+		}
+		known[addr] = true
+		dialed++
+
+		s.Logger.Log("msg", "dialing discovered peer", "addr", addr, "from", from)
+		s.dialPeer(addr)
+	}
+
+	return nil
+}
+
+// dialPeer connects to the peer with the given address through the first
+// transport able to reach it, falling back to TCP.
+func (s *Server) dialPeer(addr string) {
+	for _, tr := range s.Transports {
+		dialer, ok := tr.(Dialer)
+		if !ok {
+			continue
+		}
+
+		if err := dialer.Dial(addr); err != nil {
+			continue
+		}
+
+		if err := s.sendGetPeersMessage(NetAddr(addr)); err != nil {
+			s.Logger.Log("err", err)
+		}
+		return
+	}
+
+	if len(s.ListenAddr) > 0 {
+		go s.dialWithRetry(addr, RetryPolicy{
+			InitialBackoff: s.SeedRetry.InitialBackoff,
+			MaxBackoff:     s.SeedRetry.MaxBackoff,
+			MaxAttempts:    1,
+		})
+	}
+}
+
+func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
+	s.Logger.Log("msg", "received STATUS message", "from", from)
+
+	genesisHash, err := s.genesisHash()
+	if err != nil {
+		return err
+	}
+
+	if data.GenesisHash != genesisHash {
+		return invalidData(fmt.Errorf("peer %s has a different genesis (%s) => our genesis (%s)", from, data.GenesisHash, genesisHash))
+	}
+
+	codec := negotiateCodec(s.Codecs, data.Codecs)
+	compression := s.Compression && data.Compression
+	s.mu.Lock()
+	s.peerCodecs[from.String()] = codec
+	s.peerCompression[from.String()] = compression
+	s.mu.Unlock()
+
+	s.Logger.Log("msg", "negotiated codec", "addr", from, "codec", codec, "compression", compression)
+
+	if data.CurrentHeight <= s.chain.Height() {
+		s.Logger.Log("msg", "cannot sync blockHeight to low", "ourHeight", s.chain.Height(), "theirHeight", data.CurrentHeight, "addr", from)
+		return nil
+	}
+
+	// In this case we are 100% sure that the node has blocks heigher than us.
+	getBlocksMessage := &GetBlocksMessage{
+		From:      s.chain.Height(),
+		To:        0,
+		Locator:   s.chain.BlockLocator(),
+		RequestID: s.requests.Open(from, MessageTypeBlocks, s.Clock.Now()),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
+
+	return s.sendMessage(from, msg.Bytes())
 }
 
 func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage) error {
 	s.Logger.Log("msg", "received getStatus message", "from", from)
 
+	genesisHash, err := s.genesisHash()
+	if err != nil {
+		return err
+	}
+
 	statusMessage := &StatusMessage{
 		CurrentHeight: s.chain.Height(),
 		ID:            s.ID,
+		GenesisHash:   genesisHash,
+		Codecs:        s.Codecs,
+		Compression:   s.Compression,
 	}
 
 	buf := new(bytes.Buffer)
@@ -308,38 +1409,125 @@ func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage)
 		return err
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	msg := NewMessage(MessageTypeStatus, buf.Bytes())
 
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
+	return s.sendMessage(from, msg.Bytes())
+}
+
+func (s *Server) processBlock(from net.Addr, b *core.Block) error {
+	if b.Header == nil {
+		return invalidData(core.ErrNilHeader)
 	}
 
-	msg := NewMessage(MessageTypeStatus, buf.Bytes())
+	hash := b.Hash(s.BlockHasher)
 
-	return peer.Send(msg.Bytes())
+	// A block dropped for lack of a slot is accepted when it arrives again.
+	if !s.acquireValidationSlot() {
+		return fmt.Errorf("%w: block (%s)", ErrValidationSlotsFull, hash)
+	}
+	defer s.releaseValidationSlot()
+
+	// In a mesh the same block arrives from several peers, only the first
+	// one is validated and relayed.
+	if s.seenBlocks.Contains(hash) {
+		return core.ErrBlockKnown
+	}
+
+	// The hash only covers the header, a copy with a bad signature or body
+	// has the hash of the genuine block. Only blocks on our chain are marked
+	// as seen, so a rejected copy doesn't keep the genuine block out and a
+	// block with an unknown parent is retried.
+	if err := s.chain.AddBlockFrom(b, from.String()); err != nil {
+		if errors.Is(err, core.ErrBlockKnown) {
+			s.seenBlocks.Add(hash)
+			return err
+		}
+		return blockError(err)
+	}
+	s.seenBlocks.Add(hash)
+
+	if !s.Observer {
+		go s.broadcastBlock(b)
+	}
+
+	return nil
 }
 
-func (s *Server) processBlock(b *core.Block) error {
-	if err := s.chain.AddBlock(b); err != nil {
+// blockError marks the error of a rejected block as invalid data, unless the
+// block only arrived before its parent or forks off deeper than we follow.
+func blockError(err error) error {
+	if errors.Is(err, core.ErrBlockOutOfOrder) || errors.Is(err, core.ErrReorgTooDeep) {
 		return err
 	}
 
-	go s.broadcastBlock(b)
+	return invalidData(err)
+}
 
-	return nil
+func newValidationSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(chan struct{}, n)
+}
+
+// acquireValidationSlot takes one of the block validation slots, it returns
+// false if all of them are taken.
+func (s *Server) acquireValidationSlot() bool {
+	if s.validationSlots == nil {
+		return true
+	}
+
+	select {
+	case s.validationSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseValidationSlot() {
+	if s.validationSlots != nil {
+		<-s.validationSlots
+	}
 }
 
 func (s *Server) processTransaction(tx *core.Transaction) error {
-	hash := tx.Hash(core.TxHasher{})
+	hash := tx.Hash(s.TxHasher)
 
-	if s.mempool.Contains(hash) {
-		return nil
+	if s.mempool.ContainsTx(tx) {
+		return fmt.Errorf("%w: (%s)", ErrTxKnown, hash)
+	}
+
+	// Checked before the signature, which hashes the whole data.
+	if len(tx.Data) > s.MaxTxDataSize {
+		return fmt.Errorf("%w: transaction (%s) carries (%d) bytes => maximum (%d)", ErrTxTooLarge, hash, len(tx.Data), s.MaxTxDataSize)
 	}
 
 	if err := tx.Verify(); err != nil {
-		return err
+		return invalidData(err)
+	}
+
+	if s.TxTimestampTolerance > 0 {
+		if err := tx.ValidateTimestamp(s.Clock.Now(), s.TxTimestampTolerance); err != nil {
+			return err
+		}
+	}
+
+	if tx.Fee < s.MinFee {
+		return fmt.Errorf("%w: transaction (%s) pays (%d) => minimum (%d)", ErrFeeTooLow, hash, tx.Fee, s.MinFee)
+	}
+
+	// A transaction the current state can't afford would fail every block
+	// including it.
+	if err := s.chain.CheckTransaction(tx); err != nil {
+		return fmt.Errorf("transaction (%s) can't be applied: %w", hash, err)
+	}
+
+	if s.TxValidator != nil {
+		if err := s.TxValidator(tx.Data); err != nil {
+			return fmt.Errorf("transaction (%s) rejected: %w", hash, err)
+		}
 	}
 
 	// s.Logger.Log(
@@ -348,13 +1536,20 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 	// 	"mempoolPending", s.mempool.PendingCount(),
 	// )
 
-	go s.broadcastTx(tx)
+	// Announced once pooled, so a getdata answering the announcement finds
+	// the transaction.
+	if err := s.mempool.Add(tx); err != nil {
+		return err
+	}
 
-	s.mempool.Add(tx)
+	if !s.Observer {
+		s.queueAnnouncement(hash)
+	}
 
 	return nil
 }
 
+// broadcastBlock sends the block to all peers in a MessageTypeBlock message.
 func (s *Server) broadcastBlock(b *core.Block) error {
 	buf := &bytes.Buffer{}
 	if err := b.Encode(core.NewGobBlockEncoder(buf)); err != nil {
@@ -366,59 +1561,307 @@ func (s *Server) broadcastBlock(b *core.Block) error {
 	return s.broadcast(msg.Bytes())
 }
 
-func (s *Server) broadcastTx(tx *core.Transaction) error {
+// reinjectTransactions returns the transactions of the blocks rolled back by
+// a reorg to the mempool, unless the new branch includes them as well.
+func (s *Server) reinjectTransactions(orphaned, added []*core.Block) {
+	included := make(map[types.Hash]struct{})
+	for _, b := range added {
+		for _, tx := range b.Transactions {
+			included[tx.Hash(s.TxHasher)] = struct{}{}
+		}
+	}
+
+	for _, b := range orphaned {
+		for _, tx := range b.Transactions {
+			if _, ok := included[tx.Hash(s.TxHasher)]; ok || tx.IsCoinbase() {
+				continue
+			}
+
+			if err := tx.Verify(); err != nil {
+				continue
+			}
+
+			s.mempool.Reinject(tx)
+		}
+	}
+}
+
+// processTxBatch processes every transaction of the batch on its own, a bad
+// transaction does not keep the others out of the mempool.
+func (s *Server) processTxBatch(txx []*core.Transaction) error {
+	var (
+		firstErr error
+		rejected int
+	)
+	for _, tx := range txx {
+		if err := s.processTransaction(tx); err != nil {
+			if errors.Is(err, ErrTxKnown) {
+				continue
+			}
+			// An invalid transaction is reported over a policy rejection,
+			// so the batch costs the peer score.
+			if firstErr == nil || (isInvalidData(err) && !isInvalidData(firstErr)) {
+				firstErr = err
+			}
+			rejected++
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("(%d) of (%d) transactions in batch rejected: %w", rejected, len(txx), firstErr)
+	}
+
+	return nil
+}
+
+// queueAnnouncement queues the transaction to be announced to the peers, the
+// announcement is dropped when the queue is full.
+func (s *Server) queueAnnouncement(hash types.Hash) {
+	select {
+	case s.gossipCh <- hash:
+	default:
+		s.Logger.Log("msg", "gossip queue full, dropping announcement", "hash", hash)
+	}
+}
+
+// gossipLoop announces the queued transactions, the transactions queued
+// while an announcement was sent go out together in the next one.
+func (s *Server) gossipLoop() {
+	for {
+		select {
+		case hash := <-s.gossipCh:
+			hashes := []types.Hash{hash}
+		drain:
+			for len(hashes) < MaxInvHashes {
+				select {
+				case hash := <-s.gossipCh:
+					hashes = append(hashes, hash)
+				default:
+					break drain
+				}
+			}
+
+			if err := s.announceTxs(hashes); err != nil {
+				s.Logger.Log("err", err)
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// announceTxs tells the peers about new transactions, they request them with
+// a getdata message if they don't have them yet.
+func (s *Server) announceTxs(hashes []types.Hash) error {
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := gob.NewEncoder(buf).Encode(&InvMessage{Hashes: hashes}); err != nil {
 		return err
 	}
 
-	msg := NewMessage(MessageTypeTx, buf.Bytes())
+	msg := NewMessage(MessageTypeInv, buf.Bytes())
 
 	return s.broadcast(msg.Bytes())
 }
 
-func (s *Server) createNewBlock() error {
-	currentHeader, err := s.chain.GetHeader(s.chain.Height())
+func (s *Server) processInvMessage(from net.Addr, data *InvMessage) error {
+	missing := []types.Hash{}
+	for _, hash := range data.Hashes {
+		if !s.mempool.Contains(hash) {
+			missing = append(missing, hash)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&GetDataMessage{Hashes: missing}); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeGetData, buf.Bytes())
+
+	return s.sendMessage(from, msg.Bytes())
+}
+
+// processGetDataMessage sends the requested transactions that are in the
+// mempool back to the peer in a single batch.
+func (s *Server) processGetDataMessage(from net.Addr, data *GetDataMessage) error {
+	txx := []*core.Transaction{}
+	for _, hash := range data.Hashes {
+		if tx := s.mempool.Get(hash); tx != nil {
+			txx = append(txx, tx)
+		}
+	}
+
+	if len(txx) == 0 {
+		return nil
+	}
+
+	msg, err := NewTxBatchMessageWithCodec(s.peerCodec(from), txx)
 	if err != nil {
 		return err
 	}
 
-	// For now we are going to use all transactions that are in the pending pool
-	// Later on when we know the internal structure of our transaction
-	// we will implement some kind of complexity function to determine how
-	// many transactions can be included in a block.
-	txx := s.mempool.Pending()
+	return s.sendMessage(from, msg.Bytes())
+}
+
+// RequestTx asks the given peer for the transaction with the given hash. A
+// transaction the peer answers with is added to the mempool unless it is
+// already confirmed on our chain.
+func (s *Server) RequestTx(to net.Addr, hash types.Hash) error {
+	getTx := &GetTxMessage{
+		RequestID: s.requests.Open(to, MessageTypeTxResponse, s.Clock.Now()),
+		Hash:      hash,
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(getTx); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeGetTx, buf.Bytes())
+
+	return s.sendMessage(to, msg.Bytes())
+}
+
+// processGetTxMessage replies with the requested transaction, looked up in
+// the mempool first and in the confirmed transactions after.
+func (s *Server) processGetTxMessage(from net.Addr, data *GetTxMessage) error {
+	reply := &TxMessage{
+		RequestID: data.RequestID,
+		Hash:      data.Hash,
+		Codec:     s.peerCodec(from),
+	}
+
+	tx := s.mempool.Get(data.Hash)
+	if tx == nil {
+		tx, _ = s.chain.GetTransaction(data.Hash)
+	}
+
+	if tx != nil {
+		encoded, err := encodeTx(reply.Codec, tx)
+		if err != nil {
+			return err
+		}
+		reply.Tx = encoded
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(reply); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeTxResponse, buf.Bytes())
+
+	return s.sendMessage(from, msg.Bytes())
+}
+
+// processTxMessage handles the answer to a RequestTx. The transaction goes
+// through the same checks as a relayed one before it enters the mempool.
+func (s *Server) processTxMessage(from net.Addr, data *TxMessage) error {
+	if !s.requests.Close(data.RequestID, from, MessageTypeTxResponse, s.Clock.Now()) {
+		s.Logger.Log("msg", "discarding unrequested transaction", "from", from, "requestID", data.RequestID)
+		return nil
+	}
+
+	if len(data.Tx) == 0 {
+		s.Logger.Log("msg", "peer doesn't know transaction", "from", from, "hash", data.Hash)
+		return nil
+	}
+
+	tx, err := decodeTx(data.Codec, data.Tx)
+	if err != nil {
+		return invalidData(err)
+	}
+
+	if hash := tx.Hash(s.TxHasher); hash != data.Hash {
+		return invalidData(fmt.Errorf("requested transaction (%s) => received (%s)", data.Hash, hash))
+	}
+
+	if _, err := s.chain.GetTransaction(data.Hash); err == nil {
+		return nil
+	}
+
+	return s.processTransaction(tx)
+}
+
+// shouldProduceBlock returns false when empty blocks are skipped, the
+// mempool is empty and no keep-alive block is due.
+func (s *Server) shouldProduceBlock() bool {
+	if !s.SkipEmptyBlocks || s.mempool.PendingCount() > 0 {
+		return true
+	}
+
+	if s.KeepAliveBlockTime == 0 {
+		return false
+	}
+
+	header := s.chain.LastHeader()
+
+	return s.Clock.Now().Sub(time.Unix(0, header.Timestamp)) >= s.KeepAliveBlockTime
+}
+
+func (s *Server) createNewBlock() error {
+	currentHeader := s.chain.LastHeader()
+
+	txx := s.mempool.Select(s.TxOrdering, s.MaxBlockSize)
+
+	// Transactions that became unaffordable since they were pooled are
+	// dropped, they would fail the block.
+	txx, failed := s.chain.FilterApplicable(txx)
+	if len(failed) > 0 {
+		s.Logger.Log("msg", "evicting unappliable transactions", "count", len(failed))
+		s.mempool.RemovePending(failed)
+	}
+	included := txx
+	privKey := s.validatorKey(currentHeader.Height + 1)
+
+	if s.BlockReward > 0 {
+		amount, err := core.CoinbaseAmount(s.BlockReward, txx)
+		if err != nil {
+			return err
+		}
+
+		coinbase := core.NewCoinbaseTransaction(privKey.PublicKey().Address(), amount)
+		txx = append([]*core.Transaction{coinbase}, txx...)
+	}
 
-	block, err := core.NewBlockFromPrevHeader(currentHeader, txx)
+	block, err := core.NewBlockFromPrevHeaderWithHasher(s.BlockHasher, currentHeader, txx)
 	if err != nil {
 		return err
 	}
+	block.Timestamp = s.Clock.Now().UnixNano()
 
-	if err := block.Sign(*s.PrivateKey); err != nil {
+	if err := block.Sign(privKey); err != nil {
 		return err
 	}
 
 	if err := s.chain.AddBlock(block); err != nil {
 		return err
 	}
+	s.seenBlocks.Add(block.Hash(s.BlockHasher))
 
 	// TODO(@ayushn2): pending pool of tx should only reflect on validator nodes.
 	// Right now "normal nodes" does not have their pending pool cleared.
-	s.mempool.ClearPending()
+	s.mempool.RemovePending(included)
 
 	go s.broadcastBlock(block)
 
 	return nil
 }
 
-func genesisBlock() *core.Block {
-	header := &core.Header{
-		Version:   1,
-		DataHash:  types.Hash{},
-		Height:    0,
-		Timestamp: 000000,
+// validatorKey returns the key designated to sign the block at the given height.
+func (s *Server) validatorKey(height uint32) crypto.PrivateKey {
+	return s.PrivateKeys[int(height)%len(s.PrivateKeys)]
+}
+
+func (s *Server) genesisHash() (types.Hash, error) {
+	header, err := s.chain.GetHeader(0)
+	if err != nil {
+		return types.Hash{}, err
 	}
 
-	b, _ := core.NewBlock(header, nil)
-	return b
+	return s.BlockHasher.Hash(header), nil
 }
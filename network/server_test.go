@@ -0,0 +1,1619 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/ayushn2/blockchainz/util"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerValidatorKeysRoundRobin(t *testing.T) {
+	keys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+
+	s, err := NewServer(ServerOpts{
+		ID:          "VALIDATOR",
+		Logger:      log.NewNopLogger(),
+		BlockTime:   time.Hour,
+		PrivateKeys: keys,
+	})
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, s.createNewBlock())
+	}
+
+	for height := uint32(1); height <= 6; height++ {
+		b, err := s.chain.GetBlock(height)
+		assert.Nil(t, err)
+		assert.Equal(t, keys[height%3].PublicKey(), b.Validator)
+	}
+}
+
+func TestServerCoinbase(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(ServerOpts{
+		ID:          "VALIDATOR",
+		Logger:      log.NewNopLogger(),
+		BlockTime:   time.Hour,
+		PrivateKey:  &privKey,
+		BlockReward: 50,
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.createNewBlock())
+	assert.Nil(t, s.createNewBlock())
+
+	balance, err := s.chain.GetBalance(privKey.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+}
+
+func TestServerTxValidator(t *testing.T) {
+	errNoMagic := errors.New("missing magic byte")
+	s, err := NewServer(ServerOpts{
+		ID:        "NODE",
+		Logger:    log.NewNopLogger(),
+		BlockTime: time.Hour,
+		TxValidator: func(data []byte) error {
+			if len(data) == 0 || data[0] != 0xff {
+				return errNoMagic
+			}
+			return nil
+		},
+	})
+	assert.Nil(t, err)
+
+	privKey := crypto.GeneratePrivateKey()
+	tx := core.NewTransaction([]byte{0x01, 0x02})
+	assert.Nil(t, tx.Sign(privKey))
+	assert.ErrorIs(t, s.processTransaction(tx), errNoMagic)
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	tx = core.NewTransaction([]byte{0xff, 0x02})
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, s.processTransaction(tx))
+	assert.Equal(t, 1, s.mempool.PendingCount())
+}
+
+func TestServerMinFee(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(ServerOpts{
+		ID:        "NODE",
+		Logger:    log.NewNopLogger(),
+		BlockTime: time.Hour,
+		MinFee:    10,
+		Genesis: &core.Genesis{
+			Alloc: map[types.Address]uint64{privKey.PublicKey().Address(): 100},
+		},
+	})
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction([]byte("cheap"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.ErrorIs(t, s.processTransaction(tx), ErrFeeTooLow)
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	tx = core.NewTransaction([]byte("paying"))
+	tx.Fee = 10
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, s.processTransaction(tx))
+	assert.Equal(t, 1, s.mempool.PendingCount())
+}
+
+func TestServerMaxTxDataSize(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:            "NODE",
+		Logger:        log.NewNopLogger(),
+		BlockTime:     time.Hour,
+		MaxTxDataSize: 16,
+	})
+	assert.Nil(t, err)
+
+	privKey := crypto.GeneratePrivateKey()
+	tx := core.NewTransaction(bytes.Repeat([]byte{1}, 17))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.ErrorIs(t, s.processTransaction(tx), ErrTxTooLarge)
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	tx = core.NewTransaction(bytes.Repeat([]byte{1}, 16))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, s.processTransaction(tx))
+	assert.Equal(t, 1, s.mempool.PendingCount())
+}
+
+func TestServerBlockPropagatesOnce(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	servers, transports := newLocalServers(t, 3, func(i int, opts *ServerOpts) {
+		if i == 0 {
+			opts.PrivateKey = &privKey
+		}
+	})
+
+	// Connect the nodes in a cycle 0 -> 1 -> 2 -> 0.
+	for i := range transports {
+		connectLocal(t, transports[i].LocalTransport, transports[(i+1)%len(transports)].LocalTransport)
+	}
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	assert.Nil(t, servers[0].createNewBlock())
+
+	assert.Eventually(t, func() bool {
+		for _, s := range servers {
+			if s.chain.Height() != 1 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Give a looping block the chance to show up.
+	time.Sleep(100 * time.Millisecond)
+
+	// Every node sends the block once to each of its two peers.
+	for _, tr := range transports {
+		assert.Equal(t, 2, tr.sendCount(MessageTypeBlock))
+	}
+}
+
+func TestServerBansMisbehavingPeer(t *testing.T) {
+	servers, transports := newLocalServers(t, 1, nil)
+	s := servers[0]
+
+	bad := NewLocalTransport(NetAddr("BAD"))
+	connectLocal(t, transports[0].LocalTransport, bad)
+
+	go s.Start()
+	defer s.Stop()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, bad.SendMessage(s.Transports[0].Addr(), []byte("garbage")))
+	}
+
+	assert.Eventually(t, func() bool {
+		return s.peerScores.IsBanned(bad.Addr())
+	}, 2*time.Second, 10*time.Millisecond)
+
+	stats := s.Stats()
+	if assert.Len(t, stats.Peers, 1) {
+		assert.True(t, stats.Peers[0].Banned)
+		assert.Equal(t, banScore, stats.Peers[0].Score)
+	}
+
+	// Nothing is sent to a banned peer anymore.
+	assert.Nil(t, s.broadcast([]byte("hello")))
+	assert.Equal(t, 0, transports[0].sendCount(MessageTypeTx))
+	assert.Empty(t, s.peerScores.Rank(s.peers()))
+}
+
+func TestServerPolicyRejectionsDontCostScore(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:        "NODE",
+		Logger:    log.NewNopLogger(),
+		BlockTime: time.Hour,
+		MinFee:    10,
+	})
+	assert.Nil(t, err)
+
+	peer := NetAddr("PEER")
+	privKey := crypto.GeneratePrivateKey()
+	for i := 0; i < 10; i++ {
+		tx := core.NewTransaction([]byte(fmt.Sprintf("cheap %d", i)))
+		assert.Nil(t, tx.Sign(privKey))
+		s.processRPC(peer, &DecodedMessage{From: peer, Data: tx})
+	}
+	assert.False(t, s.peerScores.IsBanned(peer))
+	assert.Empty(t, s.peerScores.Stats())
+
+	// A block arriving before its parent is no fault of the peer either.
+	prev := *s.chain.LastHeader()
+	prev.Height = 4
+	b, err := core.NewBlockFromPrevHeader(&prev, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+	s.processRPC(peer, &DecodedMessage{From: peer, Data: b})
+	assert.Empty(t, s.peerScores.Stats())
+
+	// A bad signature proves the transaction invalid.
+	tx := core.NewTransaction([]byte("tampered"))
+	tx.Fee = 10
+	assert.Nil(t, tx.Sign(privKey))
+	tx.Data = []byte("tampered after signing")
+	s.processRPC(peer, &DecodedMessage{From: peer, Data: tx})
+
+	stats := s.peerScores.Stats()
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, 1, stats[0].InvalidMessages)
+		assert.Equal(t, scoreInvalidMessage, stats[0].Score)
+	}
+}
+
+// countingTransport counts the messages sent per message type.
+type failingTransport struct {
+	*LocalTransport
+}
+
+func (t *failingTransport) SendMessage(to net.Addr, payload []byte) error {
+	return fmt.Errorf("%s: link to %s is down", t.addr, to)
+}
+
+func TestServerBroadcastPartialFailure(t *testing.T) {
+	var (
+		local  = make([]*LocalTransport, 3)
+		remote = make([]*LocalTransport, 3)
+	)
+	for i := range local {
+		local[i] = NewLocalTransport(NetAddr(fmt.Sprintf("LOCAL_%d", i)))
+		remote[i] = NewLocalTransport(NetAddr(fmt.Sprintf("REMOTE_%d", i)))
+		connectLocal(t, local[i], remote[i])
+	}
+
+	servers, _ := newLocalServers(t, 1, func(i int, opts *ServerOpts) {
+		opts.Transports = []Transport{local[0], &failingTransport{local[1]}, local[2]}
+	})
+	s := servers[0]
+
+	err := s.broadcast([]byte("hello"))
+
+	var errs BroadcastErrors
+	if assert.ErrorAs(t, err, &errs) {
+		assert.Len(t, errs, 1)
+		assert.Contains(t, errs.Error(), "REMOTE_1")
+	}
+
+	// The peers behind the healthy transports still got the message.
+	for _, i := range []int{0, 2} {
+		select {
+		case rpc := <-remote[i].Consume():
+			assert.Equal(t, local[i].Addr(), rpc.From)
+		case <-time.After(time.Second):
+			t.Fatalf("peer %s did not receive the broadcast", remote[i].Addr())
+		}
+	}
+	assert.Empty(t, remote[1].Consume())
+}
+
+type countingTransport struct {
+	*LocalTransport
+
+	lock  sync.Mutex
+	sends map[MessageType]int
+	// batchCodec is the codec of the last transaction batch sent.
+	batchCodec Codec
+}
+
+func (t *countingTransport) SendMessage(to net.Addr, payload []byte) error {
+	if msg, err := DecodeMessage(payload); err == nil {
+		t.lock.Lock()
+		t.sends[msg.Header]++
+		if msg.Header == MessageTypeTxBatch {
+			batch := new(TxBatchMessage)
+			if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(batch); err == nil {
+				t.batchCodec = batch.Codec
+			}
+		}
+		t.lock.Unlock()
+	}
+
+	return t.LocalTransport.SendMessage(to, payload)
+}
+
+func (t *countingTransport) sendCount(msgType MessageType) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.sends[msgType]
+}
+
+// newLocalServers creates n servers that each run on their own counting local
+// transport, configure may change the options of every server.
+func newLocalServers(t *testing.T, n int, configure func(i int, opts *ServerOpts)) ([]*Server, []*countingTransport) {
+	var (
+		servers    = make([]*Server, n)
+		transports = make([]*countingTransport, n)
+	)
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("NODE_%d", i)
+		transports[i] = &countingTransport{
+			LocalTransport: NewLocalTransport(NetAddr(id)),
+			sends:          make(map[MessageType]int),
+		}
+
+		opts := ServerOpts{
+			ID:         id,
+			Logger:     log.NewNopLogger(),
+			BlockTime:  time.Hour,
+			Transports: []Transport{transports[i]},
+		}
+		if configure != nil {
+			configure(i, &opts)
+		}
+
+		s, err := NewServer(opts)
+		assert.Nil(t, err)
+		servers[i] = s
+	}
+
+	return servers, transports
+}
+
+func connectLocal(t *testing.T, a, b *LocalTransport) {
+	assert.Nil(t, a.Connect(b))
+	assert.Nil(t, b.Connect(a))
+}
+
+func TestServerReinjectsOrphanedTransactions(t *testing.T) {
+	servers, _ := newLocalServers(t, 1, nil)
+	s := servers[0]
+	privKey := crypto.GeneratePrivateKey()
+
+	signedTx := func(data string) *core.Transaction {
+		tx := core.NewTransaction([]byte(data))
+		assert.Nil(t, tx.Sign(privKey))
+		return tx
+	}
+	signedBlock := func(prev *core.Header, txx ...*core.Transaction) *core.Block {
+		b, err := core.NewBlockFromPrevHeader(prev, txx)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		return b
+	}
+
+	var (
+		orphanedTx = signedTx("only on the orphaned branch")
+		sharedTx   = signedTx("on both branches")
+	)
+
+	genesis, err := s.chain.GetHeader(0)
+	assert.Nil(t, err)
+
+	// The orphaned block is mined from the pool, its transactions stay
+	// pooled but are no longer pending.
+	s.mempool.Add(orphanedTx)
+	s.mempool.Add(sharedTx)
+	a1 := signedBlock(genesis, orphanedTx, sharedTx)
+	assert.Nil(t, s.chain.AddBlock(a1))
+	s.mempool.RemovePending(a1.Transactions)
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	b1 := signedBlock(genesis, sharedTx)
+	assert.Nil(t, s.chain.AddBlock(b1))
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	assert.Nil(t, s.chain.AddBlock(signedBlock(b1.Header)))
+	assert.Equal(t, uint32(2), s.chain.Height())
+
+	assert.Equal(t, []*core.Transaction{orphanedTx}, s.mempool.Pending())
+}
+
+func TestServerRollbackReinjectsTransactions(t *testing.T) {
+	servers, _ := newLocalServers(t, 1, nil)
+	s := servers[0]
+	privKey := crypto.GeneratePrivateKey()
+
+	var txx []*core.Transaction
+	for i := 0; i < 3; i++ {
+		tx := core.NewTransaction([]byte(fmt.Sprintf("rolled back %d", i)))
+		assert.Nil(t, tx.Sign(privKey))
+		txx = append(txx, tx)
+
+		b, err := core.NewBlockFromPrevHeader(s.chain.LastHeader(), []*core.Transaction{tx})
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		assert.Nil(t, s.chain.AddBlock(b))
+	}
+
+	assert.Nil(t, s.chain.Rollback(1))
+	assert.Equal(t, uint32(1), s.chain.Height())
+	assert.Equal(t, 2, s.mempool.PendingCount())
+	assert.False(t, s.mempool.Contains(txx[0].Hash(core.TxHasher{})))
+	assert.True(t, s.mempool.Contains(txx[1].Hash(core.TxHasher{})))
+	assert.True(t, s.mempool.Contains(txx[2].Hash(core.TxHasher{})))
+}
+
+func TestServerRollbackReturnsMinedTransactionsToPending(t *testing.T) {
+	servers, _ := newLocalServers(t, 1, nil)
+	s := servers[0]
+	privKey := crypto.GeneratePrivateKey()
+
+	var txx []*core.Transaction
+	for i := 0; i < 3; i++ {
+		tx := core.NewTransaction([]byte(fmt.Sprintf("mined %d", i)))
+		assert.Nil(t, tx.Sign(privKey))
+		txx = append(txx, tx)
+
+		s.mempool.Add(tx)
+		b, err := core.NewBlockFromPrevHeader(s.chain.LastHeader(), []*core.Transaction{tx})
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		assert.Nil(t, s.chain.AddBlock(b))
+		s.mempool.RemovePending(b.Transactions)
+	}
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	assert.Nil(t, s.chain.Rollback(1))
+	assert.Equal(t, txx[1:], s.mempool.Pending())
+}
+
+func TestServerTxInventoryGossip(t *testing.T) {
+	servers, transports := newLocalServers(t, 2, nil)
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	tx := core.NewTransaction([]byte("announced"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	hash := tx.Hash(core.TxHasher{})
+
+	assert.Nil(t, servers[0].processTransaction(tx))
+
+	assert.Eventually(t, func() bool {
+		return servers[1].mempool.Contains(hash)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Node 0 only announced the hash, node 1 asked for it and got the full
+	// transaction in response.
+	assert.Equal(t, 1, transports[0].sendCount(MessageTypeInv))
+	assert.Equal(t, 1, transports[1].sendCount(MessageTypeGetData))
+	assert.Equal(t, 1, transports[0].sendCount(MessageTypeTxBatch))
+	assert.Equal(t, 0, transports[0].sendCount(MessageTypeTx))
+
+	// Node 1 announces the transaction back, node 0 already has it.
+	assert.Eventually(t, func() bool {
+		return transports[1].sendCount(MessageTypeInv) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, transports[0].sendCount(MessageTypeGetData))
+}
+
+func TestServerObserverDoesNotRelay(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	servers, transports := newLocalServers(t, 2, func(i int, opts *ServerOpts) {
+		if i == 1 {
+			opts.Observer = true
+			opts.PrivateKey = &privKey
+		}
+	})
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+	observer := servers[1]
+	assert.False(t, observer.isValidator)
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	tx := core.NewTransaction([]byte("observed"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, observer.processTransaction(tx))
+	assert.True(t, observer.mempool.Contains(tx.Hash(core.TxHasher{})))
+
+	b, err := core.NewBlockFromPrevHeader(observer.chain.LastHeader(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, observer.processBlock(transports[0].Addr(), b))
+	assert.Equal(t, uint32(1), observer.chain.Height())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, transports[1].sendCount(MessageTypeInv))
+	assert.Equal(t, 0, transports[1].sendCount(MessageTypeBlock))
+	assert.False(t, servers[0].mempool.Contains(tx.Hash(core.TxHasher{})))
+}
+
+func TestServerRetriesSeedNode(t *testing.T) {
+	// Reserve an address for the seed that nobody listens on yet.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	seedAddr := ln.Addr().String()
+	assert.Nil(t, ln.Close())
+
+	s, err := NewServer(ServerOpts{
+		ID:         "NODE",
+		Logger:     log.NewNopLogger(),
+		ListenAddr: "127.0.0.1:0",
+		SeedNodes:  []string{seedAddr},
+		SeedRetry: RetryPolicy{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     50 * time.Millisecond,
+		},
+	})
+	assert.Nil(t, err)
+	go s.Start()
+	defer s.Stop()
+
+	// Let a few attempts fail before the seed comes up.
+	time.Sleep(1200 * time.Millisecond)
+
+	seed, err := NewServer(ServerOpts{
+		ID:         "SEED",
+		Logger:     log.NewNopLogger(),
+		ListenAddr: seedAddr,
+	})
+	assert.Nil(t, err)
+	go seed.Start()
+	defer seed.Stop()
+
+	assert.Eventually(t, func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.peerMap) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+// stubResolver resolves the host names in its map, others fail.
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestServerDialsDNSSeeds(t *testing.T) {
+	// Every address the seed resolves to listens on the same port.
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	_, port, err := net.SplitHostPort(first.Addr().String())
+	assert.Nil(t, err)
+
+	listeners := []net.Listener{first}
+	for _, ip := range []string{"127.0.0.2", "127.0.0.3"} {
+		ln, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			t.Skipf("cannot listen on %s: %s", ip, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	accepted := make(chan string, len(listeners))
+	for _, ln := range listeners {
+		defer ln.Close()
+		go func(ln net.Listener) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			accepted <- ln.Addr().String()
+			io.Copy(io.Discard, conn)
+		}(ln)
+	}
+
+	s, err := NewServer(ServerOpts{
+		ID:         "NODE",
+		Logger:     log.NewNopLogger(),
+		ListenAddr: "127.0.0.1:0",
+		DNSSeeds: []string{
+			"unknown.seed:" + port,
+			"missing-port.seed",
+			"seed.test:" + port,
+		},
+		Resolver: stubResolver{
+			"seed.test": {"127.0.0.1", "127.0.0.2", "127.0.0.3"},
+		},
+	})
+	assert.Nil(t, err)
+	go s.Start()
+	defer s.Stop()
+
+	dialed := map[string]bool{}
+	for len(dialed) < len(listeners) {
+		select {
+		case addr := <-accepted:
+			dialed[addr] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dialed (%d) of (%d) seed addresses", len(dialed), len(listeners))
+		}
+	}
+}
+
+func TestServerSkipEmptyBlocks(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(ServerOpts{
+		ID:              "NODE",
+		Logger:          log.NewNopLogger(),
+		BlockTime:       10 * time.Millisecond,
+		PrivateKey:      &privKey,
+		SkipEmptyBlocks: true,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uint32(0), s.chain.Height())
+
+	tx := core.NewTransaction([]byte("wake up"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, s.processTransaction(tx))
+
+	assert.Eventually(t, func() bool {
+		return s.chain.Height() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The mempool is empty again, so no further blocks are produced.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uint32(1), s.chain.Height())
+}
+
+func TestServerDecoderReturnsNilMessage(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:     "NODE",
+		Logger: log.NewNopLogger(),
+		RPCDecodeFunc: func(RPC) (*DecodedMessage, error) {
+			return nil, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	assert.NotPanics(t, func() {
+		s.handleRPC(RPC{From: NetAddr("PEER"), Payload: strings.NewReader("anything")})
+	})
+}
+
+// processedCounter wraps a processor and marks every processed message as
+// done on the wait group.
+type processedCounter struct {
+	RPCProcessor
+	wg *sync.WaitGroup
+}
+
+func (p *processedCounter) ProcessMessage(msg *DecodedMessage) error {
+	defer p.wg.Done()
+	return p.RPCProcessor.ProcessMessage(msg)
+}
+
+func BenchmarkServerTxFlood(b *testing.B) {
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			s, err := NewServer(ServerOpts{
+				ID:         "NODE",
+				Logger:     log.NewNopLogger(),
+				RPCWorkers: workers,
+			})
+			assert.Nil(b, err)
+
+			wg := &sync.WaitGroup{}
+			s.RPCProcessor = &processedCounter{RPCProcessor: s, wg: wg}
+
+			privKey := crypto.GeneratePrivateKey()
+			rpcs := make([]RPC, b.N)
+			for i := range rpcs {
+				tx := core.NewTransaction([]byte(fmt.Sprintf("flood %d", i)))
+				assert.Nil(b, tx.Sign(privKey))
+
+				buf := &bytes.Buffer{}
+				assert.Nil(b, tx.Encode(core.NewGobTxEncoder(buf)))
+				rpcs[i] = RPC{
+					From:    NetAddr(fmt.Sprintf("PEER_%d", i%8)),
+					Payload: bytes.NewReader(NewMessage(MessageTypeTx, buf.Bytes()).Bytes()),
+				}
+			}
+
+			go s.Start()
+			defer s.Stop()
+
+			b.ResetTimer()
+			wg.Add(b.N)
+			for _, rpc := range rpcs {
+				s.rpcCh <- rpc
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// addrConn is a connection with a fixed remote address.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+func (c addrConn) Close() error         { return nil }
+
+func TestServerReservesOutboundSlots(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:            "NODE",
+		Logger:        log.NewNopLogger(),
+		MaxPeers:      3,
+		OutboundSlots: 1,
+	})
+	assert.Nil(t, err)
+
+	newPeer := func(addr string, outgoing bool) *TCPPeer {
+		return &TCPPeer{conn: addrConn{addr: NetAddr(addr)}, Outgoing: outgoing}
+	}
+
+	assert.Nil(t, s.addPeer(newPeer("IN_1", false)))
+	assert.Nil(t, s.addPeer(newPeer("IN_2", false)))
+	// The last slot is reserved for a peer we dial.
+	assert.ErrorIs(t, s.addPeer(newPeer("IN_3", false)), ErrPeerSlotsFull)
+	assert.Nil(t, s.addPeer(newPeer("OUT_1", true)))
+	assert.ErrorIs(t, s.addPeer(newPeer("OUT_2", true)), ErrPeerSlotsFull)
+
+	stats := s.Stats()
+	assert.Equal(t, 2, stats.InboundPeers)
+	assert.Equal(t, 1, stats.OutboundPeers)
+}
+
+func TestServerReconnectsDroppedPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	servers, _ := newLocalServers(t, 1, func(i int, opts *ServerOpts) {
+		opts.ReconnectRetry = RetryPolicy{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond,
+		}
+	})
+	s := servers[0]
+	go s.Start()
+	defer s.Stop()
+
+	go s.dialWithRetry(ln.Addr().String(), RetryPolicy{MaxAttempts: 1})
+
+	// accept returns the next connection of the server, once it started the
+	// handshake on it.
+	accept := func() net.Conn {
+		assert.Nil(t, ln.(*net.TCPListener).SetDeadline(time.Now().Add(2*time.Second)))
+		conn, err := ln.Accept()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		assert.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, err := conn.Read(make([]byte, 2048))
+		assert.Nil(t, err)
+		assert.Greater(t, n, 0)
+
+		return conn
+	}
+
+	first := accept()
+	assert.Equal(t, 1, s.Stats().OutboundPeers)
+	first.Close()
+
+	second := accept()
+	defer second.Close()
+	assert.Eventually(t, func() bool {
+		return s.Stats().OutboundPeers == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// blockingTransport blocks every send until it is released.
+type blockingTransport struct {
+	*LocalTransport
+	release chan struct{}
+}
+
+func (t *blockingTransport) SendMessage(to net.Addr, payload []byte) error {
+	<-t.release
+	return t.LocalTransport.SendMessage(to, payload)
+}
+
+func TestServerGossipBounded(t *testing.T) {
+	tr := &blockingTransport{
+		LocalTransport: NewLocalTransport(NetAddr("NODE")),
+		release:        make(chan struct{}),
+	}
+	connectLocal(t, tr.LocalTransport, NewLocalTransport(NetAddr("PEER")))
+
+	s, err := NewServer(ServerOpts{
+		ID:              "NODE",
+		Logger:          log.NewNopLogger(),
+		BlockTime:       time.Hour,
+		Transports:      []Transport{tr},
+		GossipQueueSize: 16,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	before := runtime.NumGoroutine()
+
+	privKey := crypto.GeneratePrivateKey()
+	for i := 0; i < 2000; i++ {
+		tx := core.NewTransaction([]byte(fmt.Sprintf("flood %d", i)))
+		assert.Nil(t, tx.Sign(privKey))
+		assert.Nil(t, s.processTransaction(tx))
+	}
+
+	// The peer doesn't take any message, announcements are queued or
+	// dropped instead of piling up in goroutines.
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+5)
+	assert.Equal(t, 2000, s.mempool.PendingCount())
+
+	close(tr.release)
+}
+
+func TestServerNetworkMagic(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:           "NODE",
+		Logger:       log.NewNopLogger(),
+		BlockTime:    time.Hour,
+		NetworkMagic: 0xcafebabe,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	txFrame := func(data string) ([]byte, types.Hash) {
+		tx := core.NewTransaction([]byte(data))
+		assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, tx.Encode(core.NewGobTxEncoder(buf)))
+		return NewMessage(MessageTypeTx, buf.Bytes()).Bytes(), tx.Hash(core.TxHasher{})
+	}
+
+	frame, hash := txFrame("wrong magic")
+	s.handleRPC(RPC{From: NetAddr("PEER"), Payload: bytes.NewReader(withMagic(0xdeadbeef, frame))})
+	assert.False(t, s.mempool.Contains(hash))
+
+	frame, hash = txFrame("no magic")
+	s.handleRPC(RPC{From: NetAddr("PEER"), Payload: bytes.NewReader(frame)})
+	assert.False(t, s.mempool.Contains(hash))
+
+	frame, hash = txFrame("right magic")
+	s.handleRPC(RPC{From: NetAddr("PEER"), Payload: bytes.NewReader(withMagic(0xcafebabe, frame))})
+	assert.True(t, s.mempool.Contains(hash))
+}
+
+func TestServerNegotiatesCodec(t *testing.T) {
+	servers, transports := newLocalServers(t, 2, func(i int, opts *ServerOpts) {
+		opts.Codecs = []Codec{CodecJSON, CodecGob}
+	})
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	// Each side answers the status request of the other.
+	assert.Nil(t, servers[0].processGetStatusMessage(transports[1].Addr(), &GetStatusMessage{}))
+	assert.Nil(t, servers[1].processGetStatusMessage(transports[0].Addr(), &GetStatusMessage{}))
+
+	assert.Eventually(t, func() bool {
+		return servers[0].peerCodec(transports[1].Addr()) == CodecJSON &&
+			servers[1].peerCodec(transports[0].Addr()) == CodecJSON
+	}, 2*time.Second, 10*time.Millisecond)
+
+	tx := core.NewTransaction([]byte("json encoded"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, servers[0].processTransaction(tx))
+
+	assert.Eventually(t, func() bool {
+		return servers[1].mempool.Contains(tx.Hash(core.TxHasher{}))
+	}, 2*time.Second, 10*time.Millisecond)
+
+	transports[0].lock.Lock()
+	defer transports[0].lock.Unlock()
+	assert.Equal(t, CodecJSON, transports[0].batchCodec)
+}
+
+func TestServerCompression(t *testing.T) {
+	servers, transports := newLocalServers(t, 1, func(i int, opts *ServerOpts) {
+		opts.Compression = true
+	})
+	s := servers[0]
+
+	compressing := NewLocalTransport(NetAddr("COMPRESSING"))
+	plain := NewLocalTransport(NetAddr("PLAIN"))
+	connectLocal(t, transports[0].LocalTransport, compressing)
+	connectLocal(t, transports[0].LocalTransport, plain)
+
+	genesisHash, err := s.genesisHash()
+	assert.Nil(t, err)
+	assert.Nil(t, s.processStatusMessage(compressing.Addr(), &StatusMessage{GenesisHash: genesisHash, Compression: true}))
+	assert.Nil(t, s.processStatusMessage(plain.Addr(), &StatusMessage{GenesisHash: genesisHash}))
+
+	frame := largeBlockFrame(t)
+	assert.Nil(t, s.sendMessage(compressing.Addr(), frame))
+	assert.Nil(t, s.sendMessage(plain.Addr(), frame))
+
+	payload, err := io.ReadAll((<-compressing.Consume()).Payload)
+	assert.Nil(t, err)
+	assert.Less(t, len(payload), len(frame))
+
+	msg, err := DecodeMessage(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, msg.Bytes())
+
+	// Small messages and peers without compression get the frame as is.
+	payload, err = io.ReadAll((<-plain.Consume()).Payload)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, payload)
+
+	small := NewMessage(MessageTypeGetStatus, nil).Bytes()
+	assert.Equal(t, small, s.compress(compressing.Addr(), small))
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	assert.Equal(t, CodecJSON, negotiateCodec([]Codec{CodecJSON, CodecGob}, []Codec{CodecGob, CodecJSON}))
+	assert.Equal(t, CodecGob, negotiateCodec([]Codec{CodecGob, CodecJSON}, []Codec{CodecJSON, CodecGob}))
+	// Peers without codecs in their status only speak gob.
+	assert.Equal(t, CodecGob, negotiateCodec([]Codec{CodecJSON}, nil))
+}
+
+func TestServerPrunedBlocksUnavailable(t *testing.T) {
+	servers, transports := newLocalServers(t, 2, func(i int, opts *ServerOpts) {
+		if i == 0 {
+			opts.PruneDepth = 3
+		}
+	})
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	privKey := crypto.GeneratePrivateKey()
+	for i := 0; i < 10; i++ {
+		b, err := core.NewBlockFromPrevHeader(servers[0].chain.LastHeader(), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		assert.Nil(t, servers[0].chain.AddBlock(b))
+	}
+
+	_, err := servers[0].chain.GetBlock(1)
+	assert.ErrorIs(t, err, core.ErrBlockPruned)
+
+	assert.Nil(t, servers[0].processGetBlocksMessage(transports[1].Addr(), &GetBlocksMessage{}))
+	assert.Equal(t, 1, transports[0].sendCount(MessageTypeBlocks))
+
+	rpc := <-transports[1].Consume()
+	msg, err := DefaultRPCDecodeFunc(rpc)
+	assert.Nil(t, err)
+	blocks := msg.Data.(*BlocksMessage)
+	assert.True(t, blocks.Unavailable)
+	assert.Empty(t, blocks.Blocks)
+}
+
+// blockingValidator holds every block in ValidateBlock until released.
+type blockingValidator struct {
+	next    core.Validator
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (v *blockingValidator) ValidateBlock(b *core.Block) error {
+	v.entered <- struct{}{}
+	<-v.release
+
+	return v.next.ValidateBlock(b)
+}
+
+func TestServerMaxBlockValidations(t *testing.T) {
+	servers, _ := newLocalServers(t, 1, func(i int, opts *ServerOpts) {
+		opts.MaxBlockValidations = 2
+	})
+	s := servers[0]
+
+	validator := &blockingValidator{
+		next:    core.NewBlockValidator(s.chain),
+		entered: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	s.chain.SetValidator(validator)
+
+	privKey := crypto.GeneratePrivateKey()
+	newBlock := func() *core.Block {
+		b, err := core.NewBlockFromPrevHeader(s.chain.LastHeader(), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		return b
+	}
+	first, second, third := newBlock(), newBlock(), newBlock()
+	second.Timestamp = first.Timestamp + 1
+	third.Timestamp = first.Timestamp + 2
+	assert.Nil(t, second.Sign(privKey))
+	assert.Nil(t, third.Sign(privKey))
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.processBlock(NetAddr("PEER"), first) }()
+	<-validator.entered
+	go func() { errs <- s.processBlock(NetAddr("PEER"), second) }()
+
+	// The second block holds a slot while it waits for the first one.
+	assert.Eventually(t, func() bool {
+		return len(s.validationSlots) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.ErrorIs(t, s.processBlock(NetAddr("PEER"), third), ErrValidationSlotsFull)
+
+	close(validator.release)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, <-errs)
+	}
+	assert.Empty(t, s.validationSlots)
+
+	// The rejected block was not marked as seen.
+	assert.Nil(t, s.processBlock(NetAddr("PEER"), third))
+}
+
+func TestServerQuarantinesRejectedBlock(t *testing.T) {
+	servers, _ := newLocalServers(t, 1, nil)
+	s := servers[0]
+
+	b, err := core.NewBlockFromPrevHeader(s.chain.LastHeader(), nil)
+	assert.Nil(t, err)
+	b.GasUsed = 1
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	assert.ErrorIs(t, s.processBlock(NetAddr("PEER"), b), core.ErrGasUsedMismatch)
+
+	stats := s.Stats()
+	if assert.Len(t, stats.Quarantine, 1) {
+		assert.Equal(t, b.Hash(core.BlockHasher{}), stats.Quarantine[0].Hash)
+		assert.Equal(t, "PEER", stats.Quarantine[0].Source)
+		assert.Contains(t, stats.Quarantine[0].Reason, core.ErrGasUsedMismatch.Error())
+	}
+}
+
+func TestServerKnownTransaction(t *testing.T) {
+	logs := &bytes.Buffer{}
+	s, err := NewServer(ServerOpts{
+		ID:        "NODE",
+		Logger:    log.NewLogfmtLogger(logs),
+		BlockTime: time.Hour,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	tx := core.NewTransaction([]byte("twice"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, s.processTransaction(tx))
+	assert.ErrorIs(t, s.processTransaction(tx), ErrTxKnown)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(core.NewGobTxEncoder(buf)))
+	frame := NewMessage(MessageTypeTx, buf.Bytes()).Bytes()
+
+	s.handleRPC(RPC{From: NetAddr("PEER"), Payload: bytes.NewReader(frame)})
+	assert.NotContains(t, logs.String(), "error")
+	assert.Equal(t, uint64(1), s.Stats().KnownMessages)
+}
+
+func TestServerSyncsFromCommonAncestor(t *testing.T) {
+	servers, transports := newLocalServers(t, 2, nil)
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	privKey := crypto.GeneratePrivateKey()
+	extend := func(chains ...*core.Blockchain) {
+		b, err := core.NewBlockFromPrevHeader(chains[0].LastHeader(), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		for _, bc := range chains {
+			assert.Nil(t, bc.AddBlock(b))
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		extend(servers[0].chain, servers[1].chain)
+	}
+	for i := 0; i < 10; i++ {
+		extend(servers[0].chain)
+	}
+	for i := 0; i < 2; i++ {
+		extend(servers[1].chain)
+	}
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	genesisHash, err := servers[0].genesisHash()
+	assert.Nil(t, err)
+	assert.Nil(t, servers[1].processStatusMessage(transports[0].Addr(), &StatusMessage{
+		CurrentHeight: servers[0].chain.Height(),
+		GenesisHash:   genesisHash,
+	}))
+
+	assert.Eventually(t, func() bool {
+		return servers[1].chain.Height() == 15
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, servers[0].chain.LastHeader(), servers[1].chain.LastHeader())
+}
+
+// orderRecorder records the order messages are processed in, the first
+// message is held up so a message overtaking it would be noticed.
+type orderRecorder struct {
+	mu        sync.Mutex
+	started   bool
+	processed []string
+	wg        *sync.WaitGroup
+}
+
+func (r *orderRecorder) ProcessMessage(msg *DecodedMessage) error {
+	defer r.wg.Done()
+
+	r.mu.Lock()
+	first := !r.started
+	r.started = true
+	r.mu.Unlock()
+	if first {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.processed = append(r.processed, fmt.Sprintf("%T", msg.Data))
+
+	return nil
+}
+
+func TestServerRelaxedOrdering(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:              "NODE",
+		Logger:          log.NewNopLogger(),
+		BlockTime:       time.Hour,
+		RPCWorkers:      4,
+		RelaxedOrdering: true,
+	})
+	assert.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+	recorder := &orderRecorder{wg: wg}
+	s.RPCProcessor = recorder
+
+	go s.Start()
+	defer s.Stop()
+
+	rpc := func(msgType MessageType, data any) RPC {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, gob.NewEncoder(buf).Encode(data))
+		return RPC{
+			From:    NetAddr("PEER"),
+			Payload: bytes.NewReader(NewMessage(msgType, buf.Bytes()).Bytes()),
+		}
+	}
+
+	wg.Add(2)
+	s.rpcCh <- rpc(MessageTypeGetStatus, &GetStatusMessage{})
+	s.rpcCh <- rpc(MessageTypeGetBlocks, &GetBlocksMessage{From: 1})
+	wg.Wait()
+
+	assert.Equal(t, []string{"*network.GetStatusMessage", "*network.GetBlocksMessage"}, recorder.processed)
+
+	assert.True(t, requiresOrdering(&core.Block{}))
+	assert.False(t, requiresOrdering(&core.Transaction{}))
+}
+
+func TestServerRelaxedOrderingDecodesOnce(t *testing.T) {
+	var decoded int32
+	s, err := NewServer(ServerOpts{
+		ID:              "NODE",
+		Logger:          log.NewNopLogger(),
+		BlockTime:       time.Hour,
+		RPCWorkers:      4,
+		RelaxedOrdering: true,
+		RPCDecodeFunc: func(rpc RPC) (*DecodedMessage, error) {
+			atomic.AddInt32(&decoded, 1)
+			return DefaultRPCDecodeFunc(rpc)
+		},
+	})
+	assert.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+	s.RPCProcessor = &orderRecorder{wg: wg}
+
+	go s.Start()
+	defer s.Stop()
+
+	const n = 20
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, util.NewRandomTransaction(32).Encode(core.NewGobTxEncoder(buf)))
+		s.rpcCh <- RPC{
+			From:    NetAddr(fmt.Sprintf("PEER_%d", i%3)),
+			Payload: bytes.NewReader(NewMessage(MessageTypeTx, buf.Bytes()).Bytes()),
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(n), atomic.LoadInt32(&decoded))
+}
+
+func TestServerMempoolSamples(t *testing.T) {
+	clock := newFakeClock()
+	s, err := NewServer(ServerOpts{
+		ID:                    "NODE",
+		Logger:                log.NewNopLogger(),
+		BlockTime:             time.Hour,
+		Clock:                 clock,
+		MempoolSampleInterval: time.Second,
+		MempoolSamples:        3,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	assert.Eventually(t, func() bool {
+		return clock.tickerCount() == 1
+	}, time.Second, time.Millisecond)
+
+	sampled := func(n int) {
+		clock.Advance(time.Second)
+		assert.Eventually(t, func() bool {
+			return len(s.Stats().MempoolSamples) == n
+		}, time.Second, time.Millisecond)
+	}
+
+	sampled(1)
+	for i := 0; i < 4; i++ {
+		tx := core.NewTransaction([]byte(fmt.Sprintf("sample %d", i)))
+		assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+		assert.Nil(t, s.processTransaction(tx))
+	}
+	sampled(2)
+
+	samples := s.Stats().MempoolSamples
+	assert.Equal(t, 0, samples[0].Pending)
+	assert.Equal(t, 4, samples[1].Pending)
+	assert.Equal(t, clock.Now(), samples[1].Time)
+
+	// Only the most recent samples are kept.
+	sampled(3)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool {
+		samples := s.Stats().MempoolSamples
+		return len(samples) == 3 && samples[2].Time.Equal(clock.Now())
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 4, s.Stats().MempoolSamples[0].Pending)
+}
+
+func TestServerTxTimestampTolerance(t *testing.T) {
+	clock := newFakeClock()
+	s, err := NewServer(ServerOpts{
+		ID:                   "NODE",
+		Logger:               log.NewNopLogger(),
+		BlockTime:            time.Hour,
+		Clock:                clock,
+		TxTimestampTolerance: time.Minute,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	signed := func(data string, ts time.Time) *core.Transaction {
+		tx := core.NewTransaction([]byte(data))
+		tx.Timestamp = ts.UnixNano()
+		assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+		return tx
+	}
+
+	assert.Nil(t, s.processTransaction(signed("recent", clock.Now().Add(-30*time.Second))))
+	assert.ErrorIs(t, s.processTransaction(signed("stale", clock.Now().Add(-time.Hour))), core.ErrTxTimestamp)
+	assert.ErrorIs(t, s.processTransaction(signed("undeclared", time.Unix(0, 0))), core.ErrTxTimestamp)
+	assert.Equal(t, 1, s.mempool.PendingCount())
+}
+
+func TestServerDiscardsUnrequestedBlocks(t *testing.T) {
+	clock := newFakeClock()
+	s, err := NewServer(ServerOpts{
+		ID:             "NODE",
+		Logger:         log.NewNopLogger(),
+		BlockTime:      time.Hour,
+		Clock:          clock,
+		RequestTimeout: time.Minute,
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	peer := NetAddr("PEER")
+	b, err := core.NewBlockFromPrevHeader(s.chain.LastHeader(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	id := s.requests.Open(peer, MessageTypeBlocks, clock.Now())
+
+	// A response with an unknown id is ignored.
+	assert.Nil(t, s.processBlocksMessage(peer, &BlocksMessage{RequestID: id + 1, Blocks: []*core.Block{b}}))
+	assert.Equal(t, uint32(0), s.chain.Height())
+
+	// The request times out and its response is ignored as well.
+	clock.Advance(2 * time.Minute)
+	assert.Equal(t, 0, s.requests.Len(clock.Now()))
+	assert.Nil(t, s.processBlocksMessage(peer, &BlocksMessage{RequestID: id, Blocks: []*core.Block{b}}))
+	assert.Equal(t, uint32(0), s.chain.Height())
+
+	id = s.requests.Open(peer, MessageTypeBlocks, clock.Now())
+	assert.Nil(t, s.processBlocksMessage(peer, &BlocksMessage{RequestID: id, Blocks: []*core.Block{b}}))
+	assert.Equal(t, uint32(1), s.chain.Height())
+}
+
+func TestServerRequestTx(t *testing.T) {
+	servers, transports := newLocalServers(t, 2, nil)
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	privKey := crypto.GeneratePrivateKey()
+	signedTx := func(data string) *core.Transaction {
+		tx := core.NewTransaction([]byte(data))
+		assert.Nil(t, tx.Sign(privKey))
+		return tx
+	}
+
+	var (
+		pendingTx   = signedTx("pending at the peer")
+		confirmedTx = signedTx("confirmed at the peer")
+		peer        = transports[1].Addr()
+	)
+
+	servers[1].mempool.Add(pendingTx)
+	b, err := core.NewBlockFromPrevHeader(servers[1].chain.LastHeader(), []*core.Transaction{confirmedTx})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, servers[1].chain.AddBlock(b))
+
+	for _, tx := range []*core.Transaction{pendingTx, confirmedTx} {
+		hash := tx.Hash(core.TxHasher{})
+		assert.Nil(t, servers[0].RequestTx(peer, hash))
+		assert.Eventually(t, func() bool {
+			return servers[0].mempool.Contains(hash)
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// An unknown hash is answered with an empty response.
+	assert.Nil(t, servers[0].RequestTx(peer, types.Hash{1}))
+	assert.Eventually(t, func() bool {
+		return servers[0].requests.Len(servers[0].Clock.Now()) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, servers[0].mempool.PendingCount())
+}
+
+func TestServerSubmitTransaction(t *testing.T) {
+	servers, transports := newLocalServers(t, 2, nil)
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	unsigned := core.NewTransaction([]byte("unsigned"))
+	assert.NotNil(t, servers[0].SubmitTransaction(unsigned))
+	assert.Equal(t, 0, servers[0].mempool.PendingCount())
+
+	tx := core.NewTransaction([]byte("submitted locally"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	hash := tx.Hash(core.TxHasher{})
+
+	assert.Nil(t, servers[0].SubmitTransaction(tx))
+	assert.True(t, servers[0].mempool.Contains(hash))
+	assert.ErrorIs(t, servers[0].SubmitTransaction(tx), ErrTxKnown)
+
+	assert.Eventually(t, func() bool {
+		return servers[1].mempool.Contains(hash)
+	}, time.Second, 10*time.Millisecond)
+	assert.Greater(t, transports[0].sendCount(MessageTypeInv), 0)
+}
+
+func TestServerBroadcastFanout(t *testing.T) {
+	for _, c := range []struct {
+		fanout, peers, want int
+	}{
+		{0, 100, 100},
+		{FanoutSqrt, 100, 10},
+		{FanoutSqrt, 10, 4},
+		{FanoutSqrt, 4, 3},
+		{FanoutSqrt, 2, 2},
+		{8, 100, 8},
+		{8, 5, 5},
+		{1, 100, 3},
+	} {
+		s := &Server{ServerOpts: ServerOpts{BroadcastFanout: c.fanout}}
+		assert.Equal(t, c.want, s.fanout(c.peers), "fanout (%d) of (%d) peers", c.fanout, c.peers)
+	}
+}
+
+// delayTransport delays every message it sends.
+type delayTransport struct {
+	Transport
+	delay time.Duration
+}
+
+func (t *delayTransport) SendMessage(to net.Addr, payload []byte) error {
+	time.Sleep(t.delay)
+	return t.Transport.SendMessage(to, payload)
+}
+
+func TestServerPeerLatency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	servers, transports := newLocalServers(t, 2, func(i int, opts *ServerOpts) {
+		if i == 0 {
+			opts.Transports = []Transport{&delayTransport{Transport: opts.Transports[0], delay: delay}}
+		}
+	})
+	connectLocal(t, transports[0].LocalTransport, transports[1].LocalTransport)
+
+	for _, s := range servers {
+		go s.Start()
+		defer s.Stop()
+	}
+
+	s, peer := servers[0], transports[1].Addr()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, s.Ping(peer))
+		assert.Eventually(t, func() bool {
+			return s.requests.Len(s.Clock.Now()) == 0
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	stats := s.Stats().Peers
+	assert.Len(t, stats, 1)
+	assert.Equal(t, peer.String(), stats[0].Addr)
+	assert.GreaterOrEqual(t, stats[0].Latency, delay)
+	assert.Less(t, stats[0].Latency, delay+500*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	s.servePeers(rec, httptest.NewRequest("GET", "/peers", nil))
+
+	var served []PeerStats
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&served))
+	assert.Equal(t, stats, served)
+}
+
+func TestPeerScoresRecordLatency(t *testing.T) {
+	ps := newPeerScores()
+	peer := NetAddr("PEER")
+
+	ps.RecordLatency(peer, 80*time.Millisecond)
+	assert.Equal(t, 80*time.Millisecond, ps.Stats()[0].Latency)
+
+	// A single slow round trip moves the average by an eighth.
+	ps.RecordLatency(peer, 160*time.Millisecond)
+	assert.Equal(t, 90*time.Millisecond, ps.Stats()[0].Latency)
+}
+
+func TestPeerScoresBanHost(t *testing.T) {
+	ps := newPeerScores()
+	bad := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	good := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40000}
+
+	for !ps.MessageResult(bad, false) {
+	}
+
+	// Reconnecting from another port doesn't lift the ban.
+	again := &net.TCPAddr{IP: bad.IP, Port: 40001}
+	assert.True(t, ps.IsBanned(again))
+	assert.False(t, ps.IsBanned(good))
+	assert.Equal(t, []net.Addr{good}, ps.Rank([]net.Addr{again, good}))
+}
+
+func TestPeerScoresDropDisconnected(t *testing.T) {
+	ps := newPeerScores()
+	gone, back, banned := NetAddr("GONE"), NetAddr("BACK"), NetAddr("BANNED")
+	now := time.Now()
+
+	ps.MessageResult(gone, true)
+	ps.MessageResult(back, true)
+	for !ps.MessageResult(banned, false) {
+	}
+
+	ps.Disconnected(gone, now)
+	ps.Disconnected(back, now)
+	ps.Disconnected(banned, now)
+	ps.Connected(back)
+	assert.Len(t, ps.Stats(), 3)
+
+	// The stats of peers away for longer than the TTL are dropped, the ban
+	// stays.
+	ps.Disconnected(NetAddr("OTHER"), now.Add(peerStatsTTL+time.Second))
+	stats := ps.Stats()
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "BACK", stats[0].Addr)
+	}
+	assert.True(t, ps.IsBanned(banned))
+}
+
+func TestServerRejectsUnaffordableTransaction(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:        "NODE",
+		Logger:    log.NewNopLogger(),
+		BlockTime: time.Hour,
+	})
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction([]byte("unfunded"))
+	tx.Value = 1
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	assert.ErrorIs(t, s.processTransaction(tx), core.ErrInsufficientBalance)
+	assert.Equal(t, 0, s.mempool.PendingCount())
+}
+
+func TestServerEvictsUnappliableTransactions(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	servers, _ := newLocalServers(t, 1, func(i int, opts *ServerOpts) {
+		opts.PrivateKeys = []crypto.PrivateKey{crypto.GeneratePrivateKey()}
+		opts.Genesis = &core.Genesis{
+			Alloc: map[types.Address]uint64{privKey.PublicKey().Address(): 10},
+		}
+	})
+	s := servers[0]
+
+	// Each transfer is affordable on its own, but not both of them.
+	first := core.NewTransaction([]byte("first"))
+	first.Value = 8
+	assert.Nil(t, first.Sign(privKey))
+	second := core.NewTransaction([]byte("second"))
+	second.Value = 8
+	assert.Nil(t, second.Sign(privKey))
+
+	assert.Nil(t, s.processTransaction(first))
+	assert.Nil(t, s.processTransaction(second))
+	assert.Equal(t, 2, s.mempool.PendingCount())
+
+	assert.Nil(t, s.createNewBlock())
+	assert.Equal(t, uint32(1), s.chain.Height())
+	assert.Equal(t, 0, s.mempool.PendingCount())
+
+	b, err := s.chain.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []*core.Transaction{first}, b.Transactions)
+
+	// Block production goes on with the mempool cleared.
+	assert.Nil(t, s.createNewBlock())
+	assert.Equal(t, uint32(2), s.chain.Height())
+}
+
+func TestServerRejectsBlockWithoutHeader(t *testing.T) {
+	servers, transports := newLocalServers(t, 1, nil)
+
+	assert.ErrorIs(t, servers[0].processBlock(transports[0].Addr(), &core.Block{}), core.ErrNilHeader)
+	assert.Equal(t, uint32(0), servers[0].chain.Height())
+}
+
+func TestServerRejectedBlockIsNotSeen(t *testing.T) {
+	servers, transports := newLocalServers(t, 1, nil)
+	s, from := servers[0], transports[0].Addr()
+
+	privKey := crypto.GeneratePrivateKey()
+	b, err := core.NewBlockFromPrevHeader(s.chain.LastHeader(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	// A copy of the header with a forged signature has the same hash.
+	forged, err := crypto.GeneratePrivateKey().Sign([]byte("forged"))
+	assert.Nil(t, err)
+	bad := &core.Block{Header: b.Header, Validator: b.Validator, Signature: forged}
+	assert.NotNil(t, s.processBlock(from, bad))
+	assert.False(t, s.seenBlocks.Contains(b.Hash(core.BlockHasher{})))
+
+	assert.Nil(t, s.processBlock(from, b))
+	assert.Equal(t, uint32(1), s.chain.Height())
+	assert.ErrorIs(t, s.processBlock(from, b), core.ErrBlockKnown)
+}
@@ -0,0 +1,108 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ayushn2/blockchainz/core"
+)
+
+// ServerStats is a snapshot of the state of a server for operators.
+type ServerStats struct {
+	ID             string
+	Height         uint32
+	MempoolPending int
+	Peers          []PeerStats
+	// InboundPeers and OutboundPeers count the TCP peers that connected to
+	// us and the ones we dialed.
+	InboundPeers  int
+	OutboundPeers int
+	// KnownMessages counts the blocks and transactions received that the
+	// server already had.
+	KnownMessages uint64
+	// MempoolSamples are the recent mempool lengths, oldest first. It is
+	// empty unless ServerOpts.MempoolSampleInterval is set.
+	MempoolSamples []MempoolSample
+	// Quarantine holds the recently rejected blocks with the reason and the
+	// peer they came from, oldest first.
+	Quarantine []core.QuarantinedBlock
+}
+
+// MempoolSample is the number of pending transactions at a point in time.
+type MempoolSample struct {
+	Time    time.Time
+	Pending int
+}
+
+// sampleRing keeps the most recent samples in a fixed size buffer.
+type sampleRing struct {
+	mu      sync.Mutex
+	samples []MempoolSample
+	// next is the index the next sample is written to.
+	next int
+	full bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	return &sampleRing{
+		samples: make([]MempoolSample, size),
+	}
+}
+
+func (r *sampleRing) Add(sample MempoolSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Samples returns a copy of the samples, oldest first.
+func (r *sampleRing) Samples() []MempoolSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]MempoolSample{}, r.samples[:r.next]...)
+	}
+
+	return append(append([]MempoolSample{}, r.samples[r.next:]...), r.samples[:r.next]...)
+}
+
+func (s *Server) Stats() ServerStats {
+	s.mu.RLock()
+	inbound, outbound := s.countPeers()
+	s.mu.RUnlock()
+
+	var samples []MempoolSample
+	if s.mempoolSamples != nil {
+		samples = s.mempoolSamples.Samples()
+	}
+
+	return ServerStats{
+		ID:             s.ID,
+		Height:         s.chain.Height(),
+		MempoolPending: s.mempool.PendingCount(),
+		Peers:          s.peerScores.Stats(),
+		InboundPeers:   inbound,
+		OutboundPeers:  outbound,
+		KnownMessages:  atomic.LoadUint64(&s.knownMessages),
+		MempoolSamples: samples,
+		Quarantine:     s.chain.Quarantine().Entries(),
+	}
+}
+
+// servePeers writes the stats of the peers, including their latency, as
+// JSON.
+func (s *Server) servePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.peerScores.Stats()); err != nil {
+		s.Logger.Log("msg", "failed to write peer stats", "err", err)
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"time"
 )
 
 type TCPPeer struct {
 	conn     net.Conn
 	Outgoing bool
+	// dialAddr is the address an outgoing peer was dialed at, it is dialed
+	// again when the connection drops.
+	dialAddr string
 }
 
 func (p *TCPPeer) Send(b []byte) error {
@@ -16,16 +20,19 @@ func (p *TCPPeer) Send(b []byte) error {
 	return err
 }
 
-func (p *TCPPeer) readLoop(rpcCh chan RPC) {
+// readLoop hands the messages of the peer to rpcCh until reading from the
+// connection fails, the error is returned. Every message gets its own copy
+// of the read buffer, messages queued for the RPC workers are decoded after
+// the next read.
+func (p *TCPPeer) readLoop(rpcCh chan RPC) error {
 	buf := make([]byte, 2048)
 	for {
 		n, err := p.conn.Read(buf)
 		if err != nil {
-			fmt.Printf("read error: %s", err)
-			continue
+			return err
 		}
 
-		msg := buf[:n]
+		msg := append([]byte(nil), buf[:n]...)
 		rpcCh <- RPC{
 			From:    p.conn.RemoteAddr(),
 			Payload: bytes.NewReader(msg),
@@ -33,17 +40,104 @@ func (p *TCPPeer) readLoop(rpcCh chan RPC) {
 	}
 }
 
+// TCPOptions are applied to every connection of a TCP transport.
+type TCPOptions struct {
+	// ReadBufferSize and WriteBufferSize set the socket buffer sizes, zero
+	// keeps the operating system default.
+	ReadBufferSize  int
+	WriteBufferSize int
+	NoDelay         bool
+	// KeepAlive is the period between keep-alive probes, zero disables
+	// keep-alive.
+	KeepAlive time.Duration
+}
+
+func DefaultTCPOptions() TCPOptions {
+	return TCPOptions{
+		NoDelay:   true,
+		KeepAlive: 15 * time.Second,
+	}
+}
+
+// tcpConn is the part of *net.TCPConn used to apply the TCPOptions.
+type tcpConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+	SetNoDelay(noDelay bool) error
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+func (o TCPOptions) apply(conn tcpConn) error {
+	if o.ReadBufferSize > 0 {
+		if err := conn.SetReadBuffer(o.ReadBufferSize); err != nil {
+			return err
+		}
+	}
+
+	if o.WriteBufferSize > 0 {
+		if err := conn.SetWriteBuffer(o.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.SetNoDelay(o.NoDelay); err != nil {
+		return err
+	}
+
+	if err := conn.SetKeepAlive(o.KeepAlive > 0); err != nil {
+		return err
+	}
+
+	if o.KeepAlive > 0 {
+		return conn.SetKeepAlivePeriod(o.KeepAlive)
+	}
+
+	return nil
+}
+
 type TCPTransport struct {
 	peerCh     chan *TCPPeer
 	listenAddr string
 	listener   net.Listener
+	opts       TCPOptions
 }
 
 func NewTCPTransport(addr string, peerCh chan *TCPPeer) *TCPTransport {
+	return NewTCPTransportWithOptions(addr, peerCh, DefaultTCPOptions())
+}
+
+func NewTCPTransportWithOptions(addr string, peerCh chan *TCPPeer, opts TCPOptions) *TCPTransport {
 	return &TCPTransport{
 		peerCh:     peerCh,
 		listenAddr: addr,
+		opts:       opts,
+	}
+}
+
+// Dial connects to the given address and applies the options of the
+// transport to the connection.
+func (t *TCPTransport) Dial(addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
 	}
+
+	if err := t.configure(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (t *TCPTransport) configure(conn net.Conn) error {
+	tc, ok := conn.(tcpConn)
+	if !ok {
+		return nil
+	}
+
+	return t.opts.apply(tc)
 }
 
 func (t *TCPTransport) Start() error {
@@ -67,6 +161,12 @@ func (t *TCPTransport) acceptLoop() {
 			continue
 		}
 
+		if err := t.configure(conn); err != nil {
+			fmt.Printf("configure error from %s: %s\n", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+
 		peer := &TCPPeer{
 			conn: conn,
 		}
@@ -0,0 +1,91 @@
+package network
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingConn records the options set on it.
+type recordingConn struct {
+	readBuffer      int
+	writeBuffer     int
+	noDelay         bool
+	keepAlive       bool
+	keepAlivePeriod time.Duration
+}
+
+func (c *recordingConn) SetReadBuffer(bytes int) error            { c.readBuffer = bytes; return nil }
+func (c *recordingConn) SetWriteBuffer(bytes int) error           { c.writeBuffer = bytes; return nil }
+func (c *recordingConn) SetNoDelay(noDelay bool) error            { c.noDelay = noDelay; return nil }
+func (c *recordingConn) SetKeepAlive(keepAlive bool) error        { c.keepAlive = keepAlive; return nil }
+func (c *recordingConn) SetKeepAlivePeriod(d time.Duration) error { c.keepAlivePeriod = d; return nil }
+
+func TestTCPOptionsApply(t *testing.T) {
+	conn := &recordingConn{}
+	opts := TCPOptions{
+		ReadBufferSize:  1 << 16,
+		WriteBufferSize: 1 << 17,
+		NoDelay:         true,
+		KeepAlive:       time.Minute,
+	}
+	assert.Nil(t, opts.apply(conn))
+
+	assert.Equal(t, 1<<16, conn.readBuffer)
+	assert.Equal(t, 1<<17, conn.writeBuffer)
+	assert.True(t, conn.noDelay)
+	assert.True(t, conn.keepAlive)
+	assert.Equal(t, time.Minute, conn.keepAlivePeriod)
+
+	conn = &recordingConn{noDelay: true, keepAlive: true}
+	assert.Nil(t, TCPOptions{}.apply(conn))
+	assert.False(t, conn.noDelay)
+	assert.False(t, conn.keepAlive)
+	assert.Equal(t, 0, conn.readBuffer)
+}
+
+func TestTCPTransportDialAppliesOptions(t *testing.T) {
+	peerCh := make(chan *TCPPeer, 1)
+	tr := NewTCPTransportWithOptions("127.0.0.1:0", peerCh, TCPOptions{
+		ReadBufferSize:  1 << 16,
+		WriteBufferSize: 1 << 16,
+		NoDelay:         true,
+		KeepAlive:       time.Minute,
+	})
+	assert.Nil(t, tr.Start())
+	defer tr.listener.Close()
+
+	conn, err := tr.Dial(tr.listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.IsType(t, &net.TCPConn{}, conn)
+
+	peer := <-peerCh
+	assert.NotNil(t, peer.conn)
+	peer.conn.Close()
+}
+
+func TestTCPPeerReadLoopCopiesMessages(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	rpcCh := make(chan RPC, 2)
+	peer := &TCPPeer{conn: local}
+	go peer.readLoop(rpcCh)
+
+	// Both messages are queued before the first one is read.
+	for _, msg := range []string{"first", "second"} {
+		_, err := remote.Write([]byte(msg))
+		assert.Nil(t, err)
+	}
+
+	for _, want := range []string{"first", "second"} {
+		rpc := <-rpcCh
+		got, err := io.ReadAll(rpc.Payload)
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(got))
+	}
+}
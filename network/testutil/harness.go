@@ -0,0 +1,150 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/network"
+	"github.com/ayushn2/blockchainz/types"
+	"github.com/go-kit/log"
+)
+
+// Node is a single server of the harness together with its local transport.
+type Node struct {
+	Server    *network.Server
+	Transport *network.LocalTransport
+}
+
+// Harness runs a network of servers connected over local transports, so
+// tests don't have to wire servers and transports by hand.
+type Harness struct {
+	Nodes []*Node
+
+	// client is connected to every node and used to inject messages.
+	client *network.LocalTransport
+}
+
+// NewHarness creates n servers that are not connected to each other yet,
+// configure (which may be nil) is called with the options of every server
+// before it is created.
+func NewHarness(n int, configure func(i int, opts *network.ServerOpts)) (*Harness, error) {
+	h := &Harness{
+		client: network.NewLocalTransport(network.NetAddr("HARNESS_CLIENT")),
+	}
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("NODE_%d", i)
+		tr := network.NewLocalTransport(network.NetAddr(id))
+
+		opts := network.ServerOpts{
+			ID:         id,
+			Logger:     log.NewNopLogger(),
+			Transports: []network.Transport{tr},
+		}
+		if configure != nil {
+			configure(i, &opts)
+		}
+
+		s, err := network.NewServer(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := h.client.Connect(tr); err != nil {
+			return nil, err
+		}
+
+		h.Nodes = append(h.Nodes, &Node{
+			Server:    s,
+			Transport: tr,
+		})
+	}
+
+	return h, nil
+}
+
+// Connect connects node a and node b in both directions.
+func (h *Harness) Connect(a, b int) error {
+	if err := h.Nodes[a].Transport.Connect(h.Nodes[b].Transport); err != nil {
+		return err
+	}
+
+	return h.Nodes[b].Transport.Connect(h.Nodes[a].Transport)
+}
+
+// ConnectAll connects every node with every other node.
+func (h *Harness) ConnectAll() error {
+	for a := 0; a < len(h.Nodes); a++ {
+		for b := a + 1; b < len(h.Nodes); b++ {
+			if err := h.Connect(a, b); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *Harness) Start() {
+	for _, node := range h.Nodes {
+		go node.Server.Start()
+	}
+}
+
+func (h *Harness) Stop() {
+	for _, node := range h.Nodes {
+		node.Server.Stop()
+	}
+}
+
+// InjectTx sends the transaction to node i as if it came from a peer.
+func (h *Harness) InjectTx(i int, tx *core.Transaction) error {
+	buf := &bytes.Buffer{}
+	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+		return err
+	}
+
+	msg := network.NewMessage(network.MessageTypeTx, buf.Bytes())
+
+	return h.client.SendMessage(h.Nodes[i].Transport.Addr(), msg.Bytes())
+}
+
+// WaitFor polls cond until it returns true or the timeout expires.
+func (h *Harness) WaitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("condition not met within %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return nil
+}
+
+// WaitForTx waits until every node has the transaction in its mempool.
+func (h *Harness) WaitForTx(hash types.Hash, timeout time.Duration) error {
+	return h.WaitFor(timeout, func() bool {
+		for _, node := range h.Nodes {
+			if !node.Server.Mempool().Contains(hash) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// WaitForHeight waits until every node reached at least the given height.
+func (h *Harness) WaitForHeight(height uint32, timeout time.Duration) error {
+	return h.WaitFor(timeout, func() bool {
+		for _, node := range h.Nodes {
+			if node.Server.Chain().Height() < height {
+				return false
+			}
+		}
+		return true
+	})
+}
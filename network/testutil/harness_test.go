@@ -0,0 +1,135 @@
+package testutil
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/ayushn2/blockchainz/network"
+	"github.com/ayushn2/blockchainz/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHarnessPropagatesTransaction(t *testing.T) {
+	h, err := NewHarness(3, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, h.ConnectAll())
+
+	h.Start()
+	defer h.Stop()
+
+	tx := util.NewRandomTransactionWithSignature(t, crypto.GeneratePrivateKey(), 100)
+	assert.Nil(t, h.InjectTx(0, tx))
+	assert.Nil(t, h.WaitForTx(tx.Hash(core.TxHasher{}), 2*time.Second))
+}
+
+func TestHarnessDiscoversPeers(t *testing.T) {
+	h, err := NewHarness(3, nil)
+	assert.Nil(t, err)
+	// Node 0 only knows node 1, which is connected to node 2.
+	assert.Nil(t, h.Connect(0, 1))
+	assert.Nil(t, h.Connect(1, 2))
+
+	h.Start()
+	defer h.Stop()
+
+	connected := func(a, b int) bool {
+		for _, addr := range h.Nodes[a].Transport.Peers() {
+			if addr == h.Nodes[b].Transport.Addr() {
+				return true
+			}
+		}
+		return false
+	}
+
+	assert.Nil(t, h.WaitFor(2*time.Second, func() bool {
+		return connected(0, 2) && connected(2, 0)
+	}))
+
+	tx := util.NewRandomTransactionWithSignature(t, crypto.GeneratePrivateKey(), 100)
+	assert.Nil(t, h.InjectTx(2, tx))
+	assert.Nil(t, h.WaitForTx(tx.Hash(core.TxHasher{}), 2*time.Second))
+}
+
+func TestHarnessBroadcastsProducedBlocks(t *testing.T) {
+	h, err := NewHarness(3, func(i int, opts *network.ServerOpts) {
+		if i == 0 {
+			privKey := crypto.GeneratePrivateKey()
+			opts.PrivateKey = &privKey
+			opts.BlockTime = 50 * time.Millisecond
+		}
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, h.ConnectAll())
+
+	h.Start()
+	defer h.Stop()
+
+	assert.Nil(t, h.WaitForHeight(2, 3*time.Second))
+
+	header, err := h.Nodes[0].Server.Chain().GetHeader(2)
+	assert.Nil(t, err)
+	for _, node := range h.Nodes[1:] {
+		received, err := node.Server.Chain().GetHeader(2)
+		assert.Nil(t, err)
+		assert.Equal(t, core.BlockHasher{}.Hash(header), core.BlockHasher{}.Hash(received))
+	}
+}
+
+// recordingTransport records the peers each message type was sent to.
+type recordingTransport struct {
+	network.Transport
+
+	mu   sync.Mutex
+	sent map[network.MessageType]map[string]bool
+}
+
+func (t *recordingTransport) SendMessage(to net.Addr, payload []byte) error {
+	if msg, err := network.DecodeMessage(payload); err == nil {
+		t.mu.Lock()
+		if t.sent[msg.Header] == nil {
+			t.sent[msg.Header] = make(map[string]bool)
+		}
+		t.sent[msg.Header][to.String()] = true
+		t.mu.Unlock()
+	}
+
+	return t.Transport.SendMessage(to, payload)
+}
+
+func (t *recordingTransport) sentTo(typ network.MessageType) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.sent[typ])
+}
+
+func TestHarnessBroadcastFanout(t *testing.T) {
+	var recorder *recordingTransport
+	h, err := NewHarness(10, func(i int, opts *network.ServerOpts) {
+		if i == 0 {
+			recorder = &recordingTransport{
+				Transport: opts.Transports[0],
+				sent:      make(map[network.MessageType]map[string]bool),
+			}
+			opts.Transports = []network.Transport{recorder}
+			opts.BroadcastFanout = network.FanoutSqrt
+		}
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, h.ConnectAll())
+
+	h.Start()
+	defer h.Stop()
+
+	tx := util.NewRandomTransactionWithSignature(t, crypto.GeneratePrivateKey(), 100)
+	assert.Nil(t, h.InjectTx(0, tx))
+	assert.Nil(t, h.WaitForTx(tx.Hash(core.TxHasher{}), 2*time.Second))
+
+	// Node 0 announced the transaction to 3 of its 9 peers, the others
+	// learned about it from them.
+	assert.Equal(t, 3, recorder.sentTo(network.MessageTypeInv))
+}
@@ -4,10 +4,26 @@ import "net"
 
 type NetAddr string
 
+func (a NetAddr) Network() string {
+	return "local"
+}
+
+func (a NetAddr) String() string {
+	return string(a)
+}
+
 type Transport interface {
 	Consume() <-chan RPC
 	Connect(Transport) error
 	SendMessage(net.Addr, []byte) error
 	Broadcast([]byte) error
 	Addr() net.Addr
+	// Peers returns the addresses of the peers connected to the transport.
+	Peers() []net.Addr
+}
+
+// Dialer is implemented by transports that can connect to a peer knowing
+// only its address, it is used to connect to peers learned from others.
+type Dialer interface {
+	Dial(addr string) error
 }
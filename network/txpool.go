@@ -1,38 +1,192 @@
 package network
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ayushn2/blockchainz/core"
 	"github.com/ayushn2/blockchainz/types"
 )
 
+// ReplacementFeeBump is the percentage by which a transaction has to raise
+// the fee of the pending transaction it replaces.
+const ReplacementFeeBump = 10
+
+var ErrReplacementUnderpriced = errors.New("replacement transaction underpriced")
+
+// TxOrdering decides which pending transactions make it into a block when
+// they don't all fit.
+type TxOrdering byte
+
+const (
+	// OrderByArrival includes the transactions in the order they arrived.
+	OrderByArrival TxOrdering = iota
+	// OrderByFeePerByte includes the transactions paying the highest fee
+	// per byte first, so a large transaction doesn't crowd out several
+	// small ones paying more in total.
+	OrderByFeePerByte
+)
+
 type TxPool struct {
 	all     *TxSortedMap
 	pending *TxSortedMap
 	// The maxLength of the total pool of transactions.
 	// When the pool is full we will prune the oldest transaction.
 	maxLength int
+
+	// contents maps the content hash of every transaction in the pool to its
+	// hash, so a transaction arriving with another encoding is still found.
+	contentsLock sync.RWMutex
+	contents     map[types.Hash]types.Hash
+
+	// senders indexes the pending transactions by the address of their
+	// sender, in the order they were added.
+	sendersLock sync.RWMutex
+	senders     map[types.Address][]*core.Transaction
+
+	subsLock sync.RWMutex
+	subs     map[chan *core.Transaction]struct{}
+
+	hasher core.Hasher[*core.Transaction]
 }
 
 func NewTxPool(maxLength int) *TxPool {
+	return NewTxPoolWithHasher(maxLength, core.TxHasher{})
+}
+
+// NewTxPoolWithHasher creates a pool that identifies transactions by the
+// hashes of the given hasher, which should be the hasher of the chain.
+func NewTxPoolWithHasher(maxLength int, hasher core.Hasher[*core.Transaction]) *TxPool {
 	return &TxPool{
-		all:       NewTxSortedMap(),
-		pending:   NewTxSortedMap(),
+		all:       newTxSortedMap(hasher),
+		pending:   newTxSortedMap(hasher),
 		maxLength: maxLength,
+		hasher:    hasher,
+		contents:  make(map[types.Hash]types.Hash),
+		senders:   make(map[types.Address][]*core.Transaction),
+		subs:      make(map[chan *core.Transaction]struct{}),
 	}
 }
 
-func (p *TxPool) Add(tx *core.Transaction) {
+// Add adds the transaction to the pool, a transaction whose content is
+// already pooled is ignored. A transaction with the sender and nonce of a
+// pending one replaces it if it raises the fee by at least
+// ReplacementFeeBump percent, otherwise it is rejected.
+func (p *TxPool) Add(tx *core.Transaction) error {
+	if p.ContainsTx(tx) {
+		return nil
+	}
+
+	if old := p.replaced(tx); old != nil {
+		minFee := old.Fee + old.Fee*ReplacementFeeBump/100
+		if minFee == old.Fee {
+			minFee++
+		}
+		if tx.Fee < minFee {
+			return fmt.Errorf("%w: transaction (%s) pays (%d) => minimum (%d) to replace (%s)", ErrReplacementUnderpriced, tx.Hash(p.hasher), tx.Fee, minFee, old.Hash(p.hasher))
+		}
+
+		p.remove(old)
+	}
+
 	// prune the oldest transaction that is sitting in the all pool
 	if p.all.Count() == p.maxLength {
 		oldest := p.all.First()
-		p.all.Remove(oldest.Hash(core.TxHasher{}))
+		p.all.Remove(oldest.Hash(p.hasher))
+
+		p.contentsLock.Lock()
+		delete(p.contents, oldest.ContentHash())
+		p.contentsLock.Unlock()
+	}
+
+	p.all.Add(tx)
+	p.pending.Add(tx)
+
+	p.contentsLock.Lock()
+	p.contents[tx.ContentHash()] = tx.Hash(p.hasher)
+	p.contentsLock.Unlock()
+
+	// Transactions spending outputs have no sender account.
+	if sender := tx.Sender(); !sender.IsZero() {
+		p.sendersLock.Lock()
+		p.senders[sender] = append(p.senders[sender], tx)
+		p.sendersLock.Unlock()
 	}
 
-	if !p.all.Contains(tx.Hash(core.TxHasher{})) {
-		p.all.Add(tx)
-		p.pending.Add(tx)
+	p.notify(tx)
+
+	return nil
+}
+
+// replaced returns the pending transaction with the sender and nonce of the
+// given one, or nil if there is none.
+func (p *TxPool) replaced(tx *core.Transaction) *core.Transaction {
+	sender := tx.Sender()
+	if tx.Nonce == 0 || sender.IsZero() {
+		return nil
+	}
+
+	p.sendersLock.RLock()
+	defer p.sendersLock.RUnlock()
+
+	for _, pending := range p.senders[sender] {
+		if pending.Nonce == tx.Nonce {
+			return pending
+		}
+	}
+
+	return nil
+}
+
+// remove drops the transaction from the pool.
+func (p *TxPool) remove(tx *core.Transaction) {
+	// A pending transaction may have been pruned from the pool already.
+	if hash := tx.Hash(p.hasher); p.all.Contains(hash) {
+		p.all.Remove(hash)
+	}
+
+	p.contentsLock.Lock()
+	delete(p.contents, tx.ContentHash())
+	p.contentsLock.Unlock()
+
+	p.RemovePending([]*core.Transaction{tx})
+}
+
+// Subscribe returns a channel receiving every transaction added to the pool
+// and a function to cancel the subscription. A subscriber that falls more
+// than size transactions behind misses transactions instead of blocking the
+// pool.
+func (p *TxPool) Subscribe(size int) (<-chan *core.Transaction, func()) {
+	ch := make(chan *core.Transaction, size)
+
+	p.subsLock.Lock()
+	p.subs[ch] = struct{}{}
+	p.subsLock.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			p.subsLock.Lock()
+			delete(p.subs, ch)
+			p.subsLock.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+func (p *TxPool) notify(tx *core.Transaction) {
+	p.subsLock.RLock()
+	defer p.subsLock.RUnlock()
+
+	for ch := range p.subs {
+		select {
+		case ch <- tx:
+		default:
+		}
 	}
 }
 
@@ -40,29 +194,170 @@ func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
 
+// ContainsTx returns true if the pool holds the transaction, either with the
+// same hash or with the same content under another encoding.
+func (p *TxPool) ContainsTx(tx *core.Transaction) bool {
+	if p.all.Contains(tx.Hash(p.hasher)) {
+		return true
+	}
+
+	p.contentsLock.RLock()
+	hash, ok := p.contents[tx.ContentHash()]
+	p.contentsLock.RUnlock()
+
+	return ok && tx.Equal(p.all.Get(hash))
+}
+
+// Get returns the transaction with the given hash or nil if it is not in
+// the pool.
+func (p *TxPool) Get(hash types.Hash) *core.Transaction {
+	return p.all.Get(hash)
+}
+
 // Pending returns a slice of transactions that are in the pending pool
 func (p *TxPool) Pending() []*core.Transaction {
 	return p.pending.txx.Data
 }
 
+// BySender returns the pending transactions of the given sender in the order
+// they were added to the pool.
+func (p *TxPool) BySender(addr types.Address) []*core.Transaction {
+	p.sendersLock.RLock()
+	defer p.sendersLock.RUnlock()
+
+	txx := make([]*core.Transaction, len(p.senders[addr]))
+	copy(txx, p.senders[addr])
+
+	return txx
+}
+
+// Select returns the pending transactions for the next block in the given
+// ordering, with a total size of at most budget bytes. A transaction that
+// doesn't fit is skipped and smaller ones after it are still included. A
+// budget of zero selects all pending transactions.
+func (p *TxPool) Select(ordering TxOrdering, budget int) []*core.Transaction {
+	pending := p.Pending()
+
+	txx := make([]*core.Transaction, len(pending))
+	copy(txx, pending)
+
+	if ordering == OrderByFeePerByte {
+		sort.SliceStable(txx, func(i, j int) bool {
+			return txx[i].FeePerByte() > txx[j].FeePerByte()
+		})
+	}
+
+	if budget <= 0 {
+		return txx
+	}
+
+	selected := make([]*core.Transaction, 0, len(txx))
+	for _, tx := range txx {
+		if size := tx.Size(); size <= budget {
+			selected = append(selected, tx)
+			budget -= size
+		}
+	}
+
+	return selected
+}
+
+// RemovePending removes the given transactions from the pending pool, after
+// they were included in a block.
+func (p *TxPool) RemovePending(txx []*core.Transaction) {
+	removed := make(map[types.Hash]struct{}, len(txx))
+	for _, tx := range txx {
+		hash := tx.Hash(p.hasher)
+		if p.pending.Contains(hash) {
+			p.pending.Remove(hash)
+		}
+		removed[hash] = struct{}{}
+	}
+
+	p.sendersLock.Lock()
+	defer p.sendersLock.Unlock()
+
+	for sender, senderTxx := range p.senders {
+		kept := senderTxx[:0]
+		for _, tx := range senderTxx {
+			if _, ok := removed[tx.Hash(p.hasher)]; !ok {
+				kept = append(kept, tx)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(p.senders, sender)
+		} else {
+			p.senders[sender] = kept
+		}
+	}
+}
+
+// Reinject makes the transaction pending again after the block including it
+// was rolled back. A transaction still in the pool from before it was mined
+// only returns to the pending pool, any other transaction is added.
+func (p *TxPool) Reinject(tx *core.Transaction) {
+	hash := tx.Hash(p.hasher)
+	if !p.all.Contains(hash) {
+		p.contentsLock.RLock()
+		pooled, ok := p.contents[tx.ContentHash()]
+		p.contentsLock.RUnlock()
+
+		if !ok || !tx.Equal(p.all.Get(pooled)) {
+			p.Add(tx)
+			return
+		}
+		hash = pooled
+	}
+
+	if p.pending.Contains(hash) {
+		return
+	}
+
+	tx = p.all.Get(hash)
+	p.pending.Add(tx)
+
+	if sender := tx.Sender(); !sender.IsZero() {
+		p.sendersLock.Lock()
+		p.senders[sender] = append(p.senders[sender], tx)
+		p.sendersLock.Unlock()
+	}
+
+	p.notify(tx)
+}
+
 func (p *TxPool) ClearPending() {
 	p.pending.Clear()
+
+	p.sendersLock.Lock()
+	p.senders = make(map[types.Address][]*core.Transaction)
+	p.sendersLock.Unlock()
 }
 
 func (p *TxPool) PendingCount() int {
 	return p.pending.Count()
 }
 
+// TxSortedMap keeps the transactions in the order they were added. The
+// order doesn't depend on timestamps or on map iteration, so transactions
+// added together keep their order and blocks built from them are
+// reproducible.
 type TxSortedMap struct {
 	lock   sync.RWMutex
 	lookup map[types.Hash]*core.Transaction
 	txx    *types.List[*core.Transaction]
+	hasher core.Hasher[*core.Transaction]
 }
 
 func NewTxSortedMap() *TxSortedMap {
+	return newTxSortedMap(core.TxHasher{})
+}
+
+func newTxSortedMap(hasher core.Hasher[*core.Transaction]) *TxSortedMap {
 	return &TxSortedMap{
 		lookup: make(map[types.Hash]*core.Transaction),
 		txx:    types.NewList[*core.Transaction](),
+		hasher: hasher,
 	}
 }
 
@@ -71,7 +366,7 @@ func (t *TxSortedMap) First() *core.Transaction {
 	defer t.lock.RUnlock()
 
 	first := t.txx.Get(0)
-	return t.lookup[first.Hash(core.TxHasher{})]
+	return t.lookup[first.Hash(t.hasher)]
 }
 
 func (t *TxSortedMap) Get(h types.Hash) *core.Transaction {
@@ -82,7 +377,7 @@ func (t *TxSortedMap) Get(h types.Hash) *core.Transaction {
 }
 
 func (t *TxSortedMap) Add(tx *core.Transaction) {
-	hash := tx.Hash(core.TxHasher{})
+	hash := tx.Hash(t.hasher)
 
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -1,9 +1,13 @@
 package network
 
 import (
+	"bytes"
+	"crypto/elliptic"
+	"math/big"
 	"testing"
 
 	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/crypto"
 	"github.com/ayushn2/blockchainz/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -105,3 +109,237 @@ func TestTxSortedMapRemove(t *testing.T) {
 	assert.Equal(t, m.Count(), 0)
 	assert.False(t, m.Contains(tx.Hash(core.TxHasher{})))
 }
+
+func TestTxPoolSubscribe(t *testing.T) {
+	p := NewTxPool(10)
+	txs, cancel := p.Subscribe(1)
+
+	tx := util.NewRandomTransaction(100)
+	p.Add(tx)
+	p.Add(tx)
+	assert.Equal(t, tx, <-txs)
+	assert.Len(t, txs, 0)
+
+	cancel()
+	cancel()
+	p.Add(util.NewRandomTransaction(100))
+	_, ok := <-txs
+	assert.False(t, ok)
+}
+
+func TestTxPoolContainsTxByContent(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := core.NewTransaction([]byte("same content"))
+	assert.Nil(t, tx.Sign(privKey))
+
+	// Negating S gives another valid signature, so the same logical
+	// transaction gets a new encoding.
+	other := core.NewTransaction([]byte("same content"))
+	other.From = tx.From
+	other.Signature = &crypto.Signature{
+		R: tx.Signature.R,
+		S: new(big.Int).Sub(elliptic.P256().Params().N, tx.Signature.S),
+	}
+	assert.Nil(t, other.Verify())
+
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(core.NewGobTxEncoder(a)))
+	assert.Nil(t, other.Encode(core.NewGobTxEncoder(b)))
+	assert.NotEqual(t, a.Bytes(), b.Bytes())
+
+	decoded := new(core.Transaction)
+	assert.Nil(t, decoded.Decode(core.NewGobTxDecoder(b)))
+
+	p := NewTxPool(10)
+	p.Add(tx)
+	assert.True(t, p.ContainsTx(decoded))
+
+	p.Add(decoded)
+	assert.Equal(t, 1, p.PendingCount())
+
+	// The same content from another sender is a different transaction.
+	fromOther := core.NewTransaction([]byte("same content"))
+	assert.Nil(t, fromOther.Sign(crypto.GeneratePrivateKey()))
+	assert.False(t, p.ContainsTx(fromOther))
+}
+
+func TestTxPoolBySender(t *testing.T) {
+	p := NewTxPool(10)
+	alice, bob := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+
+	signed := func(key crypto.PrivateKey, data string) *core.Transaction {
+		tx := core.NewTransaction([]byte(data))
+		assert.Nil(t, tx.Sign(key))
+		return tx
+	}
+
+	a1, b1, a2 := signed(alice, "a1"), signed(bob, "b1"), signed(alice, "a2")
+	p.Add(a1)
+	p.Add(b1)
+	p.Add(a2)
+	p.Add(a1)
+
+	assert.Equal(t, []*core.Transaction{a1, a2}, p.BySender(alice.PublicKey().Address()))
+	assert.Equal(t, []*core.Transaction{b1}, p.BySender(bob.PublicKey().Address()))
+	assert.Empty(t, p.BySender(crypto.GeneratePrivateKey().PublicKey().Address()))
+
+	p.ClearPending()
+	assert.Empty(t, p.BySender(alice.PublicKey().Address()))
+}
+
+func TestTxPoolReplaceByFee(t *testing.T) {
+	p := NewTxPool(10)
+	key := crypto.GeneratePrivateKey()
+
+	signed := func(nonce, fee uint64) *core.Transaction {
+		tx := &core.Transaction{Data: []byte("bump"), Nonce: nonce, Fee: fee}
+		assert.Nil(t, tx.Sign(key))
+		return tx
+	}
+
+	original := signed(1, 100)
+	assert.Nil(t, p.Add(original))
+
+	// A bump below ReplacementFeeBump percent is rejected.
+	assert.ErrorIs(t, p.Add(signed(1, 109)), ErrReplacementUnderpriced)
+	assert.True(t, p.Contains(original.Hash(core.TxHasher{})))
+
+	replacement := signed(1, 110)
+	assert.Nil(t, p.Add(replacement))
+	assert.False(t, p.Contains(original.Hash(core.TxHasher{})))
+	assert.False(t, p.ContainsTx(original))
+	assert.Equal(t, []*core.Transaction{replacement}, p.Pending())
+	assert.Equal(t, []*core.Transaction{replacement}, p.BySender(key.PublicKey().Address()))
+
+	// Another nonce or no nonce doesn't replace anything.
+	assert.Nil(t, p.Add(signed(2, 1)))
+	assert.Nil(t, p.Add(signed(0, 1)))
+	assert.Nil(t, p.Add(signed(0, 2)))
+	assert.Equal(t, 4, p.PendingCount())
+
+	// Even a zero fee has to be raised.
+	free := signed(3, 0)
+	assert.Nil(t, p.Add(free))
+	other := &core.Transaction{Data: []byte("other"), Nonce: 3}
+	assert.Nil(t, other.Sign(key))
+	assert.ErrorIs(t, p.Add(other), ErrReplacementUnderpriced)
+	assert.Nil(t, p.Add(signed(3, 1)))
+	assert.False(t, p.ContainsTx(free))
+}
+
+func TestTxPoolReinject(t *testing.T) {
+	p := NewTxPool(10)
+	key := crypto.GeneratePrivateKey()
+
+	mined := core.NewTransaction([]byte("mined"))
+	assert.Nil(t, mined.Sign(key))
+	p.Add(mined)
+	p.RemovePending([]*core.Transaction{mined})
+	assert.Equal(t, 0, p.PendingCount())
+
+	// Adding it again is ignored, it is still pooled.
+	p.Add(mined)
+	assert.Equal(t, 0, p.PendingCount())
+
+	p.Reinject(mined)
+	p.Reinject(mined)
+	assert.Equal(t, []*core.Transaction{mined}, p.Pending())
+	assert.Equal(t, []*core.Transaction{mined}, p.BySender(key.PublicKey().Address()))
+
+	// A transaction the pool never saw is added.
+	other := util.NewRandomTransaction(10)
+	p.Reinject(other)
+	assert.Equal(t, 2, p.PendingCount())
+	assert.True(t, p.Contains(other.Hash(core.TxHasher{})))
+}
+
+func TestTxPoolSelectByFeePerByte(t *testing.T) {
+	p := NewTxPool(10)
+	privKey := crypto.GeneratePrivateKey()
+
+	newTx := func(dataSize int, fee uint64) *core.Transaction {
+		tx := util.NewRandomTransaction(dataSize)
+		tx.Fee = fee
+		assert.Nil(t, tx.Sign(privKey))
+		return tx
+	}
+
+	// The large transaction pays the highest fee but the least per byte.
+	large := newTx(300, 60)
+	small := []*core.Transaction{newTx(10, 40), newTx(10, 40), newTx(10, 40)}
+	p.Add(large)
+	for _, tx := range small {
+		p.Add(tx)
+	}
+
+	budget := 10
+	for _, tx := range small {
+		budget += tx.Size()
+	}
+	assert.Greater(t, large.Size()+small[0].Size(), budget)
+
+	totalFee := func(txx []*core.Transaction) (fee uint64, size int) {
+		for _, tx := range txx {
+			fee += tx.Fee
+			size += tx.Size()
+		}
+		return fee, size
+	}
+
+	// The best total fee of any subset fitting into the budget.
+	all := append([]*core.Transaction{large}, small...)
+	var best uint64
+	for mask := 0; mask < 1<<len(all); mask++ {
+		var subset []*core.Transaction
+		for i, tx := range all {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, tx)
+			}
+		}
+		if fee, size := totalFee(subset); size <= budget && fee > best {
+			best = fee
+		}
+	}
+
+	selected := p.Select(OrderByFeePerByte, budget)
+	assert.ElementsMatch(t, small, selected)
+	fee, size := totalFee(selected)
+	assert.Equal(t, best, fee)
+	assert.LessOrEqual(t, size, budget)
+
+	// In arrival order the large transaction takes up the budget.
+	assert.Equal(t, []*core.Transaction{large}, p.Select(OrderByArrival, budget))
+
+	// Without a budget all transactions are selected.
+	assert.Len(t, p.Select(OrderByFeePerByte, 0), 4)
+	assert.Equal(t, large, p.Select(OrderByFeePerByte, 0)[3])
+
+	p.RemovePending(selected)
+	assert.Equal(t, 1, p.PendingCount())
+	assert.Equal(t, []*core.Transaction{large}, p.BySender(privKey.PublicKey().Address()))
+	assert.True(t, p.Contains(small[0].Hash(core.TxHasher{})))
+}
+
+func TestTxPoolSelectOrderIsStable(t *testing.T) {
+	txx := make([]*core.Transaction, 50)
+	for i := range txx {
+		// Same size, fee and timestamp, the transactions only differ in
+		// their data. They are unsigned, signatures differ in size.
+		tx := core.NewTransaction([]byte{byte(i)})
+		tx.Fee = 10
+		tx.Timestamp = 1
+		txx[i] = tx
+	}
+
+	for run := 0; run < 5; run++ {
+		p := NewTxPool(len(txx))
+		for _, tx := range txx {
+			p.Add(tx)
+		}
+
+		assert.Equal(t, txx, p.Pending())
+		for _, ordering := range []TxOrdering{OrderByArrival, OrderByFeePerByte} {
+			assert.Equal(t, txx, p.Select(ordering, 0))
+		}
+	}
+}
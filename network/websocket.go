@@ -0,0 +1,221 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsClientBuffer is the number of events queued for a client, a client
+	// that falls further behind is disconnected.
+	wsClientBuffer = 64
+	wsTxBuffer     = 256
+)
+
+// WSEvent is pushed as JSON to the websocket clients, Data holds a WSBlock or
+// a WSTransaction depending on the Type.
+type WSEvent struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+type WSBlock struct {
+	Hash          string   `json:"hash"`
+	Height        uint32   `json:"height"`
+	PrevBlockHash string   `json:"prevBlockHash"`
+	Timestamp     int64    `json:"timestamp"`
+	GasUsed       uint64   `json:"gasUsed"`
+	Validator     string   `json:"validator"`
+	Transactions  []string `json:"transactions"`
+}
+
+type WSTransaction struct {
+	Hash  string `json:"hash"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to"`
+	Value uint64 `json:"value"`
+	Fee   uint64 `json:"fee"`
+	Data  []byte `json:"data"`
+}
+
+func newWSBlock(b *core.Block, blockHasher core.Hasher[*core.Header], txHasher core.Hasher[*core.Transaction]) WSBlock {
+	wsb := WSBlock{
+		Hash:          b.Hash(blockHasher).String(),
+		Height:        b.Height,
+		PrevBlockHash: b.PrevBlockHash.String(),
+		Timestamp:     b.Timestamp,
+		GasUsed:       b.GasUsed,
+		Transactions:  make([]string, len(b.Transactions)),
+	}
+	if !b.Validator.IsZero() {
+		wsb.Validator = b.Validator.Address().String()
+	}
+
+	for i, tx := range b.Transactions {
+		wsb.Transactions[i] = tx.Hash(txHasher).String()
+	}
+
+	return wsb
+}
+
+func newWSTransaction(tx *core.Transaction, hasher core.Hasher[*core.Transaction]) WSTransaction {
+	wstx := WSTransaction{
+		Hash:  tx.Hash(hasher).String(),
+		To:    tx.To.String(),
+		Value: tx.Value,
+		Fee:   tx.Fee,
+		Data:  tx.Data,
+	}
+	if !tx.From.IsZero() {
+		wstx.From = tx.Sender().String()
+	}
+
+	return wstx
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// wsServer streams new blocks and mempool transactions to websocket clients.
+type wsServer struct {
+	logger      log.Logger
+	upgrader    websocket.Upgrader
+	blockHasher core.Hasher[*core.Header]
+	txHasher    core.Hasher[*core.Transaction]
+
+	lock    sync.Mutex
+	clients map[*wsClient]struct{}
+	closed  bool
+}
+
+func newWSServer(logger log.Logger, blockHasher core.Hasher[*core.Header], txHasher core.Hasher[*core.Transaction]) *wsServer {
+	return &wsServer{
+		logger:      logger,
+		blockHasher: blockHasher,
+		txHasher:    txHasher,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		clients: make(map[*wsClient]struct{}),
+	}
+}
+
+func (ws *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ws.logger.Log("msg", "websocket upgrade failed", "err", err)
+		return
+	}
+
+	c := &wsClient{
+		conn: conn,
+		send: make(chan []byte, wsClientBuffer),
+	}
+
+	ws.lock.Lock()
+	if ws.closed {
+		ws.lock.Unlock()
+		conn.Close()
+		return
+	}
+	ws.clients[c] = struct{}{}
+	ws.lock.Unlock()
+
+	go ws.writeLoop(c)
+
+	// Clients only listen, reading is needed to notice they went away.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	ws.remove(c)
+}
+
+func (ws *wsServer) writeLoop(c *wsClient) {
+	for msg := range c.send {
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			ws.remove(c)
+			break
+		}
+	}
+
+	// Drain the queue so a publish never blocks on a client that is gone.
+	for range c.send {
+	}
+}
+
+// remove disconnects the client, it is safe to call more than once.
+func (ws *wsServer) remove(c *wsClient) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if _, ok := ws.clients[c]; !ok {
+		return
+	}
+
+	delete(ws.clients, c)
+	close(c.send)
+	c.conn.Close()
+}
+
+func (ws *wsServer) publish(eventType string, data any) {
+	msg, err := json.Marshal(WSEvent{Type: eventType, Data: data})
+	if err != nil {
+		ws.logger.Log("msg", "cannot encode websocket event", "err", err)
+		return
+	}
+
+	ws.lock.Lock()
+	var slow []*wsClient
+	for c := range ws.clients {
+		select {
+		case c.send <- msg:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	ws.lock.Unlock()
+
+	for _, c := range slow {
+		ws.remove(c)
+	}
+}
+
+func (ws *wsServer) PublishBlock(b *core.Block) {
+	ws.publish("block", newWSBlock(b, ws.blockHasher, ws.txHasher))
+}
+
+func (ws *wsServer) PublishTx(tx *core.Transaction) {
+	ws.publish("tx", newWSTransaction(tx, ws.txHasher))
+}
+
+func (ws *wsServer) clientCount() int {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	return len(ws.clients)
+}
+
+// Close disconnects all clients, new clients are refused afterwards.
+func (ws *wsServer) Close() {
+	ws.lock.Lock()
+	ws.closed = true
+	clients := make([]*wsClient, 0, len(ws.clients))
+	for c := range ws.clients {
+		clients = append(clients, c)
+	}
+	ws.lock.Unlock()
+
+	for _, c := range clients {
+		ws.remove(c)
+	}
+}
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayushn2/blockchainz/core"
+	"github.com/ayushn2/blockchainz/crypto"
+	"github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebsocketPushesEvents(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(ServerOpts{
+		ID:           "NODE",
+		Logger:       log.NewNopLogger(),
+		BlockTime:    time.Hour,
+		PrivateKey:   &privKey,
+		WSListenAddr: "127.0.0.1:0",
+	})
+	assert.Nil(t, err)
+	defer s.Stop()
+
+	ts := httptest.NewServer(s.ws)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return s.ws.clientCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, s.createNewBlock())
+
+	var blockEvent struct {
+		Type string  `json:"type"`
+		Data WSBlock `json:"data"`
+	}
+	assert.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	assert.Nil(t, conn.ReadJSON(&blockEvent))
+	assert.Equal(t, "block", blockEvent.Type)
+	assert.Equal(t, uint32(1), blockEvent.Data.Height)
+	assert.Equal(t, privKey.PublicKey().Address().String(), blockEvent.Data.Validator)
+
+	tx := core.NewTransaction([]byte("websocket"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, s.processTransaction(tx))
+
+	var txEvent struct {
+		Type string        `json:"type"`
+		Data WSTransaction `json:"data"`
+	}
+	assert.Nil(t, conn.ReadJSON(&txEvent))
+	assert.Equal(t, "tx", txEvent.Type)
+	assert.Equal(t, tx.Hash(core.TxHasher{}).String(), txEvent.Data.Hash)
+
+	// A client going away is removed from the server.
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return s.ws.clientCount() == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -29,4 +29,14 @@ func AddressFromBytes(b []byte) Address {
 	}
 
 	return a
-}
\ No newline at end of file
+}
+// ZeroAddress returns the address with all bytes zero. No key maps to it, so
+// it stands for "no account": coinbase transactions have no sender and
+// value sent to the zero address can never be spent again.
+func ZeroAddress() Address {
+	return Address{}
+}
+
+func (a Address) IsZero() bool {
+	return a == ZeroAddress()
+}
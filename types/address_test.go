@@ -0,0 +1,19 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressIsZero(t *testing.T) {
+	assert.True(t, ZeroAddress().IsZero())
+	assert.True(t, Address{}.IsZero())
+
+	a := AddressFromBytes(bytes.Repeat([]byte{0}, 20))
+	assert.True(t, a.IsZero())
+
+	a[19] = 1
+	assert.False(t, a.IsZero())
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// MaxBloomFilterSize is the largest filter, in bytes, that is accepted
+	// when decoding.
+	MaxBloomFilterSize = 1 << 20
+	// MaxBloomHashes is the largest number of hash functions of a filter.
+	MaxBloomHashes = 32
+)
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter")
+
+// BloomFilter is a probabilistic set of hashes. Contains never misses a
+// hash that was added, but may report hashes that weren't, so a peer can
+// tell what it certainly doesn't have before asking for it. The filter is
+// exchanged between peers in its binary encoding.
+type BloomFilter struct {
+	bits []byte
+	k    uint32
+}
+
+// NewBloomFilter returns a filter sized to hold capacity hashes with the
+// given false positive rate.
+func NewBloomFilter(capacity int, falsePositiveRate float64) *BloomFilter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	m := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	size := int(math.Ceil(m / 8))
+	if size > MaxBloomFilterSize {
+		size = MaxBloomFilterSize
+	}
+
+	k := uint32(math.Round(float64(size*8) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > MaxBloomHashes {
+		k = MaxBloomHashes
+	}
+
+	return &BloomFilter{
+		bits: make([]byte, size),
+		k:    k,
+	}
+}
+
+// Add adds the hash to the filter. The zero filter has no bits to set, use
+// NewBloomFilter.
+func (f *BloomFilter) Add(h Hash) {
+	if len(f.bits) == 0 {
+		return
+	}
+
+	m, h1, h2 := f.positions(h)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains returns false if the hash was certainly not added, true if it
+// may have been.
+func (f *BloomFilter) Contains(h Hash) bool {
+	if len(f.bits) == 0 {
+		return false
+	}
+
+	m, h1, h2 := f.positions(h)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// positions returns the number of bits and the two values the bit positions
+// of the hash are derived from. Hashes are uniformly distributed already,
+// their first bytes serve as the two hash functions of double hashing.
+func (f *BloomFilter) positions(h Hash) (m, h1, h2 uint64) {
+	m = uint64(len(f.bits)) * 8
+	h1 = binary.BigEndian.Uint64(h[0:8])
+	// An odd step visits different bits for every hash function.
+	h2 = binary.BigEndian.Uint64(h[8:16]) | 1
+
+	return m, h1, h2
+}
+
+// MarshalBinary encodes the filter as the number of hash functions, 4 bytes
+// big endian, followed by the bits.
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 4+len(f.bits))
+	binary.BigEndian.PutUint32(b, f.k)
+	copy(b[4:], f.bits)
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes a filter encoded with MarshalBinary.
+func (f *BloomFilter) UnmarshalBinary(b []byte) error {
+	if len(b) < 5 {
+		return fmt.Errorf("%w: (%d) bytes", ErrInvalidBloomFilter, len(b))
+	}
+
+	if size := len(b) - 4; size > MaxBloomFilterSize {
+		return fmt.Errorf("%w: (%d) bytes => maximum (%d)", ErrInvalidBloomFilter, size, MaxBloomFilterSize)
+	}
+
+	k := binary.BigEndian.Uint32(b)
+	if k < 1 || k > MaxBloomHashes {
+		return fmt.Errorf("%w: (%d) hash functions => maximum (%d)", ErrInvalidBloomFilter, k, MaxBloomHashes)
+	}
+
+	f.k = k
+	f.bits = append([]byte{}, b[4:]...)
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bloomTestHash(i int) Hash {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(i))
+	return Hash(sha256.Sum256(b[:]))
+}
+
+func TestBloomFilterNoFalseNegatives(t *testing.T) {
+	f := NewBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(bloomTestHash(i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.Contains(bloomTestHash(i)))
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	const capacity = 10000
+	f := NewBloomFilter(capacity, 0.01)
+	for i := 0; i < capacity; i++ {
+		f.Add(bloomTestHash(i))
+	}
+
+	falsePositives := 0
+	for i := capacity; i < 2*capacity; i++ {
+		if f.Contains(bloomTestHash(i)) {
+			falsePositives++
+		}
+	}
+
+	// Allow some slack above the 1% the filter is sized for.
+	assert.Less(t, float64(falsePositives)/capacity, 0.02)
+}
+
+func TestBloomFilterEncoding(t *testing.T) {
+	f := NewBloomFilter(100, 0.01)
+	for i := 0; i < 100; i++ {
+		f.Add(bloomTestHash(i))
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(f))
+
+	decoded := new(BloomFilter)
+	assert.Nil(t, gob.NewDecoder(buf).Decode(decoded))
+	assert.Equal(t, f, decoded)
+	for i := 0; i < 100; i++ {
+		assert.True(t, decoded.Contains(bloomTestHash(i)))
+	}
+
+	for _, b := range [][]byte{
+		nil,
+		{0, 0, 0, 1},
+		{0, 0, 0, 0, 0xff},
+		{0, 0, 0, MaxBloomHashes + 1, 0xff},
+		make([]byte, 4+MaxBloomFilterSize+1),
+	} {
+		assert.ErrorIs(t, new(BloomFilter).UnmarshalBinary(b), ErrInvalidBloomFilter)
+	}
+}
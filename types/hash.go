@@ -3,10 +3,17 @@ package types
 import (
 	"encoding/hex"
 	"fmt"
+	"math/bits"
 )
 
 type Hash [32]uint8
 
+// ZeroHash returns the hash with all bytes zero, used for "no hash" like the
+// previous block hash of the genesis block.
+func ZeroHash() Hash {
+	return Hash{}
+}
+
 func (h Hash) IsZero() bool {
 	for i := 0; i < 32; i++ {
 		if h[i] != 0 {
@@ -16,6 +23,28 @@ func (h Hash) IsZero() bool {
 	return true
 }
 
+// Xor returns the bitwise xor of the hashes, the distance of two node ids
+// in a Kademlia style routing table.
+func (h Hash) Xor(other Hash) Hash {
+	var out Hash
+	for i := 0; i < 32; i++ {
+		out[i] = h[i] ^ other[i]
+	}
+	return out
+}
+
+// LeadingZeros returns the number of leading zero bits of the hash, 256 for
+// the zero hash. For a distance it is the length of the common prefix of the
+// two ids.
+func (h Hash) LeadingZeros() int {
+	for i := 0; i < 32; i++ {
+		if h[i] != 0 {
+			return i*8 + bits.LeadingZeros8(h[i])
+		}
+	}
+	return 256
+}
+
 func (h Hash) ToSlice() []byte {
 	b := make([]byte, 32)
 	for i := 0; i < 32; i++ {
@@ -41,3 +70,17 @@ func HashFromBytes(b []byte) Hash {
 
 	return Hash(value)
 }
+
+// HashFromString parses the hex encoded hash returned by String.
+func HashFromString(s string) (Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Hash{}, fmt.Errorf("invalid hash (%s): %w", s, err)
+	}
+
+	if len(b) != 32 {
+		return Hash{}, fmt.Errorf("invalid hash (%s): given bytes with length %d should be 32", s, len(b))
+	}
+
+	return HashFromBytes(b), nil
+}
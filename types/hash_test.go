@@ -0,0 +1,68 @@
+package types
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashFromString(t *testing.T) {
+	h := Hash(sha256.Sum256([]byte("foo")))
+
+	parsed, err := HashFromString(h.String())
+	assert.Nil(t, err)
+	assert.Equal(t, h, parsed)
+}
+
+func TestHashFromStringWrongLength(t *testing.T) {
+	_, err := HashFromString("abcd")
+	assert.NotNil(t, err)
+
+	_, err = HashFromString(strings.Repeat("ab", 33))
+	assert.NotNil(t, err)
+}
+
+func TestHashFromStringNotHex(t *testing.T) {
+	_, err := HashFromString(strings.Repeat("zz", 32))
+	assert.NotNil(t, err)
+}
+
+func TestZeroHash(t *testing.T) {
+	assert.True(t, ZeroHash().IsZero())
+	assert.False(t, Hash(sha256.Sum256([]byte("foo"))).IsZero())
+}
+
+func TestHashXor(t *testing.T) {
+	a, err := HashFromString("ff00ff00000000000000000000000000000000000000000000000000000000aa")
+	assert.Nil(t, err)
+	b, err := HashFromString("0f0f0000000000000000000000000000000000000000000000000000000000ff")
+	assert.Nil(t, err)
+
+	want, err := HashFromString("f00fff0000000000000000000000000000000000000000000000000000000055")
+	assert.Nil(t, err)
+	assert.Equal(t, want, a.Xor(b))
+	assert.Equal(t, want, b.Xor(a))
+	assert.True(t, a.Xor(a).IsZero())
+	assert.Equal(t, a, a.Xor(Hash{}))
+}
+
+func TestHashLeadingZeros(t *testing.T) {
+	assert.Equal(t, 256, Hash{}.LeadingZeros())
+	assert.Equal(t, 0, Hash{0x80}.LeadingZeros())
+	assert.Equal(t, 7, Hash{0x01}.LeadingZeros())
+	assert.Equal(t, 12, Hash{0x00, 0x0f}.LeadingZeros())
+
+	var last Hash
+	last[31] = 0x01
+	assert.Equal(t, 255, last.LeadingZeros())
+}
+
+func TestHashXorAllocations(t *testing.T) {
+	a, b := Hash{0x01}, Hash{0x10}
+	allocs := testing.AllocsPerRun(100, func() {
+		a.Xor(b).LeadingZeros()
+	})
+	assert.Equal(t, float64(0), allocs)
+}
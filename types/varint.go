@@ -0,0 +1,69 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxVarintLen is the maximum length of an encoded uvarint, enough for any
+// uint64. Longer encodings are rejected when reading.
+const MaxVarintLen = binary.MaxVarintLen64
+
+var (
+	ErrVarintTooLong    = errors.New("varint too long")
+	ErrVarintNotMinimal = errors.New("varint not minimally encoded")
+)
+
+// PutUvarint encodes v into buf and returns the number of bytes written.
+// buf must be at least MaxVarintLen bytes long, it panics otherwise.
+func PutUvarint(buf []byte, v uint64) int {
+	return binary.PutUvarint(buf, v)
+}
+
+// AppendUvarint appends the encoding of v to b and returns the extended
+// slice.
+func AppendUvarint(b []byte, v uint64) []byte {
+	var buf [MaxVarintLen]byte
+	n := PutUvarint(buf[:], v)
+
+	return append(b, buf[:n]...)
+}
+
+// ReadUvarint reads an uvarint from r. It reads at most MaxVarintLen bytes,
+// so a peer can't make it consume an endless run of continuation bytes, and
+// it rejects values overflowing an uint64 and encodings with trailing zero
+// bytes, so every value has exactly one encoding.
+func ReadUvarint(r io.ByteReader) (uint64, error) {
+	var (
+		v     uint64
+		shift uint
+	)
+
+	for i := 0; i < MaxVarintLen; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		if i == MaxVarintLen-1 && b > 1 {
+			return 0, fmt.Errorf("%w: value overflows 64 bits", ErrVarintTooLong)
+		}
+
+		if b < 0x80 {
+			if b == 0 && i > 0 {
+				return 0, fmt.Errorf("%w: trailing zero byte at (%d)", ErrVarintNotMinimal, i)
+			}
+			return v | uint64(b)<<shift, nil
+		}
+
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+
+	return 0, fmt.Errorf("%w: more than (%d) bytes", ErrVarintTooLong, MaxVarintLen)
+}
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUvarintRoundTrip(t *testing.T) {
+	values := []uint64{0, 1, 127, 128, 255, 16383, 16384, math.MaxUint32, math.MaxUint64 - 1, math.MaxUint64}
+
+	for _, v := range values {
+		b := AppendUvarint(nil, v)
+		assert.LessOrEqual(t, len(b), MaxVarintLen)
+
+		buf := make([]byte, MaxVarintLen)
+		assert.Equal(t, len(b), PutUvarint(buf, v))
+		assert.Equal(t, b, buf[:len(b)])
+
+		r := bytes.NewReader(b)
+		decoded, err := ReadUvarint(r)
+		assert.Nil(t, err)
+		assert.Equal(t, v, decoded)
+		assert.Equal(t, 0, r.Len())
+	}
+
+	assert.Len(t, AppendUvarint(nil, 127), 1)
+	assert.Len(t, AppendUvarint(nil, 128), 2)
+	assert.Len(t, AppendUvarint(nil, math.MaxUint64), MaxVarintLen)
+}
+
+func TestReadUvarintTooLong(t *testing.T) {
+	// Continuation bits beyond the maximum length.
+	_, err := ReadUvarint(bytes.NewReader(bytes.Repeat([]byte{0xff}, MaxVarintLen+1)))
+	assert.ErrorIs(t, err, ErrVarintTooLong)
+
+	// Ten bytes, but the last one overflows 64 bits.
+	overflow := append(bytes.Repeat([]byte{0xff}, MaxVarintLen-1), 0x02)
+	_, err = ReadUvarint(bytes.NewReader(overflow))
+	assert.ErrorIs(t, err, ErrVarintTooLong)
+}
+
+func TestReadUvarintNotMinimal(t *testing.T) {
+	_, err := ReadUvarint(bytes.NewReader([]byte{0x80, 0x00}))
+	assert.ErrorIs(t, err, ErrVarintNotMinimal)
+
+	_, err = ReadUvarint(bytes.NewReader([]byte{0x81, 0x80, 0x00}))
+	assert.ErrorIs(t, err, ErrVarintNotMinimal)
+}
+
+func TestReadUvarintTruncated(t *testing.T) {
+	_, err := ReadUvarint(bytes.NewReader(nil))
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ReadUvarint(bytes.NewReader([]byte{0x80}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}